@@ -0,0 +1,216 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package httpmetrics
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+// clientMetrics are the metrics of requests with the same host and method.
+type clientMetrics struct {
+	requests *metrics.Counter
+	errors   *metrics.Counter
+	status   [len(statusClasses)]*metrics.Counter
+	latency  metrics.Histogram
+	dns      metrics.Histogram
+	connect  metrics.Histogram
+	tls      metrics.Histogram
+	ttfb     metrics.Histogram
+	newConns *metrics.Counter
+	reused   *metrics.Counter
+	// Totals for the reuse ratio which mustn't be affected by latched
+	// reporters resetting the counters
+	newTotal    atomic.Uint64
+	reusedTotal atomic.Uint64
+}
+
+// newClientMetrics gets or adds the metrics for a route of the form
+// "METHOD host" in the scope host/METHOD, or OtherRoute in its own scope.
+func newClientMetrics(registry metrics.Registry, route string, newHistogram func() metrics.Histogram) *clientMetrics {
+	var r metrics.Registry
+	var tags metrics.AddOption
+	if method, host, ok := strings.Cut(route, " "); ok {
+		r = registry.Scope(host).Scope(method)
+		tags = metrics.WithTags(map[string]string{"host": host, "method": method})
+	} else {
+		r = registry.Scope(route)
+		tags = metrics.WithTags(map[string]string{"host": route})
+	}
+	histogram := func(name, description string) metrics.Histogram {
+		return getOrAddHistogram(r, name, newHistogram, tags,
+			metrics.WithDescription(description), metrics.WithUnit(metrics.UnitMicroseconds))
+	}
+	m := &clientMetrics{
+		requests: getOrAdd(r, "requests", metrics.NewCounter, tags,
+			metrics.WithDescription("Number of requests")),
+		errors: getOrAdd(r, "errors", metrics.NewCounter, tags,
+			metrics.WithDescription("Number of requests that failed without a response")),
+		status:  getOrAddStatus(r, tags),
+		latency: histogram("latency", "Time until the response headers were received"),
+		dns:     histogram("dns", "Time taken by DNS lookups"),
+		connect: histogram("connect", "Time taken to connect"),
+		tls:     histogram("tls_handshake", "Time taken by TLS handshakes"),
+		ttfb:    histogram("time_to_first_byte", "Time until the first byte of the response was received"),
+		newConns: getOrAdd(r, "connections/new", metrics.NewCounter, tags,
+			metrics.WithDescription("Number of requests that used a new connection")),
+		reused: getOrAdd(r, "connections/reused", metrics.NewCounter, tags,
+			metrics.WithDescription("Number of requests that reused a connection")),
+	}
+	metrics.GetOrAdd(r, "connections/reuse_ratio", func() any {
+		return metrics.GaugeFunc(m.reuseRatio)
+	}, tags, metrics.WithDescription("Fraction of requests that reused a connection"), metrics.WithUnit(metrics.UnitRatio))
+	return m
+}
+
+func (m *clientMetrics) reuseRatio() float64 {
+	reused := m.reusedTotal.Load()
+	total := reused + m.newTotal.Load()
+	if total == 0 {
+		return 0
+	}
+	return float64(reused) / float64(total)
+}
+
+type transport struct {
+	next   http.RoundTripper
+	routes *routeTable[clientMetrics]
+}
+
+// NewTransport returns a RoundTripper that records the following metrics
+// for each host and method before passing requests on to next, or
+// http.DefaultTransport if it's nil:
+//
+//	requests               number of requests (counter)
+//	errors                 number of requests that failed without a response (counter)
+//	status/1xx..5xx        number of responses by status class (counters)
+//	latency                time until the response headers were received (histogram)
+//	dns                    time taken by DNS lookups (histogram)
+//	connect                time taken to connect (histogram)
+//	tls_handshake          time taken by TLS handshakes (histogram)
+//	time_to_first_byte     time until the first response byte was received (histogram)
+//	connections/new        requests that used a new connection (counter)
+//	connections/reused     requests that reused a connection (counter)
+//	connections/reuse_ratio  fraction of all requests that reused a connection (gauge)
+//
+// Times are in microseconds. The metrics are in the scope host/METHOD of
+// the registry, for instance example.com:8080/GET/latency, and have the
+// host and method as tags. Pass a scope of a registry such as
+// registry.Scope("client") to keep them apart from other metrics.
+//
+// The breakdown of a request's time is gathered with httptrace, after any
+// trace that's already in the request's context. Connections that are
+// dialed by one request may be used by another so the time taken to
+// connect isn't always part of the latency of the same request.
+//
+// WithMaxRoutes bounds the number of host and method pairs, and
+// WithHistogram sets the factory of the histograms. Like those of
+// NewHandler the metrics are exempt from the registry's TTL.
+func NewTransport(registry metrics.Registry, next http.RoundTripper, opts ...Option) http.RoundTripper {
+	c := newConfig(opts)
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{
+		next: next,
+		routes: newRouteTable(c.maxRoutes, func(_, route string) *clientMetrics {
+			return newClientMetrics(registry, route, c.newHistogram)
+		}),
+	}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route := req.Method + " " + req.URL.Host
+	m := t.routes.get(route, route)
+	start := time.Now()
+	ct := &clientTrace{m: m, start: start}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), ct.trace()))
+	res, err := t.next.RoundTrip(req)
+	m.requests.Inc(1)
+	m.latency.Update(time.Since(start).Microseconds())
+	if err != nil {
+		m.errors.Inc(1)
+		return res, err
+	}
+	m.status[statusClass(res.StatusCode)].Inc(1)
+	return res, err
+}
+
+// clientTrace records the phases of a request. Its hooks may be called
+// concurrently when the transport dials several addresses.
+type clientTrace struct {
+	m            *clientMetrics
+	start        time.Time
+	mu           sync.Mutex
+	dnsStart     time.Time
+	connectStart map[string]time.Time
+	tlsStart     time.Time
+}
+
+func (c *clientTrace) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			c.mu.Lock()
+			c.dnsStart = time.Now()
+			c.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			c.mu.Lock()
+			start := c.dnsStart
+			c.mu.Unlock()
+			if !start.IsZero() {
+				c.m.dns.Update(time.Since(start).Microseconds())
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			c.mu.Lock()
+			if c.connectStart == nil {
+				c.connectStart = make(map[string]time.Time)
+			}
+			c.connectStart[network+" "+addr] = time.Now()
+			c.mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			c.mu.Lock()
+			start, ok := c.connectStart[network+" "+addr]
+			c.mu.Unlock()
+			if ok && err == nil {
+				c.m.connect.Update(time.Since(start).Microseconds())
+			}
+		},
+		TLSHandshakeStart: func() {
+			c.mu.Lock()
+			c.tlsStart = time.Now()
+			c.mu.Unlock()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			c.mu.Lock()
+			start := c.tlsStart
+			c.mu.Unlock()
+			if !start.IsZero() && err == nil {
+				c.m.tls.Update(time.Since(start).Microseconds())
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				c.m.reused.Inc(1)
+				c.m.reusedTotal.Add(1)
+			} else {
+				c.m.newConns.Inc(1)
+				c.m.newTotal.Add(1)
+			}
+		},
+		GotFirstResponseByte: func() {
+			c.m.ttfb.Update(time.Since(c.start).Microseconds())
+		},
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package httpmetrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+func TestTransportTTL(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+	reg := metrics.NewRegistry(metrics.WithTTL(time.Millisecond))
+	client := &http.Client{Transport: NewTransport(reg, nil)}
+	for i := range 2 {
+		// The first walk notices the request and the second would expire
+		// the idle route
+		for range i * 2 {
+			time.Sleep(time.Millisecond * 5)
+			reg.Do(func(string, any) error { return nil })
+		}
+		res, err := client.Get(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if c := counter(t, reg, s.Listener.Addr().String()+"/GET/requests"); c != 2 {
+		t.Fatalf("Expected 2 requests after the TTL. Got %d", c)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer s.Close()
+	host := s.Listener.Addr().String()
+
+	reg := metrics.NewRegistry()
+	client := &http.Client{Transport: NewTransport(reg.Scope("client"), s.Client().Transport)}
+	for _, method := range []string{"GET", "GET", "POST"} {
+		req, _ := http.NewRequest(method, s.URL, nil)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+
+	prefix := "client/" + host + "/"
+	expected := map[string]uint64{
+		"GET/requests":            2,
+		"GET/errors":              0,
+		"GET/status/2xx":          2,
+		"GET/connections/new":     1,
+		"GET/connections/reused":  1,
+		"POST/requests":           1,
+		"POST/status/4xx":         1,
+		"POST/connections/reused": 1,
+	}
+	for name, exp := range expected {
+		if c := counter(t, reg, prefix+name); c != exp {
+			t.Fatalf("Expected %s to be %d. Got %d", name, exp, c)
+		}
+	}
+	metrics.Visit(reg, func(name string, m any, md metrics.Metadata) error {
+		switch name {
+		case prefix + "GET/tls_handshake", prefix + "GET/connect":
+			if c := m.(metrics.Histogram).Distribution().Count; c != 1 {
+				t.Fatalf("Expected 1 value for %s. Got %d", name, c)
+			}
+		case prefix + "GET/latency", prefix + "GET/time_to_first_byte":
+			if c := m.(metrics.Histogram).Distribution().Count; c != 2 {
+				t.Fatalf("Expected 2 values for %s. Got %d", name, c)
+			}
+		case prefix + "GET/connections/reuse_ratio":
+			if v := m.(metrics.GaugeMetric).Value(); v != 0.5 {
+				t.Fatalf("Expected reuse ratio of 0.5. Got %f", v)
+			}
+			if exp := map[string]string{"host": host, "method": "GET"}; !reflect.DeepEqual(md.Tags, exp) {
+				t.Fatalf("Expected tags %v. Got %v", exp, md.Tags)
+			}
+		}
+		return nil
+	})
+
+	// Requests that fail count as errors
+	s.Close()
+	if _, err := client.Get(s.URL); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if c := counter(t, reg, prefix+"GET/errors"); c != 1 {
+		t.Fatalf("Expected 1 error. Got %d", c)
+	}
+}
+
+func TestTransportMaxRoutes(t *testing.T) {
+	reg := metrics.NewRegistry()
+	rt := NewTransport(reg, roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}), WithMaxRoutes(2))
+	for _, host := range []string{"a", "b", "c", "a"} {
+		if _, err := rt.RoundTrip(&http.Request{Method: "GET", URL: &url.URL{Scheme: "http", Host: host}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exp, scopes := []string{"a", "b", "other"}, reg.(metrics.ScopesRegistry).Scopes(); !reflect.DeepEqual(scopes, exp) {
+		t.Fatalf("Expected scopes %v. Got %v", exp, scopes)
+	}
+	if c := counter(t, reg, "a/GET/requests"); c != 2 {
+		t.Fatalf("Expected 2 requests for a. Got %d", c)
+	}
+}
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
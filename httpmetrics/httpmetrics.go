@@ -0,0 +1,175 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+// Package httpmetrics instruments net/http servers and clients.
+package httpmetrics
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+// DefaultMaxRoutes is the number of routes that are tracked separately
+// unless changed with WithMaxRoutes.
+const DefaultMaxRoutes = 100
+
+const (
+	// OtherRoute is the route of requests beyond the maximum number of
+	// routes.
+	OtherRoute = "other"
+	// UnmatchedRoute is the route of requests that don't match a pattern.
+	UnmatchedRoute = "unmatched"
+)
+
+// statusClasses are the names of the counters of responses by the first
+// digit of their status code. Codes outside 100-599 count as 5xx.
+var statusClasses = [...]string{"status/1xx", "status/2xx", "status/3xx", "status/4xx", "status/5xx"}
+
+func statusClass(code int) int {
+	if code < 100 || code >= 600 {
+		return 4
+	}
+	return code/100 - 1
+}
+
+// routeMetrics are the metrics of a single route.
+type routeMetrics struct {
+	requests     *metrics.Counter
+	inFlight     *metrics.IntegerGauge
+	latency      metrics.Histogram
+	responseSize *metrics.Distribution
+	status       [len(statusClasses)]*metrics.Counter
+}
+
+// record records a finished request.
+func (m *routeMetrics) record(status int, size int64, latencyUs int64) {
+	m.requests.Inc(1)
+	m.latency.Update(latencyUs)
+	m.responseSize.Update(float64(size))
+	m.status[statusClass(status)].Inc(1)
+}
+
+// routeTable maps routes to their metrics. Routes are keyed by the name of
+// their scope and given to newMetrics along with that name. It holds at
+// most max routes and uses OtherRoute for the rest so that the number of
+// metrics is bounded no matter what the routes are.
+type routeTable[T any] struct {
+	max        int
+	newMetrics func(name, route string) *T
+	mu         sync.RWMutex
+	routes     map[string]*T
+}
+
+func newRouteTable[T any](max int, newMetrics func(name, route string) *T) *routeTable[T] {
+	return &routeTable[T]{
+		max:        max,
+		newMetrics: newMetrics,
+		routes:     make(map[string]*T),
+	}
+}
+
+func (t *routeTable[T]) get(name, route string) *T {
+	t.mu.RLock()
+	m := t.routes[name]
+	t.mu.RUnlock()
+	if m != nil {
+		return m
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if m := t.routes[name]; m != nil {
+		return m
+	}
+	if len(t.routes) >= t.max && name != OtherRoute {
+		name, route = OtherRoute, OtherRoute
+		if m := t.routes[name]; m != nil {
+			return m
+		}
+	}
+	m = t.newMetrics(name, route)
+	t.routes[name] = m
+	return m
+}
+
+// newRouteMetrics gets or adds the metrics of a route in the scope name.
+// The route is also set as the route tag for reporters that support tags.
+func newRouteMetrics(registry metrics.Registry, name, route string, newHistogram func() metrics.Histogram) *routeMetrics {
+	r := registry.Scope(name)
+	tags := metrics.WithTags(map[string]string{"route": route})
+	return &routeMetrics{
+		requests: getOrAdd(r, "requests", metrics.NewCounter, tags,
+			metrics.WithDescription("Number of requests")),
+		inFlight: getOrAdd(r, "in_flight", metrics.NewIntegerGauge, tags,
+			metrics.WithDescription("Number of requests in progress")),
+		responseSize: getOrAdd(r, "response_size", metrics.NewDistribution, tags,
+			metrics.WithDescription("Size of response bodies"), metrics.WithUnit(metrics.UnitBytes)),
+		latency: getOrAddHistogram(r, "latency", newHistogram, tags,
+			metrics.WithDescription("Time taken by requests"), metrics.WithUnit(metrics.UnitMicroseconds)),
+		status: getOrAddStatus(r, tags),
+	}
+}
+
+// getOrAddStatus gets or adds the counters of responses by status class.
+func getOrAddStatus(r metrics.Registry, tags metrics.AddOption) [len(statusClasses)]*metrics.Counter {
+	var status [len(statusClasses)]*metrics.Counter
+	for i, name := range statusClasses {
+		status[i] = getOrAdd(r, name, metrics.NewCounter, tags,
+			metrics.WithDescription("Number of responses with a "+strings.TrimPrefix(name, "status/")+" status"))
+	}
+	return status
+}
+
+// getOrAdd gets or adds a metric. If a metric with the same name but a
+// different type already exists it logs the error and returns a new one
+// that isn't part of the registry rather than failing requests. The metric
+// is exempt from the registry's TTL since the route table keeps using it.
+func getOrAdd[T any](r metrics.Registry, name string, factory func() T, opts ...metrics.AddOption) T {
+	m, err := metrics.GetOrAddMetric(r, name, factory, append(opts, metrics.WithoutExpiry())...)
+	if err != nil {
+		log.Printf("metrics/httpmetrics: not recording %s: %s", name, err)
+		return factory()
+	}
+	return m
+}
+
+// getOrAddHistogram is like getOrAdd for histograms.
+func getOrAddHistogram(r metrics.Registry, name string, factory func() metrics.Histogram, opts ...metrics.AddOption) metrics.Histogram {
+	h, err := metrics.GetOrAddHistogram(r, name, factory, append(opts, metrics.WithoutExpiry())...)
+	if err != nil {
+		log.Printf("metrics/httpmetrics: not recording %s: %s", name, err)
+		return factory()
+	}
+	return h
+}
+
+// routeName turns a route such as "GET /users/{id}" into the name of a
+// scope: GET/_users_{id}. The space after a method becomes a slash so that
+// every method has its own scope, and the slashes of the path become
+// underscores. Percent signs and underscores are percent-encoded as in
+// URLs so that no two routes get the same name. For the same reason the
+// first letter of a route named OtherRoute or UnmatchedRoute is encoded.
+func routeName(route string) string {
+	if route == OtherRoute || route == UnmatchedRoute {
+		return fmt.Sprintf("%%%02X", route[0]) + route[1:]
+	}
+	var b strings.Builder
+	for i := 0; i < len(route); i++ {
+		switch c := route[i]; c {
+		case '%', '_':
+			fmt.Fprintf(&b, "%%%02X", c)
+		case '/':
+			b.WriteByte('_')
+		case ' ':
+			b.WriteByte('/')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,273 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package httpmetrics
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+// Option configures the instrumentation.
+type Option func(c *config)
+
+type config struct {
+	maxRoutes    int
+	newHistogram func() metrics.Histogram
+	route        func(r *http.Request) string
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		maxRoutes:    DefaultMaxRoutes,
+		newHistogram: metrics.NewDefaultBucketedHistogram,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithMaxRoutes sets the number of routes that are tracked separately.
+// Requests for routes after the first max are recorded as OtherRoute.
+func WithMaxRoutes(max int) Option {
+	return func(c *config) {
+		c.maxRoutes = max
+	}
+}
+
+// WithHistogram sets the factory of the latency histograms. The default is
+// metrics.NewDefaultBucketedHistogram.
+func WithHistogram(factory func() metrics.Histogram) Option {
+	return func(c *config) {
+		c.newHistogram = factory
+	}
+}
+
+// WithRoute sets the function that returns the route of a request. It's
+// called before the request is handled. Since the number of routes is
+// bounded it should return a pattern rather than something like the path.
+// It only applies to NewHandler.
+func WithRoute(f func(r *http.Request) string) Option {
+	return func(c *config) {
+		c.route = f
+	}
+}
+
+// router is implemented by *http.ServeMux.
+type router interface {
+	Handler(r *http.Request) (h http.Handler, pattern string)
+}
+
+type handler struct {
+	next   http.Handler
+	route  func(r *http.Request) string
+	routes *routeTable[routeMetrics]
+}
+
+// NewHandler returns a handler that records the following metrics for
+// each route before passing requests on to next:
+//
+//	requests         number of requests (counter)
+//	in_flight        number of requests in progress (gauge)
+//	latency          time until the handler returned in microseconds (histogram)
+//	response_size    bytes written in response bodies (distribution)
+//	status/1xx..5xx  number of responses by status class (counters)
+//
+// The metrics of a route are in a scope of the registry named after it, so
+// the route "GET /users/{id}" becomes GET/_users_{id}/requests, and also
+// have the route as their route tag. They're exempt from the registry's
+// TTL, if it has one, since the handler keeps them for as long as it's
+// used. WithMaxRoutes bounds their number instead.
+//
+// The route is the ServeMux pattern that matches the request. If next is a
+// *http.ServeMux the pattern it will use is looked up, otherwise the
+// Pattern of the request is used which is set when the handler is
+// registered with a ServeMux itself. Requests without a pattern have the
+// route UnmatchedRoute.
+//
+// The ResponseWriter passed to next implements http.Flusher,
+// http.Hijacker and io.ReaderFrom if the original does. Hijacked
+// connections record the status that was written before hijacking or 101
+// Switching Protocols.
+func NewHandler(registry metrics.Registry, next http.Handler, opts ...Option) http.Handler {
+	c := newConfig(opts)
+	h := &handler{
+		next:  next,
+		route: c.route,
+		routes: newRouteTable(c.maxRoutes, func(name, route string) *routeMetrics {
+			return newRouteMetrics(registry, name, route, c.newHistogram)
+		}),
+	}
+	if h.route == nil {
+		h.route = requestPattern
+		if mux, ok := next.(router); ok {
+			h.route = func(r *http.Request) string {
+				_, pattern := mux.Handler(r)
+				return pattern
+			}
+		}
+	}
+	return h
+}
+
+func requestPattern(r *http.Request) string {
+	return r.Pattern
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var m *routeMetrics
+	if route := h.route(r); route != "" {
+		m = h.routes.get(routeName(route), route)
+	} else {
+		m = h.routes.get(UnmatchedRoute, UnmatchedRoute)
+	}
+
+	start := time.Now()
+	m.inFlight.Inc(1)
+	rw := &responseWriter{ResponseWriter: w}
+	completed := false
+	defer func() {
+		m.inFlight.Dec(1)
+		status := rw.status
+		if status == 0 {
+			switch {
+			case rw.hijacked:
+				status = http.StatusSwitchingProtocols
+			case !completed:
+				// The handler panicked and the server will abort the
+				// response
+				status = http.StatusInternalServerError
+			default:
+				status = http.StatusOK
+			}
+		}
+		m.record(status, rw.size, time.Since(start).Microseconds())
+	}()
+	h.next.ServeHTTP(wrapWriter(w, rw), r)
+	completed = true
+}
+
+// responseWriter records the status and size of a response.
+type responseWriter struct {
+	http.ResponseWriter
+	status   int
+	size     int64
+	hijacked bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	// Informational headers may be followed by another status
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Unwrap returns the original ResponseWriter for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseWriter) flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *responseWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (w *responseWriter) readFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.(io.ReaderFrom).ReadFrom(r)
+	w.size += n
+	return n, err
+}
+
+type flusherFunc func()
+
+func (f flusherFunc) Flush() { f() }
+
+type hijackerFunc func() (net.Conn, *bufio.ReadWriter, error)
+
+func (f hijackerFunc) Hijack() (net.Conn, *bufio.ReadWriter, error) { return f() }
+
+type readerFromFunc func(r io.Reader) (int64, error)
+
+func (f readerFromFunc) ReadFrom(r io.Reader) (int64, error) { return f(r) }
+
+// wrapWriter returns rw along with whichever of the optional interfaces
+// the original ResponseWriter implements.
+func wrapWriter(w http.ResponseWriter, rw *responseWriter) http.ResponseWriter {
+	_, isFlusher := w.(http.Flusher)
+	_, isHijacker := w.(http.Hijacker)
+	_, isReaderFrom := w.(io.ReaderFrom)
+	f, h, rf := flusherFunc(rw.flush), hijackerFunc(rw.hijack), readerFromFunc(rw.readFrom)
+	switch {
+	case isFlusher && isHijacker && isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{rw, f, h, rf}
+	case isFlusher && isHijacker:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+		}{rw, f, h}
+	case isFlusher && isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{rw, f, rf}
+	case isHijacker && isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{rw, h, rf}
+	case isFlusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+		}{rw, f}
+	case isHijacker:
+		return struct {
+			*responseWriter
+			http.Hijacker
+		}{rw, h}
+	case isReaderFrom:
+		return struct {
+			*responseWriter
+			io.ReaderFrom
+		}{rw, rf}
+	}
+	return rw
+}
@@ -0,0 +1,271 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package httpmetrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+func counter(t *testing.T, r metrics.Registry, name string) uint64 {
+	t.Helper()
+	var count uint64
+	found := false
+	r.Do(func(n string, m any) error {
+		if n == name {
+			count, found = m.(metrics.CounterMetric).Count(), true
+		}
+		return nil
+	})
+	if !found {
+		t.Fatalf("Expected metric %s", name)
+	}
+	return count
+}
+
+func TestHandlerTTL(t *testing.T) {
+	reg := metrics.NewRegistry(metrics.WithTTL(time.Millisecond))
+	reg.Add("idle", metrics.NewCounter())
+	h := NewHandler(reg, http.NotFoundHandler())
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	// The first walk notices the request and the second finds the route
+	// idle
+	found := false
+	for range 2 {
+		time.Sleep(time.Millisecond * 5)
+		found = false
+		reg.Do(func(name string, m any) error {
+			found = found || name == "idle"
+			return nil
+		})
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if found {
+		t.Fatal("Expected the idle counter to expire")
+	}
+	if c := counter(t, reg, "unmatched/requests"); c != 2 {
+		t.Fatalf("Expected 2 requests after the TTL. Got %d", c)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "user "+r.PathValue("id"))
+	})
+	mux.HandleFunc("POST /users", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	reg := metrics.NewRegistry()
+	h := NewHandler(reg.Scope("http"), mux)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/users/1", nil),
+		httptest.NewRequest("GET", "/users/2", nil),
+		httptest.NewRequest("POST", "/users", nil),
+		httptest.NewRequest("GET", "/missing", nil),
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expected := map[string]uint64{
+		"http/GET/_users_{id}/requests":   2,
+		"http/GET/_users_{id}/status/2xx": 2,
+		"http/GET/_users_{id}/status/4xx": 0,
+		"http/POST/_users/requests":       1,
+		"http/POST/_users/status/2xx":     1,
+		"http/unmatched/requests":         1,
+		"http/unmatched/status/4xx":       1,
+	}
+	for name, exp := range expected {
+		if c := counter(t, reg, name); c != exp {
+			t.Fatalf("Expected %s to be %d. Got %d", name, exp, c)
+		}
+	}
+
+	metrics.Visit(reg, func(name string, m any, md metrics.Metadata) error {
+		switch name {
+		case "http/GET/_users_{id}/response_size":
+			if v := m.(*metrics.Distribution).Value(); v.Count != 2 || v.Sum != 12 {
+				t.Fatalf("Expected 2 responses of 12 bytes. Got %+v", v)
+			}
+			if exp := map[string]string{"route": "GET /users/{id}"}; !reflect.DeepEqual(md.Tags, exp) {
+				t.Fatalf("Expected tags %v. Got %v", exp, md.Tags)
+			}
+		case "http/POST/_users/latency":
+			if c := m.(metrics.Histogram).Distribution().Count; c != 1 {
+				t.Fatalf("Expected 1 latency. Got %d", c)
+			}
+		case "http/POST/_users/in_flight":
+			if v := m.(*metrics.IntegerGauge).IntegerValue(); v != 0 {
+				t.Fatalf("Expected nothing in flight. Got %d", v)
+			}
+		}
+		return nil
+	})
+}
+
+func TestHandlerRegisteredWithMux(t *testing.T) {
+	reg := metrics.NewRegistry()
+	mux := http.NewServeMux()
+	mux.Handle("GET /items/{id}", NewHandler(reg, http.NotFoundHandler()))
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/1", nil))
+	if c := counter(t, reg, "GET/_items_{id}/status/4xx"); c != 1 {
+		t.Fatalf("Expected 1 not found. Got %d", c)
+	}
+}
+
+func TestHandlerMaxRoutes(t *testing.T) {
+	reg := metrics.NewRegistry()
+	h := NewHandler(reg, http.NotFoundHandler(), WithMaxRoutes(2), WithRoute(func(r *http.Request) string {
+		return r.URL.Path
+	}))
+	for _, path := range []string{"/a", "/b", "/c", "/d", "/a"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if exp, scopes := []string{"_a", "_b", "other"}, reg.(metrics.ScopesRegistry).Scopes(); !reflect.DeepEqual(scopes, exp) {
+		t.Fatalf("Expected scopes %v. Got %v", exp, scopes)
+	}
+	if c := counter(t, reg, "other/requests"); c != 2 {
+		t.Fatalf("Expected 2 other requests. Got %d", c)
+	}
+	if c := counter(t, reg, "_a/requests"); c != 2 {
+		t.Fatalf("Expected 2 requests for /a. Got %d", c)
+	}
+}
+
+func TestRouteName(t *testing.T) {
+	names := make(map[string]string)
+	for route, exp := range map[string]string{
+		"GET /users/{id}": "GET/_users_{id}",
+		"/":               "_",
+		"GET /a/b":        "GET/_a_b",
+		"GET /a_b":        "GET/_a%5Fb",
+		"GET/a":           "GET_a",
+		"GET /a%5Fb":      "GET/_a%255Fb",
+		"other":           "%6Fther",
+		"unmatched":       "%75nmatched",
+	} {
+		name := routeName(route)
+		if name != exp {
+			t.Errorf("Expected %q for %q. Got %q", exp, route, name)
+		}
+		if prev, ok := names[name]; ok {
+			t.Errorf("Expected %q and %q to have different names. Both got %q", prev, route, name)
+		}
+		names[name] = route
+	}
+}
+
+func TestHandlerReservedRoutes(t *testing.T) {
+	reg := metrics.NewRegistry()
+	route := ""
+	h := NewHandler(reg, http.NotFoundHandler(), WithMaxRoutes(3), WithRoute(func(*http.Request) string {
+		return route
+	}))
+	for _, route = range []string{"", "unmatched", "other", "/a", "/b"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	for name, exp := range map[string]uint64{
+		"unmatched/requests":   1,
+		"%75nmatched/requests": 1,
+		"%6Fther/requests":     1,
+		"other/requests":       2,
+	} {
+		if c := counter(t, reg, name); c != exp {
+			t.Fatalf("Expected %s to be %d. Got %d", name, exp, c)
+		}
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	reg := metrics.NewRegistry()
+	h := NewHandler(reg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}), WithRoute(func(*http.Request) string { return "/" }))
+	func() {
+		defer func() {
+			if r := recover(); r != http.ErrAbortHandler {
+				t.Fatalf("Expected the panic to be passed on. Got %v", r)
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	if c := counter(t, reg, "_/status/5xx"); c != 1 {
+		t.Fatalf("Expected a 5xx. Got %d", c)
+	}
+}
+
+func TestHandlerWriterInterfaces(t *testing.T) {
+	reg := metrics.NewRegistry()
+	var flusher, hijacker, readerFrom bool
+	h := NewHandler(reg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flusher = w.(http.Flusher)
+		_, readerFrom = w.(io.ReaderFrom)
+		if r.URL.Path == "/hijack" {
+			var hj http.Hijacker
+			if hj, hijacker = w.(http.Hijacker); hijacker {
+				conn, buf, err := hj.Hijack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+				buf.Flush()
+				conn.Close()
+			}
+			return
+		}
+		w.(io.ReaderFrom).ReadFrom(strings.NewReader("hello"))
+		w.(http.Flusher).Flush()
+	}), WithRoute(func(r *http.Request) string { return r.URL.Path }))
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != "hello" || !flusher || !readerFrom {
+		t.Fatalf("Expected hello from a Flusher and ReaderFrom. Got %q %t %t", b, flusher, readerFrom)
+	}
+	res, err = http.Get(s.URL + "/hijack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if !hijacker {
+		t.Fatal("Expected a Hijacker")
+	}
+	s.Close()
+
+	metrics.Visit(reg, func(name string, m any, _ metrics.Metadata) error {
+		if name == "read/response_size" {
+			if v := m.(*metrics.Distribution).Value(); v.Sum != 5 {
+				t.Fatalf("Expected 5 bytes from ReadFrom. Got %+v", v)
+			}
+		}
+		return nil
+	})
+	if c := counter(t, reg, "_hijack/status/1xx"); c != 1 {
+		t.Fatalf("Expected hijacked request to be 1xx. Got %d", c)
+	}
+
+	// ResponseRecorder is only a Flusher
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hijack", nil))
+	if !flusher || hijacker || readerFrom {
+		t.Fatalf("Expected only a Flusher. Got %t %t %t", flusher, hijacker, readerFrom)
+	}
+}
@@ -20,6 +20,16 @@ func (v CounterValue) Count() uint64 {
 	return uint64(v)
 }
 
+// Float64CounterValue is a running total that isn't a whole number, such
+// as the CPU time used by the process in seconds. Snapshots and exporters
+// treat it as a counter. Its Value method makes it a GaugeMetric for code
+// that doesn't know about it.
+type Float64CounterValue float64
+
+func (v Float64CounterValue) Value() float64 {
+	return float64(v)
+}
+
 type CounterFunc func() uint64
 
 func (f CounterFunc) Count() uint64 {
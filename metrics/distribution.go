@@ -26,15 +26,56 @@ func (v DistributionValue) Mean() float64 {
 	return 0.0
 }
 
+// Stat returns a single summary statistic.
+func (v DistributionValue) Stat(s Stats) float64 {
+	switch s {
+	case StatCount:
+		return float64(v.Count)
+	case StatSum:
+		return v.Sum
+	case StatMin:
+		return v.Min
+	case StatMax:
+		return v.Max
+	case StatMean:
+		return v.Mean()
+	case StatStdDev:
+		return math.Sqrt(v.Variance)
+	}
+	return 0.0
+}
+
 type DistributionMetric interface {
 	Value() DistributionValue
 }
 
+// variance is a running variance using Welford's method.
 type variance struct {
 	m float64
 	s float64
 }
 
+// update adds value which is the count'th data point.
+func (v *variance) update(value float64, count uint64) {
+	if count == 1 {
+		*v = variance{m: value, s: 0}
+		return
+	}
+	newM := v.m + ((value - v.m) / float64(count))
+	*v = variance{
+		m: newM,
+		s: v.s + ((value - v.m) * (value - newM)),
+	}
+}
+
+// value returns the sample variance of count data points.
+func (v *variance) value(count uint64) float64 {
+	if count > 1 {
+		return v.s / float64(count-1)
+	}
+	return 0.0
+}
+
 // Distribution tracks the min, max, sum, count, and variance/stddev of a set of values.
 type Distribution struct {
 	count    uint64
@@ -77,7 +118,7 @@ func (d *Distribution) Reset() {
 	d.sum = 0
 	d.min = math.Inf(1)
 	d.max = math.Inf(-1)
-	d.variance = variance{m: -1, s: 0}
+	d.variance = variance{}
 	d.mu.Unlock()
 }
 
@@ -92,15 +133,7 @@ func (d *Distribution) Update(value float64) {
 	if value > d.max {
 		d.max = value
 	}
-	if d.variance.m == -1 {
-		d.variance = variance{m: value, s: 0}
-	} else {
-		newM := d.variance.m + ((value - d.variance.m) / float64(d.count))
-		d.variance = variance{
-			m: newM,
-			s: d.variance.s + ((value - d.variance.m) * (value - newM)),
-		}
-	}
+	d.variance.update(value, d.count)
 	d.mu.Unlock()
 }
 
@@ -156,10 +189,7 @@ func (d *Distribution) Mean() float64 {
 // Variance returns the variance of all data points
 func (d *Distribution) Variance() float64 {
 	d.mu.Lock()
-	v := 0.0
-	if d.count > 1 {
-		v = d.variance.s / float64(d.count-1)
-	}
+	v := d.variance.value(d.count)
 	d.mu.Unlock()
 	return v
 }
@@ -172,14 +202,13 @@ func (d *Distribution) StdDev() float64 {
 func (d *Distribution) Value() DistributionValue {
 	d.mu.Lock()
 	v := DistributionValue{
-		Count: d.count,
-		Sum:   d.sum,
-		Min:   d.min,
-		Max:   d.max,
+		Count:    d.count,
+		Sum:      d.sum,
+		Min:      d.min,
+		Max:      d.max,
+		Variance: d.variance.value(d.count),
 	}
-	if d.count > 1 {
-		v.Variance = d.variance.s / float64(d.count-1)
-	} else if d.count == 0 {
+	if d.count == 0 {
 		v.Min = 0.0
 		v.Max = 0.0
 	}
@@ -0,0 +1,155 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"strings"
+)
+
+// RegistryVar is an expvar.Var of the metrics in a registry. Its value is
+// read from the registry whenever it's formatted so it always reflects the
+// metrics that are currently registered. Use a scope of a registry to only
+// include its metrics.
+type RegistryVar struct {
+	registry Registry
+}
+
+var _ expvar.Var = &RegistryVar{}
+
+// NewRegistryVar returns a variable of the metrics in the registry.
+func NewRegistryVar(registry Registry) *RegistryVar {
+	return &RegistryVar{registry}
+}
+
+// PublishRegistry publishes the metrics in the registry as an expvar
+// variable with the given name. Like expvar.Publish it panics if the name
+// is already in use.
+func PublishRegistry(name string, registry Registry) *RegistryVar {
+	v := NewRegistryVar(registry)
+	expvar.Publish(name, v)
+	return v
+}
+
+// String returns the metrics as a JSON object of their names and values
+// as served by RegistryHandler.
+func (v *RegistryVar) String() string {
+	b := &strings.Builder{}
+	writeJSON(b, v.registry)
+	return b.String()
+}
+
+// DefaultExpvarExclude are the expvar variables that NewExpvarCollection
+// doesn't import unless patterns are given. memstats stops the world to
+// read and is better provided by NewRuntimeCollection, and cmdline isn't
+// a metric.
+var DefaultExpvarExclude = []string{"memstats", "cmdline"}
+
+// ExpvarCollection is a Collection of the variables published with the
+// expvar package, such as those of third party libraries.
+type ExpvarCollection struct {
+	globs    [][]string
+	counters [][]string
+}
+
+// NewExpvarCollection returns a collection of the expvar variables whose
+// name matches one of the glob patterns (as used by IncludeGlob), or all
+// of them other than DefaultExpvarExclude if none are given. Variables
+// that are a RegistryVar are never included since their metrics are
+// already in a registry.
+//
+// Variables are converted to metrics as follows:
+//
+//   - *expvar.Int and *expvar.Float are gauges unless they're named by
+//     WithCounters. An Int can be decremented so it isn't necessarily a
+//     counter.
+//   - *expvar.Map is flattened by adding its keys to its name after a
+//     slash, so the key b of the map a becomes a/b, and its values are
+//     converted the same way.
+//   - Other variables, including expvar.Func, are parsed as JSON. Numbers
+//     are converted like floats, objects are flattened like maps, and
+//     anything else is skipped.
+func NewExpvarCollection(patterns ...string) *ExpvarCollection {
+	return &ExpvarCollection{globs: compileGlobs(patterns)}
+}
+
+// WithCounters makes the numbers whose metric name, after maps are
+// flattened, matches one of the glob patterns counters rather than gauges.
+// Ints become a CounterValue and floats a Float64CounterValue, unless
+// they're negative. It returns the collection so it can be called on
+// the result of NewExpvarCollection.
+func (c *ExpvarCollection) WithCounters(patterns ...string) *ExpvarCollection {
+	c.counters = append(c.counters, compileGlobs(patterns)...)
+	return c
+}
+
+func (c *ExpvarCollection) include(name string) bool {
+	if len(c.globs) == 0 {
+		for _, ex := range DefaultExpvarExclude {
+			if name == ex {
+				return false
+			}
+		}
+		return true
+	}
+	return matchAnyGlob(c.globs, name)
+}
+
+func (c *ExpvarCollection) Metrics() map[string]any {
+	out := make(map[string]any)
+	expvar.Do(func(kv expvar.KeyValue) {
+		if c.include(kv.Key) {
+			c.addExpvar(out, kv.Key, kv.Value)
+		}
+	})
+	return out
+}
+
+func (c *ExpvarCollection) addExpvar(out map[string]any, name string, v expvar.Var) {
+	switch v := v.(type) {
+	case *RegistryVar:
+	case *expvar.Int:
+		if n := v.Value(); n >= 0 && c.isCounter(name) {
+			out[name] = CounterValue(n)
+		} else {
+			out[name] = GaugeValue(n)
+		}
+	case *expvar.Float:
+		c.addFloat(out, name, v.Value())
+	case *expvar.Map:
+		v.Do(func(kv expvar.KeyValue) {
+			c.addExpvar(out, name+"/"+kv.Key, kv.Value)
+		})
+	default:
+		var value any
+		if err := json.Unmarshal([]byte(v.String()), &value); err == nil {
+			c.addJSONValue(out, name, value)
+		}
+	}
+}
+
+func (c *ExpvarCollection) addJSONValue(out map[string]any, name string, value any) {
+	switch value := value.(type) {
+	case float64:
+		c.addFloat(out, name, value)
+	case map[string]any:
+		for k, v := range value {
+			c.addJSONValue(out, name+"/"+k, v)
+		}
+	}
+}
+
+func (c *ExpvarCollection) addFloat(out map[string]any, name string, value float64) {
+	if value >= 0 && c.isCounter(name) {
+		out[name] = Float64CounterValue(value)
+	} else {
+		out[name] = GaugeValue(value)
+	}
+}
+
+func (c *ExpvarCollection) isCounter(name string) bool {
+	return len(c.counters) != 0 && matchAnyGlob(c.counters, name)
+}
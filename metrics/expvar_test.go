@@ -0,0 +1,96 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+var publishExpvarTest = sync.OnceValue(func() *expvar.Map {
+	expvar.NewInt("metricstest_int").Set(3)
+	expvar.NewInt("metricstest_negative").Set(-2)
+	expvar.NewFloat("metricstest_float").Set(1.5)
+	m := expvar.NewMap("metricstest_map")
+	m.Add("a", 1)
+	m.Set("nested", new(expvar.Map).Init())
+	m.Get("nested").(*expvar.Map).AddFloat("b", 2.5)
+	expvar.Publish("metricstest_func", expvar.Func(func() any {
+		return map[string]any{"x": 4, "s": "skipped", "list": []int{1}, "obj": map[string]int{"y": 5}}
+	}))
+	expvar.NewString("metricstest_string").Set("skipped")
+	reg := NewRegistry()
+	reg.Add("c", NewCounter())
+	PublishRegistry("metricstest_registry", reg)
+	return m
+})
+
+func TestExpvarCollection(t *testing.T) {
+	publishExpvarTest()
+	m := NewExpvarCollection("metricstest_*").Metrics()
+	exp := map[string]any{
+		"metricstest_int":          GaugeValue(3),
+		"metricstest_negative":     GaugeValue(-2),
+		"metricstest_float":        GaugeValue(1.5),
+		"metricstest_map/a":        GaugeValue(1),
+		"metricstest_map/nested/b": GaugeValue(2.5),
+		"metricstest_func/x":       GaugeValue(4),
+		"metricstest_func/obj/y":   GaugeValue(5),
+	}
+	if !reflect.DeepEqual(m, exp) {
+		t.Fatalf("Expected %+v. Got %+v", exp, m)
+	}
+
+	m = NewExpvarCollection("metricstest_*").WithCounters("metricstest_int", "metricstest_negative", "metricstest_map/**", "metricstest_func/x").Metrics()
+	exp = map[string]any{
+		"metricstest_int":          CounterValue(3),
+		"metricstest_negative":     GaugeValue(-2),
+		"metricstest_float":        GaugeValue(1.5),
+		"metricstest_map/a":        CounterValue(1),
+		"metricstest_map/nested/b": Float64CounterValue(2.5),
+		"metricstest_func/x":       Float64CounterValue(4),
+		"metricstest_func/obj/y":   GaugeValue(5),
+	}
+	if !reflect.DeepEqual(m, exp) {
+		t.Fatalf("Expected %+v. Got %+v", exp, m)
+	}
+
+	m = NewExpvarCollection().Metrics()
+	if _, ok := m["metricstest_int"]; !ok {
+		t.Fatal("Expected all variables to be included by default")
+	}
+	for name := range m {
+		if name == "memstats/NumGC" || name == "metricstest_registry/c" {
+			t.Fatalf("Expected %s to be excluded", name)
+		}
+	}
+}
+
+func TestRegistryVar(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter()
+	r.Add("a/count", c)
+	r.Add("b/value", GaugeValue(2))
+	v := NewRegistryVar(r.Scope("a"))
+
+	// The variable reflects changes to the registry
+	c.Inc(5)
+	var out map[string]any
+	if err := json.Unmarshal([]byte(v.String()), &out); err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]any{"a/count": 5.0}; !reflect.DeepEqual(out, exp) {
+		t.Fatalf("Expected %v. Got %v", exp, out)
+	}
+
+	// Metrics that fail to encode don't make the JSON invalid
+	r.Add("a/bad", make(chan int))
+	if err := json.Unmarshal([]byte(NewRegistryVar(r).String()), &out); err != nil {
+		t.Fatalf("Expected valid JSON. Got %s: %s", err, v.String())
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 var (
@@ -23,10 +24,93 @@ type Histogram interface {
 	String() string
 }
 
+// SnapshotHistogram is a histogram that reads its distribution and
+// percentiles together so they describe the same values even while it's
+// being updated.
+type SnapshotHistogram interface {
+	Histogram
+	DistributionAndPercentiles([]float64) (DistributionValue, []int64)
+}
+
+// NoClearHistogram is a histogram such as RollingHistogram whose values
+// already cover a window of time. RegistrySnapshot reports its values as
+// they are: it never clears it, and doesn't accumulate them with
+// TemporalityCumulative. OpenMetricsFamilies converts it to gauges rather
+// than a summary. Histograms that wrap one should implement it too.
+type NoClearHistogram interface {
+	Histogram
+	NoClearOnSnapshot()
+}
+
+// DistributionAndPercentiles returns the distribution and percentiles of
+// h. They're read together if h is a SnapshotHistogram, otherwise one
+// after the other so they may not agree if h is updated in between.
+func DistributionAndPercentiles(h Histogram, percentiles []float64) (DistributionValue, []int64) {
+	if sh, ok := h.(SnapshotHistogram); ok {
+		return sh.DistributionAndPercentiles(percentiles)
+	}
+	return h.Distribution(), h.Percentiles(percentiles)
+}
+
+// Stats selects the summary statistics that are reported for a histogram.
+type Stats uint
+
+const (
+	StatMean Stats = 1 << iota
+	StatCount
+	StatMin
+	StatMax
+	StatSum
+	StatStdDev
+
+	StatAll = StatMean | StatCount | StatMin | StatMax | StatSum | StatStdDev
+)
+
+var statNames = []struct {
+	stat Stats
+	name string
+}{
+	{StatCount, "count"},
+	{StatSum, "sum"},
+	{StatMin, "min"},
+	{StatMax, "max"},
+	{StatMean, "mean"},
+	{StatStdDev, "stddev"},
+}
+
+// Each calls f with the name and value of every selected statistic in a
+// consistent order.
+func (s Stats) Each(v DistributionValue, f func(stat Stats, name string, value float64)) {
+	for _, n := range statNames {
+		if s&n.stat != 0 {
+			f(n.stat, n.name, v.Stat(n.stat))
+		}
+	}
+}
+
+// HistogramExport attaches a reporting spec to a histogram. Register it
+// in place of the histogram to control what's reported for it by
+// RegistrySnapshot, RegistryHandler, and the reporters.
 type HistogramExport struct {
-	Histogram       Histogram
-	Percentiles     []float64
+	Histogram   Histogram
+	Percentiles []float64
+	// PercentileNames if nil are derived from Percentiles (e.g. 0.999 is p999)
 	PercentileNames []string
+	// Stats selects the summary statistics to report. If zero the
+	// reporter's defaults are used.
+	Stats Stats
+	// ReportEmpty reports zeros when no values have been recorded instead
+	// of omitting the histogram.
+	ReportEmpty bool
+}
+
+// defaultHistogramExport returns the spec of histograms that aren't
+// registered with one.
+func defaultHistogramExport() *HistogramExport {
+	return &HistogramExport{
+		Percentiles:     DefaultPercentiles,
+		PercentileNames: DefaultPercentileNames,
+	}
 }
 
 type histogramValues struct {
@@ -40,7 +124,24 @@ type histogramValues struct {
 
 // Return a JSON encoded version of the Histgram output
 func (e *HistogramExport) String() string {
-	return histogramToJSON(e.Histogram, e.Percentiles, e.PercentileNames)
+	return histogramToJSON(e.Histogram, e.Percentiles, e.percentileNames(), e.Stats)
+}
+
+func (e *HistogramExport) percentileNames() []string {
+	if e.PercentileNames != nil {
+		return e.PercentileNames
+	}
+	names := make([]string, len(e.Percentiles))
+	for i, p := range e.Percentiles {
+		names[i] = PercentileName(p)
+	}
+	return names
+}
+
+// PercentileName returns the conventional name for a percentile given as a
+// fraction (e.g. p50 for 0.5 and p999 for 0.999).
+func PercentileName(p float64) string {
+	return "p" + strings.Replace(strconv.FormatFloat(p*100, 'f', -1, 64), ".", "", 1)
 }
 
 func (e *HistogramExport) MarshalJSON() ([]byte, error) {
@@ -51,15 +152,28 @@ func (e *HistogramExport) MarshalText() ([]byte, error) {
 	return e.MarshalJSON()
 }
 
-// Return a JSON encoded version of the Histgram output
-func histogramToJSON(h Histogram, percentiles []float64, percentileNames []string) string {
-	v := h.Distribution()
+// Return a JSON encoded version of the Histgram output. If stats is zero
+// the count, sum, min, max, and mean are included.
+func histogramToJSON(h Histogram, percentiles []float64, percentileNames []string, stats Stats) string {
+	v, perc := DistributionAndPercentiles(h, percentiles)
 	b := &bytes.Buffer{}
-	fmt.Fprintf(b, "{\"count\":%d,\"sum\":%f,\"min\":%f,\"max\":%f,\"mean\":%s",
-		v.Count, v.Sum, v.Min, v.Max, strconv.FormatFloat(v.Mean(), 'g', -1, 64))
-	perc := h.Percentiles(percentiles)
+	if stats == 0 {
+		fmt.Fprintf(b, "{\"count\":%d,\"sum\":%f,\"min\":%f,\"max\":%f,\"mean\":%s",
+			v.Count, v.Sum, v.Min, v.Max, strconv.FormatFloat(v.Mean(), 'g', -1, 64))
+	} else {
+		b.WriteByte('{')
+		stats.Each(v, func(stat Stats, name string, value float64) {
+			if b.Len() > 1 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "%q:%s", name, strconv.FormatFloat(value, 'g', -1, 64))
+		})
+	}
 	for i, p := range perc {
-		fmt.Fprintf(b, ",\"%s\":%d", percentileNames[i], p)
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(b, "\"%s\":%d", percentileNames[i], p)
 	}
 	fmt.Fprintf(b, "}")
 	return b.String()
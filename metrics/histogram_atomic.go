@@ -0,0 +1,218 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// atomicHistogramCounts is one of the two sets of counts of an
+// atomicHistogram. The 64-bit fields come first so they're aligned on
+// 32-bit platforms.
+type atomicHistogramCounts struct {
+	// count is the number of updates that have finished which trails the
+	// number started while the counts are hot.
+	count      uint64
+	sum        int64
+	min        int64
+	max        int64
+	sumSquares uint64 // float64 bits
+	buckets    []uint64
+}
+
+func newAtomicHistogramCounts(n int) *atomicHistogramCounts {
+	return &atomicHistogramCounts{
+		min:     math.MaxInt64,
+		max:     math.MinInt64,
+		buckets: make([]uint64, n),
+	}
+}
+
+func (c *atomicHistogramCounts) update(value int64, bucket int) {
+	atomic.AddUint64(&c.buckets[bucket], 1)
+	atomic.AddInt64(&c.sum, value)
+	addFloat64(&c.sumSquares, float64(value)*float64(value))
+	minInt64(&c.min, value)
+	maxInt64(&c.max, value)
+}
+
+// minInt64 atomically stores value at addr if it's smaller.
+func minInt64(addr *int64, value int64) {
+	for m := atomic.LoadInt64(addr); value < m && !atomic.CompareAndSwapInt64(addr, m, value); {
+		m = atomic.LoadInt64(addr)
+	}
+}
+
+// maxInt64 atomically stores value at addr if it's larger.
+func maxInt64(addr *int64, value int64) {
+	for m := atomic.LoadInt64(addr); value > m && !atomic.CompareAndSwapInt64(addr, m, value); {
+		m = atomic.LoadInt64(addr)
+	}
+}
+
+// addFloat64 atomically adds delta to the float64 whose bits are at addr.
+func addFloat64(addr *uint64, delta float64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if atomic.CompareAndSwapUint64(addr, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// atomicHistogram is a bucketed histogram that's updated without a lock.
+// Updates go to the hot set of counts. Readers take turns swapping the hot
+// and cold sets, wait for updates to the now cold set to finish, read it
+// and merge it into the hot set so every read sees a consistent state.
+// This is the scheme used by the Prometheus Go client's histograms.
+type atomicHistogram struct {
+	// countAndHotIdx holds the number of updates started in the low 63
+	// bits and the index of the hot counts in the top bit.
+	countAndHotIdx uint64
+	// cleared counts the calls to Clear. It comes first with
+	// countAndHotIdx so they're aligned on 32-bit platforms.
+	cleared       uint64
+	bucketOffsets []int64
+	counts        [2]*atomicHistogramCounts
+	// mu serializes readers. Updates never take it.
+	mu sync.Mutex
+}
+
+// NewAtomicBucketedHistogram returns a histogram with the same buckets and
+// accuracy as NewBucketedHistogram that doesn't lock on Update. Updates
+// only use atomic operations so many goroutines can record to it at once,
+// while Distribution, Percentiles and Clear are slower and serialized. It's
+// a SnapshotHistogram so its distribution and percentiles can be read
+// together. The variance is computed from the sum of squares, so it loses
+// precision when the mean is large compared to the standard deviation.
+func NewAtomicBucketedHistogram(bucketOffsets []int64) Histogram {
+	return &atomicHistogram{
+		bucketOffsets: bucketOffsets,
+		counts: [2]*atomicHistogramCounts{
+			newAtomicHistogramCounts(len(bucketOffsets) + 1),
+			newAtomicHistogramCounts(len(bucketOffsets) + 1),
+		},
+	}
+}
+
+// NewDefaultAtomicBucketedHistogram returns an atomic bucketed histogram
+// with an error of 5%
+func NewDefaultAtomicBucketedHistogram() Histogram {
+	return NewAtomicBucketedHistogram(MakeBucketsForError(0.05))
+}
+
+func (h *atomicHistogram) Update(value int64) {
+	bucket := bucketIndex(h.bucketOffsets, value)
+	n := atomic.AddUint64(&h.countAndHotIdx, 1)
+	hot := h.counts[n>>63]
+	hot.update(value, bucket)
+	// Mark the update as finished last so readers don't see it half done
+	atomic.AddUint64(&hot.count, 1)
+}
+
+// swap makes the cold counts hot and waits for updates to the previously
+// hot counts to finish. It returns them along with the new hot counts and
+// must be called with mu held.
+func (h *atomicHistogram) swap() (cold, hot *atomicHistogramCounts) {
+	n := atomic.AddUint64(&h.countAndHotIdx, 1<<63)
+	count := n & (1<<63 - 1)
+	hot = h.counts[n>>63]
+	cold = h.counts[(^n)>>63]
+	for atomic.LoadUint64(&cold.count) != count {
+		runtime.Gosched()
+	}
+	return cold, hot
+}
+
+func (h *atomicHistogram) snapshot() bucketedValues {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cold, hot := h.swap()
+	s := bucketedValues{
+		count:      cold.count,
+		sum:        cold.sum,
+		min:        cold.min,
+		max:        cold.max,
+		sumSquares: math.Float64frombits(cold.sumSquares),
+		buckets:    make([]uint64, len(cold.buckets)),
+	}
+	copy(s.buckets, cold.buckets)
+
+	// Merge the cold counts into the hot ones which include everything
+	// since the swap and reset them for the next swap
+	for i, c := range s.buckets {
+		if c != 0 {
+			atomic.AddUint64(&hot.buckets[i], c)
+		}
+	}
+	atomic.AddInt64(&hot.sum, s.sum)
+	addFloat64(&hot.sumSquares, s.sumSquares)
+	minInt64(&hot.min, s.min)
+	maxInt64(&hot.max, s.max)
+	atomic.AddUint64(&hot.count, s.count)
+	cold.reset()
+	return s
+}
+
+func (c *atomicHistogramCounts) reset() {
+	atomic.StoreUint64(&c.count, 0)
+	atomic.StoreInt64(&c.sum, 0)
+	atomic.StoreInt64(&c.min, math.MaxInt64)
+	atomic.StoreInt64(&c.max, math.MinInt64)
+	atomic.StoreUint64(&c.sumSquares, 0)
+	for i := range c.buckets {
+		atomic.StoreUint64(&c.buckets[i], 0)
+	}
+}
+
+// Clear discards the updates that finished before it's called. Updates
+// that happen at the same time may or may not be kept.
+func (h *atomicHistogram) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cold, _ := h.swap()
+	// The discarded updates no longer count towards those started
+	atomic.AddUint64(&h.countAndHotIdx, -cold.count)
+	cold.reset()
+	atomic.AddUint64(&h.cleared, 1)
+}
+
+func (h *atomicHistogram) clears() uint64 {
+	return atomic.LoadUint64(&h.cleared)
+}
+
+func (h *atomicHistogram) Distribution() DistributionValue {
+	s := h.snapshot()
+	return s.distribution()
+}
+
+func (h *atomicHistogram) Percentiles(percentiles []float64) []int64 {
+	s := h.snapshot()
+	return bucketPercentiles(h.bucketOffsets, s.buckets, s.count, s.min, percentiles)
+}
+
+// DistributionAndPercentiles reads the distribution and percentiles from
+// the same snapshot so they agree while the histogram is being updated.
+func (h *atomicHistogram) DistributionAndPercentiles(percentiles []float64) (DistributionValue, []int64) {
+	s := h.snapshot()
+	return s.distribution(), bucketPercentiles(h.bucketOffsets, s.buckets, s.count, s.min, percentiles)
+}
+
+func (h *atomicHistogram) String() string {
+	return histogramToJSON(h, DefaultPercentiles, DefaultPercentileNames, 0)
+}
+
+func (h *atomicHistogram) MarshalJSON() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *atomicHistogram) MarshalText() ([]byte, error) {
+	return h.MarshalJSON()
+}
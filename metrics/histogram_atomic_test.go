@@ -0,0 +1,117 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAtomicHistogram(t *testing.T) {
+	h := NewDefaultAtomicBucketedHistogram()
+	b := NewDefaultBucketedHistogram()
+	for i := range int64(1000) {
+		h.Update(i)
+		b.Update(i)
+	}
+	// Swap the counts a few times to make sure merging keeps everything
+	for range 3 {
+		h.Distribution()
+	}
+	hv, bv := h.Distribution(), b.Distribution()
+	if hv.Count != bv.Count || hv.Sum != bv.Sum || hv.Min != bv.Min || hv.Max != bv.Max {
+		t.Fatalf("Expected %+v. Got %+v", bv, hv)
+	}
+	if math.Abs(hv.Variance-bv.Variance) > 1e-6 {
+		t.Fatalf("Expected variance %f. Got %f", bv.Variance, hv.Variance)
+	}
+	percentiles := []float64{0.0, 0.5, 0.9, 0.99, 1.0}
+	hp, bp := h.Percentiles(percentiles), b.Percentiles(percentiles)
+	for i := range percentiles {
+		if hp[i] != bp[i] {
+			t.Fatalf("Expected %v. Got %v", bp, hp)
+		}
+	}
+
+	h.Clear()
+	if v := h.Distribution(); v != (DistributionValue{}) {
+		t.Fatalf("Expected empty distribution after clear. Got %+v", v)
+	}
+	h.Update(-5)
+	if v := h.Distribution(); v.Count != 1 || v.Sum != -5 || v.Min != -5 || v.Max != -5 {
+		t.Fatalf("Expected a single -5 after clear. Got %+v", v)
+	}
+}
+
+// TestAtomicHistogramConcurrent checks that reads stay consistent while
+// updates, reads and clears all happen at once. Every value is lo or hi so
+// a consistent read has a sum of a*lo + b*hi where a + b is the count, and
+// the distribution and percentiles read together agree on the min and max.
+func TestAtomicHistogramConcurrent(t *testing.T) {
+	const (
+		lo      = 10
+		hi      = 1000
+		writers = 8
+		updates = 10000
+	)
+	h := NewDefaultAtomicBucketedHistogram()
+	if _, ok := h.(SnapshotHistogram); !ok {
+		t.Fatal("Expected a SnapshotHistogram")
+	}
+
+	var running atomic.Int32
+	running.Store(writers)
+	var wg sync.WaitGroup
+	for range writers {
+		wg.Go(func() {
+			defer running.Add(-1)
+			for range updates {
+				h.Update(lo)
+				h.Update(hi)
+			}
+		})
+	}
+	wg.Go(func() {
+		for i := 0; running.Load() > 0; i++ {
+			if i%10 == 0 {
+				h.Clear()
+			}
+			h.Percentiles(DefaultPercentiles)
+		}
+	})
+
+	for running.Load() > 0 {
+		v, p := DistributionAndPercentiles(h, []float64{0, 1})
+		if v.Count == 0 {
+			continue
+		}
+		b := (v.Sum - lo*float64(v.Count)) / (hi - lo)
+		if b != math.Trunc(b) || b < 0 || b > float64(v.Count) {
+			t.Fatalf("Expected sum of %d values of %d and %d. Got %+v", v.Count, lo, hi, v)
+		}
+		if (v.Min != lo && v.Min != hi) || (v.Max != lo && v.Max != hi) {
+			t.Fatalf("Expected min and max of %d or %d. Got %+v", lo, hi, v)
+		}
+		// The 100th percentile is the midpoint of the max's bucket
+		if float64(p[0]) != v.Min || (v.Max == lo) != (p[1] < (lo+hi)/2) {
+			t.Fatalf("Expected percentiles to agree with %+v. Got %v", v, p)
+		}
+	}
+	wg.Wait()
+}
+
+func BenchmarkAtomicHistogramUpdate(b *testing.B) {
+	benchmarkHistogramUpdate(b, NewDefaultAtomicBucketedHistogram())
+}
+
+func BenchmarkAtomicHistogramPercentiles(b *testing.B) {
+	benchmarkHistogramPercentiles(b, NewDefaultAtomicBucketedHistogram())
+}
+
+func BenchmarkAtomicHistogramConcurrentUpdate(b *testing.B) {
+	benchmarkHistogramConcurrentUpdate(b, NewDefaultAtomicBucketedHistogram())
+}
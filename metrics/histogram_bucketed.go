@@ -7,7 +7,6 @@ package metrics
 import (
 	"math"
 	"sync"
-	"sync/atomic"
 )
 
 var (
@@ -22,6 +21,8 @@ type bucketedHistogram struct {
 	max           int64
 	sum           int64
 	count         uint64
+	variance      variance
+	cleared       uint64
 	mu            sync.RWMutex
 }
 
@@ -83,11 +84,16 @@ func NewDefaultBucketedHistogram() Histogram {
 }
 
 func (h *bucketedHistogram) bucketIndex(key int64) int {
+	return bucketIndex(h.bucketOffsets, key)
+}
+
+// bucketIndex returns the index of the count for key given bucket offsets.
+func bucketIndex(bucketOffsets []int64, key int64) int {
 	low := 0
-	high := len(h.bucketOffsets) - 1
+	high := len(bucketOffsets) - 1
 	for low <= high {
 		mid := (low + high + 1) >> 1
-		midValue := h.bucketOffsets[mid]
+		midValue := bucketOffsets[mid]
 		if midValue < key {
 			low = mid + 1
 		} else if midValue > key {
@@ -106,19 +112,27 @@ func (h *bucketedHistogram) Clear() {
 	h.sum = 0
 	h.min = math.MaxInt64
 	h.max = math.MinInt64
+	h.variance = variance{}
 	for i := 0; i < len(h.bucketCounts); i++ {
 		h.bucketCounts[i] = 0
 	}
+	h.cleared++
 	h.mu.Unlock()
 }
 
+func (h *bucketedHistogram) clears() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.cleared
+}
+
 func (h *bucketedHistogram) Update(value int64) {
 	h.mu.Lock()
 	bucketIndex := h.bucketIndex(value)
 	h.bucketCounts[bucketIndex] += 1
 	h.count++
-	atomic.AddInt64(&h.sum, value)
 	h.sum += value
+	h.variance.update(float64(value), h.count)
 	if value < h.min {
 		h.min = value
 	}
@@ -131,8 +145,9 @@ func (h *bucketedHistogram) Update(value int64) {
 func (h *bucketedHistogram) Distribution() DistributionValue {
 	h.mu.RLock()
 	v := DistributionValue{
-		Count: h.count,
-		Sum:   float64(h.sum),
+		Count:    h.count,
+		Sum:      float64(h.sum),
+		Variance: h.variance.value(h.count),
 	}
 	if h.count > 0 {
 		v.Min = float64(h.min)
@@ -145,7 +160,12 @@ func (h *bucketedHistogram) Distribution() DistributionValue {
 func (h *bucketedHistogram) Percentiles(percentiles []float64) []int64 {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
+	return bucketPercentiles(h.bucketOffsets, h.bucketCounts, h.count, h.min, percentiles)
+}
 
+// bucketPercentiles returns the midpoints of the buckets that the
+// percentiles fall into. The bucket counts must add up to count.
+func bucketPercentiles(bucketOffsets []int64, bucketCounts []uint64, count uint64, min int64, percentiles []float64) []int64 {
 	scores := make([]int64, len(percentiles))
 
 	total := uint64(0)
@@ -155,25 +175,25 @@ func (h *bucketedHistogram) Percentiles(percentiles []float64) []int64 {
 			p /= 100.0
 		}
 		if p == 0.0 {
-			if h.count == 0 {
+			if count == 0 {
 				scores[i] = 0
 			} else {
-				scores[i] = h.min
+				scores[i] = min
 			}
 		} else {
-			target := p * float64(h.count)
+			target := p * float64(count)
 			for float64(total) < target {
-				total += atomic.LoadUint64(&h.bucketCounts[index])
+				total += bucketCounts[index]
 				index++
 			}
 			if index <= 1 {
 				scores[i] = 0
-			} else if index-1 >= len(h.bucketOffsets) {
+			} else if index-1 >= len(bucketOffsets) {
 				scores[i] = math.MaxInt64
 			} else {
-				// Avoid overflow calculating (h.bucketOffsets[index-2] + h.bucketOffsets[index-1] - 1) >> 1
-				o1 := h.bucketOffsets[index-2]
-				o2 := h.bucketOffsets[index-1]
+				// Avoid overflow calculating (bucketOffsets[index-2] + bucketOffsets[index-1] - 1) >> 1
+				o1 := bucketOffsets[index-2]
+				o2 := bucketOffsets[index-1]
 				bit := ((o1 & 1) | (o1 & 1)) ^ 1
 				scores[i] = (o1 >> 1) + (o2 >> 1) - bit
 			}
@@ -183,8 +203,75 @@ func (h *bucketedHistogram) Percentiles(percentiles []float64) []int64 {
 	return scores
 }
 
+// bucketedValues holds the same values as a bucketedHistogram but isn't
+// safe for concurrent use. Unlike Welford's method the variance is
+// computed from the sum of squares so it can be accumulated atomically and
+// merged, at the cost of precision when the mean is large compared to the
+// standard deviation.
+type bucketedValues struct {
+	count      uint64
+	sum        int64
+	min        int64
+	max        int64
+	sumSquares float64
+	buckets    []uint64
+}
+
+func newBucketedValues(n int) bucketedValues {
+	return bucketedValues{
+		min:     math.MaxInt64,
+		max:     math.MinInt64,
+		buckets: make([]uint64, n),
+	}
+}
+
+func (v *bucketedValues) update(value int64, bucket int) {
+	v.buckets[bucket]++
+	v.count++
+	v.sum += value
+	v.sumSquares += float64(value) * float64(value)
+	v.min = min(v.min, value)
+	v.max = max(v.max, value)
+}
+
+func (v *bucketedValues) merge(o *bucketedValues) {
+	for i, c := range o.buckets {
+		v.buckets[i] += c
+	}
+	v.count += o.count
+	v.sum += o.sum
+	v.sumSquares += o.sumSquares
+	v.min = min(v.min, o.min)
+	v.max = max(v.max, o.max)
+}
+
+func (v *bucketedValues) reset() {
+	*v = bucketedValues{
+		min:     math.MaxInt64,
+		max:     math.MinInt64,
+		buckets: v.buckets,
+	}
+	clear(v.buckets)
+}
+
+func (v *bucketedValues) distribution() DistributionValue {
+	d := DistributionValue{
+		Count: v.count,
+		Sum:   float64(v.sum),
+	}
+	if v.count > 0 {
+		d.Min = float64(v.min)
+		d.Max = float64(v.max)
+	}
+	if v.count > 1 {
+		n := float64(v.count)
+		d.Variance = max((v.sumSquares-d.Sum*d.Sum/n)/(n-1), 0)
+	}
+	return d
+}
+
 func (h *bucketedHistogram) String() string {
-	return histogramToJSON(h, DefaultPercentiles, DefaultPercentileNames)
+	return histogramToJSON(h, DefaultPercentiles, DefaultPercentileNames, 0)
 }
 
 func (h *bucketedHistogram) MarshalJSON() ([]byte, error) {
@@ -61,6 +61,14 @@ func TestBucketedHistogram(t *testing.T) {
 	}
 }
 
+func TestBucketedHistogramDistribution(t *testing.T) {
+	h := NewDefaultBucketedHistogram()
+	h.Update(20)
+	if v := h.Distribution(); v.Count != 1 || v.Sum != 20 || v.Min != 20 || v.Max != 20 {
+		t.Fatalf("Expected count 1 and sum, min and max of 20. Got %+v", v)
+	}
+}
+
 func TestBucketedHistogramPercentiles(t *testing.T) {
 	h := NewDefaultBucketedHistogram().(*bucketedHistogram)
 
@@ -0,0 +1,180 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// Float64Histogram is a histogram of counts in fixed buckets such as those
+// provided by runtime/metrics. Unlike the sampling histograms it can be
+// merged with others that have the same buckets and the difference between
+// two readings is exact, but values within a bucket are only known
+// approximately.
+type Float64Histogram struct {
+	// Counts[i] is the number of values in the range [Buckets[i], Buckets[i+1]).
+	Counts []uint64
+	// Buckets are the boundaries of the buckets in increasing order. There
+	// is one more of them than Counts. The first may be -Inf and the last
+	// +Inf.
+	Buckets []float64
+}
+
+// ErrBucketMismatch is returned when merging histograms with different
+// buckets.
+var ErrBucketMismatch = errors.New("metrics: histograms have different buckets")
+
+// Clone returns a copy of the histogram that doesn't share its counts.
+func (h *Float64Histogram) Clone() *Float64Histogram {
+	return &Float64Histogram{Counts: slices.Clone(h.Counts), Buckets: h.Buckets}
+}
+
+// Merge adds the counts of another histogram with the same buckets.
+func (h *Float64Histogram) Merge(o *Float64Histogram) error {
+	if !slices.Equal(h.Buckets, o.Buckets) {
+		return ErrBucketMismatch
+	}
+	for i, c := range o.Counts {
+		h.Counts[i] += c
+	}
+	return nil
+}
+
+// Delta returns the values recorded since prev was read from the same
+// source. If prev is nil, has different buckets, or has a larger count in
+// any bucket the source is assumed to have been reset and a copy of h is
+// returned.
+func (h *Float64Histogram) Delta(prev *Float64Histogram) *Float64Histogram {
+	d := h.Clone()
+	if prev == nil || !slices.Equal(h.Buckets, prev.Buckets) {
+		return d
+	}
+	for i, c := range prev.Counts {
+		if c > h.Counts[i] {
+			return h.Clone()
+		}
+		d.Counts[i] -= c
+	}
+	return d
+}
+
+// bucketValue returns the representative value of a bucket which is its
+// midpoint, or its finite edge if the other is infinite.
+func (h *Float64Histogram) bucketValue(i int) float64 {
+	lo, hi := h.Buckets[i], h.Buckets[i+1]
+	switch {
+	case math.IsInf(lo, -1):
+		return hi
+	case math.IsInf(hi, 1):
+		return lo
+	}
+	return lo + (hi-lo)/2
+}
+
+// Value returns the distribution of the histogram. The sum and variance
+// are estimated from the midpoints of the buckets, and the minimum and
+// maximum are the edges of the lowest and highest buckets with values.
+func (h *Float64Histogram) Value() DistributionValue {
+	var v DistributionValue
+	first, last := -1, -1
+	for i, c := range h.Counts {
+		if c == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		v.Count += c
+		v.Sum += h.bucketValue(i) * float64(c)
+	}
+	if first < 0 {
+		return v
+	}
+	v.Min = h.Buckets[first]
+	if math.IsInf(v.Min, -1) {
+		v.Min = h.Buckets[first+1]
+	}
+	v.Max = h.Buckets[last+1]
+	if math.IsInf(v.Max, 1) {
+		v.Max = h.Buckets[last]
+	}
+	mean := v.Mean()
+	for i, c := range h.Counts {
+		if c != 0 {
+			d := h.bucketValue(i) - mean
+			v.Variance += d * d * float64(c)
+		}
+	}
+	// Use the sample variance like the other histograms
+	if v.Count > 1 {
+		v.Variance /= float64(v.Count - 1)
+	} else {
+		v.Variance = 0
+	}
+	return v
+}
+
+// Quantiles returns estimates of the quantiles, given as fractions, by
+// interpolating linearly within the bucket that contains each one.
+func (h *Float64Histogram) Quantiles(quantiles []float64) []float64 {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	out := make([]float64, len(quantiles))
+	if total == 0 {
+		return out
+	}
+	for j, q := range quantiles {
+		rank := q * float64(total)
+		var seen uint64
+		for i, c := range h.Counts {
+			if c == 0 || float64(seen+c) < rank {
+				seen += c
+				continue
+			}
+			lo, hi := h.Buckets[i], h.Buckets[i+1]
+			switch {
+			case math.IsInf(lo, -1):
+				out[j] = hi
+			case math.IsInf(hi, 1):
+				out[j] = lo
+			default:
+				out[j] = lo + (hi-lo)*(rank-float64(seen))/float64(c)
+			}
+			break
+		}
+	}
+	return out
+}
+
+func (h *Float64Histogram) String() string {
+	v := h.Value()
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "{\"count\":%d,\"sum\":%s,\"min\":%s,\"max\":%s,\"mean\":%s",
+		v.Count, strconv.FormatFloat(v.Sum, 'g', -1, 64), strconv.FormatFloat(v.Min, 'g', -1, 64),
+		strconv.FormatFloat(v.Max, 'g', -1, 64), strconv.FormatFloat(v.Mean(), 'g', -1, 64))
+	for i, q := range h.Quantiles(DefaultPercentiles) {
+		fmt.Fprintf(b, ",%q:%s", DefaultPercentileNames[i], strconv.FormatFloat(q, 'g', -1, 64))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// MarshalJSON implements json.Marshaler
+func (h *Float64Histogram) MarshalJSON() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (h *Float64Histogram) MarshalText() ([]byte, error) {
+	return h.MarshalJSON()
+}
@@ -0,0 +1,79 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func testFloat64Histogram() *Float64Histogram {
+	return &Float64Histogram{
+		Counts:  []uint64{0, 2, 2, 0},
+		Buckets: []float64{math.Inf(-1), 0, 10, 20, math.Inf(1)},
+	}
+}
+
+func TestFloat64Histogram(t *testing.T) {
+	h := testFloat64Histogram()
+	v := h.Value()
+	// The sample variance of 5, 5, 15, 15
+	exp := DistributionValue{Count: 4, Sum: 40, Min: 0, Max: 20, Variance: 100.0 / 3}
+	if v != exp {
+		t.Fatalf("Expected %+v. Got %+v", exp, v)
+	}
+	if q := h.Quantiles([]float64{0.25, 0.5, 0.75, 1}); !reflect.DeepEqual(q, []float64{5, 10, 15, 20}) {
+		t.Fatalf("Expected quantiles [5 10 15 20]. Got %v", q)
+	}
+
+	// Values in the infinite buckets are represented by the finite edge
+	h.Counts[0], h.Counts[3] = 1, 1
+	if v := h.Value(); v.Min != 0 || v.Max != 20 {
+		t.Fatalf("Expected min 0 and max 20. Got %+v", v)
+	}
+
+	if err := h.Merge(testFloat64Histogram()); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []uint64{1, 4, 4, 1}; !reflect.DeepEqual(h.Counts, exp) {
+		t.Fatalf("Expected merged counts %v. Got %v", exp, h.Counts)
+	}
+	if err := h.Merge(&Float64Histogram{Counts: []uint64{1}, Buckets: []float64{0, 1}}); err != ErrBucketMismatch {
+		t.Fatalf("Expected ErrBucketMismatch. Got %v", err)
+	}
+}
+
+func TestFloat64HistogramDelta(t *testing.T) {
+	prev := testFloat64Histogram()
+	cur := testFloat64Histogram()
+	cur.Counts[2] = 5
+	if d := cur.Delta(prev); !reflect.DeepEqual(d.Counts, []uint64{0, 0, 3, 0}) {
+		t.Fatalf("Expected delta [0 0 3 0]. Got %v", d.Counts)
+	}
+	if d := cur.Delta(nil); !reflect.DeepEqual(d.Counts, cur.Counts) {
+		t.Fatalf("Expected delta from nil to be everything. Got %v", d.Counts)
+	}
+	// A bucket that went backwards means the source was reset
+	cur.Counts[1] = 1
+	if d := cur.Delta(prev); !reflect.DeepEqual(d.Counts, cur.Counts) {
+		t.Fatalf("Expected delta after reset to be everything. Got %v", d.Counts)
+	}
+
+	r := NewRegistry()
+	h := testFloat64Histogram()
+	r.Add("h", GaugeFunc(func() float64 { return 0 }))
+	r.Add("h", h)
+	snap := NewRegistrySnapshot(false)
+	snap.Snapshot(r)
+	h.Counts[2] += 2
+	snap.Snapshot(r)
+	if n := snap.Distributions[0].Value.Count; n != 2 {
+		t.Fatalf("Expected snapshot to report the delta count of 2. Got %d", n)
+	}
+	if snap.Values[0].Name != "h/p50" || snap.Values[0].Value != 15 {
+		t.Fatalf("Expected h/p50 of 15. Got %+v", snap.Values[0])
+	}
+}
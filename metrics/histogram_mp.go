@@ -33,6 +33,8 @@ type mpHistogram struct {
 	sum        int64
 	min        int64
 	max        int64
+	variance   variance
+	cleared    uint64
 	leafCount  int // number of elements in the bottom two leaves
 	currentTop int
 	rootWeight int
@@ -78,7 +80,7 @@ func NewMunroPatersonHistogramWithPrecision(p Precision) Histogram {
 }
 
 func (mp *mpHistogram) String() string {
-	return histogramToJSON(mp, DefaultPercentiles, DefaultPercentileNames)
+	return histogramToJSON(mp, DefaultPercentiles, DefaultPercentileNames, 0)
 }
 
 func (h *mpHistogram) MarshalJSON() ([]byte, error) {
@@ -97,14 +99,23 @@ func (mp *mpHistogram) Clear() {
 	mp.rootWeight = 1
 	mp.min = 0
 	mp.max = 0
+	mp.variance = variance{}
+	mp.cleared++
 	mp.mutex.Unlock()
 }
 
+func (mp *mpHistogram) clears() uint64 {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+	return mp.cleared
+}
+
 func (mp *mpHistogram) Distribution() DistributionValue {
 	mp.mutex.RLock()
 	v := DistributionValue{
-		Count: mp.count,
-		Sum:   float64(mp.sum),
+		Count:    mp.count,
+		Sum:      float64(mp.sum),
+		Variance: mp.variance.value(mp.count),
 	}
 	if mp.count > 0 {
 		v.Min = float64(mp.min)
@@ -215,6 +226,7 @@ func (mp *mpHistogram) Update(x int64) {
 	}
 	mp.count++
 	mp.sum += x
+	mp.variance.update(float64(x), mp.count)
 	mp.mutex.Unlock()
 }
 
@@ -18,12 +18,14 @@ type Sample interface {
 }
 
 type sampledHistogram struct {
-	sample Sample
-	min    int64
-	max    int64
-	sum    int64
-	count  uint64
-	lock   sync.RWMutex
+	sample   Sample
+	min      int64
+	max      int64
+	sum      int64
+	count    uint64
+	variance variance
+	cleared  uint64
+	lock     sync.RWMutex
 }
 
 func NewSampledHistogram(sample Sample) Histogram {
@@ -54,13 +56,22 @@ func (h *sampledHistogram) Clear() {
 	h.max = 0
 	h.sum = 0
 	h.count = 0
+	h.variance = variance{}
+	h.cleared++
 	h.lock.Unlock()
 }
 
+func (h *sampledHistogram) clears() uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.cleared
+}
+
 func (h *sampledHistogram) Update(value int64) {
 	h.lock.Lock()
 	h.count++
 	h.sum += value
+	h.variance.update(float64(value), h.count)
 	h.sample.Update(value)
 	if h.count == 1 {
 		h.min = value
@@ -79,8 +90,9 @@ func (h *sampledHistogram) Update(value int64) {
 func (h *sampledHistogram) Distribution() DistributionValue {
 	h.lock.RLock()
 	v := DistributionValue{
-		Count: h.count,
-		Sum:   float64(h.sum),
+		Count:    h.count,
+		Sum:      float64(h.sum),
+		Variance: h.variance.value(h.count),
 	}
 	if h.count > 0 {
 		v.Min = float64(h.min)
@@ -124,7 +136,7 @@ func (h *sampledHistogram) SampleValues() []int64 {
 }
 
 func (h *sampledHistogram) String() string {
-	return histogramToJSON(h, DefaultPercentiles, DefaultPercentileNames)
+	return histogramToJSON(h, DefaultPercentiles, DefaultPercentileNames, 0)
 }
 
 func (h *sampledHistogram) MarshalJSON() ([]byte, error) {
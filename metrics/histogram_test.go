@@ -97,3 +97,22 @@ func TestHistogramAccuracy(t *testing.T) {
 		}
 	}
 }
+
+func TestPercentileName(t *testing.T) {
+	for p, exp := range map[float64]string{0.5: "p50", 0.75: "p75", 0.99: "p99", 0.999: "p999", 0.9999: "p9999"} {
+		if n := PercentileName(p); n != exp {
+			t.Errorf("Expected PercentileName(%f) to be %s. Got %s", p, exp, n)
+		}
+	}
+}
+
+func TestHistogramExportStats(t *testing.T) {
+	h := NewUnbiasedHistogram()
+	h.Update(2)
+	h.Update(4)
+	e := &HistogramExport{Histogram: h, Percentiles: []float64{0.5}, Stats: StatCount | StatStdDev}
+	exp := `{"count":2,"stddev":1.4142135623730951,"p50":3}`
+	if s := e.String(); s != exp {
+		t.Fatalf("Expected %s. Got %s", exp, s)
+	}
+}
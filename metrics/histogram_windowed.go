@@ -0,0 +1,104 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import "sync"
+
+// WindowedHistogram is a Histogram that can be read by several consumers
+// without them interfering with each other. Each consumer sees only the
+// values recorded since its own previous window.
+type WindowedHistogram interface {
+	Histogram
+	// Window returns the values recorded since the previous call to Window
+	// by the same consumer and starts a new window for that consumer. A
+	// consumer's first window includes every value recorded so far.
+	Window(consumer any) Histogram
+	// Release discards the window for a consumer that's no longer used.
+	Release(consumer any)
+}
+
+type windowedHistogram struct {
+	factory  func() Histogram
+	lifetime Histogram
+	windows  map[any]Histogram
+	mu       sync.RWMutex
+}
+
+// NewWindowedHistogram returns a histogram that keeps a separate window for
+// every consumer (such as each RegistrySnapshot) that reads it. Values are
+// recorded in all windows as well as in a lifetime histogram which is used
+// for direct reads. Factory is used to create each of these.
+func NewWindowedHistogram(factory func() Histogram) WindowedHistogram {
+	return &windowedHistogram{
+		factory:  factory,
+		lifetime: factory(),
+		windows:  make(map[any]Histogram),
+	}
+}
+
+func (h *windowedHistogram) Window(consumer any) Histogram {
+	h.mu.Lock()
+	w, ok := h.windows[consumer]
+	if !ok {
+		w = h.lifetime
+	}
+	h.windows[consumer] = h.factory()
+	h.mu.Unlock()
+	return w
+}
+
+func (h *windowedHistogram) Release(consumer any) {
+	h.mu.Lock()
+	delete(h.windows, consumer)
+	h.mu.Unlock()
+}
+
+// Clear clears the lifetime histogram and every consumer's window.
+func (h *windowedHistogram) Clear() {
+	h.mu.Lock()
+	h.lifetime.Clear()
+	for _, w := range h.windows {
+		w.Clear()
+	}
+	h.mu.Unlock()
+}
+
+// clears returns the number of times the lifetime histogram was cleared.
+func (h *windowedHistogram) clears() uint64 {
+	if c, ok := h.lifetime.(clearedHistogram); ok {
+		return c.clears()
+	}
+	return 0
+}
+
+func (h *windowedHistogram) Update(value int64) {
+	// The histograms have their own locks. This only guards the map.
+	h.mu.RLock()
+	h.lifetime.Update(value)
+	for _, w := range h.windows {
+		w.Update(value)
+	}
+	h.mu.RUnlock()
+}
+
+func (h *windowedHistogram) Distribution() DistributionValue {
+	return h.lifetime.Distribution()
+}
+
+func (h *windowedHistogram) Percentiles(percentiles []float64) []int64 {
+	return h.lifetime.Percentiles(percentiles)
+}
+
+func (h *windowedHistogram) String() string {
+	return h.lifetime.String()
+}
+
+func (h *windowedHistogram) MarshalJSON() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *windowedHistogram) MarshalText() ([]byte, error) {
+	return h.MarshalJSON()
+}
@@ -0,0 +1,38 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import "testing"
+
+func TestWindowedHistogram(t *testing.T) {
+	h := NewWindowedHistogram(NewUnbiasedHistogram)
+	h.Update(1)
+	h.Update(2)
+
+	// First window covers everything recorded so far
+	if c := h.Window("a").Distribution().Count; c != 2 {
+		t.Fatalf("Expected first window for a to have count 2. Got %d", c)
+	}
+	h.Update(3)
+	if c := h.Window("b").Distribution().Count; c != 3 {
+		t.Fatalf("Expected first window for b to have count 3. Got %d", c)
+	}
+	h.Update(4)
+	if d := h.Window("a").Distribution(); d.Count != 2 || d.Sum != 7 {
+		t.Fatalf("Expected window for a to have count 2 and sum 7. Got %+v", d)
+	}
+	if d := h.Window("b").Distribution(); d.Count != 1 || d.Sum != 4 {
+		t.Fatalf("Expected window for b to have count 1 and sum 4. Got %+v", d)
+	}
+	if c := h.Distribution().Count; c != 4 {
+		t.Fatalf("Expected lifetime count of 4. Got %d", c)
+	}
+
+	h.Release("b")
+	h.Update(5)
+	if c := h.Window("b").Distribution().Count; c != 5 {
+		t.Fatalf("Expected released consumer to start over with count 5. Got %d", c)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"context"
+	"maps"
+	"path"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Middleware transforms the metrics seen when walking a registry. It wraps
+// the visitor that's passed to Visit and may skip metrics by not calling
+// next, or change their names, values, or metadata before calling it.
+type Middleware func(next Visitor) Visitor
+
+type chainRegistry struct {
+	registry   Registry
+	middleware []Middleware
+}
+
+// Chain returns a registry that applies the middleware, in order, to every
+// metric when it's walked. Everything else is passed through unchanged so
+// metrics are added and removed by their original names.
+func Chain(registry Registry, middleware ...Middleware) Registry {
+	return &chainRegistry{registry, middleware}
+}
+
+func (r *chainRegistry) Scope(scope string) Registry {
+	return &chainRegistry{r.registry.Scope(scope), r.middleware}
+}
+
+func (r *chainRegistry) ScopeWithOptions(scope string, opts ...ScopeOption) Registry {
+	return &chainRegistry{ScopeWithOptions(r.registry, scope, opts...), r.middleware}
+}
+
+func (r *chainRegistry) RemoveScope(scope string) {
+	if sr, ok := r.registry.(ScopesRegistry); ok {
+		sr.RemoveScope(scope)
+	}
+}
+
+func (r *chainRegistry) Scopes() []string {
+	if sr, ok := r.registry.(ScopesRegistry); ok {
+		return sr.Scopes()
+	}
+	return nil
+}
+
+func (r *chainRegistry) Add(name string, metric any) {
+	r.registry.Add(name, metric)
+}
+
+func (r *chainRegistry) AddWithOptions(name string, metric any, opts ...AddOption) {
+	AddWithOptions(r.registry, name, metric, opts...)
+}
+
+func (r *chainRegistry) GetOrAdd(name string, factory func() any, opts ...AddOption) any {
+	return GetOrAdd(r.registry, name, factory, opts...)
+}
+
+func (r *chainRegistry) Remove(name string) {
+	r.registry.Remove(name)
+}
+
+func (r *chainRegistry) Do(f Doer) error {
+	return r.Visit(func(name string, metric any, _ Metadata) error {
+		return f(name, metric)
+	})
+}
+
+func (r *chainRegistry) Visit(f Visitor) error {
+	return r.VisitContext(context.Background(), f)
+}
+
+func (r *chainRegistry) VisitContext(ctx context.Context, f Visitor) error {
+	return VisitContext(ctx, r.registry, chainVisitor(r.middleware, f))
+}
+
+func chainVisitor(middleware []Middleware, f Visitor) Visitor {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		f = middleware[i](f)
+	}
+	return f
+}
+
+// filterMiddleware returns middleware that skips metrics for which keep
+// returns false.
+func filterMiddleware(keep func(name string, metric any) bool) Middleware {
+	return func(next Visitor) Visitor {
+		return func(name string, metric any, md Metadata) error {
+			if !keep(name, metric) {
+				return nil
+			}
+			return next(name, metric, md)
+		}
+	}
+}
+
+// IncludeRegexp only keeps metrics whose name matches at least one of the
+// expressions.
+func IncludeRegexp(res ...*regexp.Regexp) Middleware {
+	return filterMiddleware(func(name string, _ any) bool {
+		return matchAnyRegexp(res, name)
+	})
+}
+
+// ExcludeRegexp skips metrics whose name matches any of the expressions.
+func ExcludeRegexp(res ...*regexp.Regexp) Middleware {
+	return filterMiddleware(func(name string, _ any) bool {
+		return !matchAnyRegexp(res, name)
+	})
+}
+
+func matchAnyRegexp(res []*regexp.Regexp, name string) bool {
+	for _, re := range res {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// IncludeGlob only keeps metrics whose name matches at least one of the
+// shell glob patterns. Patterns are matched a path segment at a time using
+// path.Match so * doesn't match a slash, and a segment of ** matches any
+// number of segments. It panics if a pattern is malformed.
+func IncludeGlob(patterns ...string) Middleware {
+	globs := compileGlobs(patterns)
+	return filterMiddleware(func(name string, _ any) bool {
+		return matchAnyGlob(globs, name)
+	})
+}
+
+// ExcludeGlob skips metrics whose name matches any of the shell glob
+// patterns. Patterns are interpreted like for IncludeGlob.
+func ExcludeGlob(patterns ...string) Middleware {
+	globs := compileGlobs(patterns)
+	return filterMiddleware(func(name string, _ any) bool {
+		return !matchAnyGlob(globs, name)
+	})
+}
+
+func compileGlobs(patterns []string) [][]string {
+	globs := make([][]string, len(patterns))
+	for i, p := range patterns {
+		globs[i] = strings.Split(p, "/")
+		for _, seg := range globs[i] {
+			if _, err := path.Match(seg, ""); err != nil {
+				panic("metrics: invalid glob pattern " + p)
+			}
+		}
+	}
+	return globs
+}
+
+func matchAnyGlob(globs [][]string, name string) bool {
+	segs := strings.Split(name, "/")
+	for _, g := range globs {
+		if matchGlob(g, segs) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGlob(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchGlob(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// ExcludeType skips metrics of type T which may be an interface, for
+// instance ExcludeType[*Meter]() or ExcludeType[Histogram]().
+func ExcludeType[T any]() Middleware {
+	return filterMiddleware(func(_ string, metric any) bool {
+		_, ok := metric.(T)
+		return !ok
+	})
+}
+
+// Rename changes the name of every metric to the one returned by f.
+func Rename(f func(name string) string) Middleware {
+	return func(next Visitor) Visitor {
+		return func(name string, metric any, md Metadata) error {
+			return next(f(name), metric, md)
+		}
+	}
+}
+
+// StripPrefix removes the prefix from the names of metrics that have it.
+func StripPrefix(prefix string) Middleware {
+	return Rename(func(name string) string {
+		return strings.TrimPrefix(name, prefix)
+	})
+}
+
+// ReplaceRegexp replaces matches of the expression in metric names with
+// repl as done by Regexp.ReplaceAllString.
+func ReplaceRegexp(re *regexp.Regexp, repl string) Middleware {
+	return Rename(func(name string) string {
+		return re.ReplaceAllString(name, repl)
+	})
+}
+
+// TagsFromName moves parts of matching names into tags. The pattern is a
+// slash separated list of segments where {key} captures the segment as the
+// value of the tag key and removes it from the name. Other segments are
+// matched using path.Match. For example the pattern http/{route}/latency
+// turns http/users/latency into http/latency with the tag route=users.
+// Captured tags take precedence over existing tags with the same key.
+// Names that don't match are unchanged.
+func TagsFromName(pattern string) Middleware {
+	segs := compileGlobs([]string{pattern})[0]
+	return func(next Visitor) Visitor {
+		return func(name string, metric any, md Metadata) error {
+			parts := strings.Split(name, "/")
+			if len(parts) != len(segs) {
+				return next(name, metric, md)
+			}
+			var kept []string
+			tags := make(map[string]string)
+			for i, seg := range segs {
+				if len(seg) > 2 && seg[0] == '{' && seg[len(seg)-1] == '}' {
+					tags[seg[1:len(seg)-1]] = parts[i]
+					continue
+				}
+				if ok, _ := path.Match(seg, parts[i]); !ok {
+					return next(name, metric, md)
+				}
+				kept = append(kept, parts[i])
+			}
+			merged := maps.Clone(md.Tags)
+			if merged == nil {
+				merged = tags
+			} else {
+				maps.Copy(merged, tags)
+			}
+			md.Tags = merged
+			return next(strings.Join(kept, "/"), metric, md)
+		}
+	}
+}
+
+// MiddlewareSwitch is middleware that can be replaced while the registry
+// it's applied to is being walked, for instance to change the filters of
+// running reporters. Every walk uses the middleware that was set when it
+// started. The zero value has no middleware so it passes every metric on.
+type MiddlewareSwitch struct {
+	middleware atomic.Pointer[[]Middleware]
+}
+
+// NewMiddlewareSwitch returns a switch set to the given middleware.
+func NewMiddlewareSwitch(middleware ...Middleware) *MiddlewareSwitch {
+	s := &MiddlewareSwitch{}
+	s.Set(middleware...)
+	return s
+}
+
+// Set replaces the middleware.
+func (s *MiddlewareSwitch) Set(middleware ...Middleware) {
+	s.middleware.Store(&middleware)
+}
+
+// Middleware applies the current middleware. Pass it (as s.Middleware) to
+// Chain.
+func (s *MiddlewareSwitch) Middleware(next Visitor) Visitor {
+	middleware := s.middleware.Load()
+	if middleware == nil {
+		return next
+	}
+	return chainVisitor(*middleware, next)
+}
@@ -0,0 +1,113 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+func chainNames(t *testing.T, r Registry) []string {
+	var names []string
+	if err := r.Do(func(name string, metric any) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestMiddlewareGlob(t *testing.T) {
+	r := NewRegistry()
+	for _, name := range []string{"http/users/latency", "http/users/get/latency", "http/errors", "db/queries"} {
+		r.Add(name, 1)
+	}
+	cases := []struct {
+		mw  Middleware
+		exp []string
+	}{
+		{IncludeGlob("http/*/latency"), []string{"http/users/latency"}},
+		{IncludeGlob("http/**/latency"), []string{"http/users/get/latency", "http/users/latency"}},
+		{IncludeGlob("**"), []string{"db/queries", "http/errors", "http/users/get/latency", "http/users/latency"}},
+		{ExcludeGlob("http/**", "d?/*"), nil},
+		{ExcludeGlob("http/*"), []string{"db/queries", "http/users/get/latency", "http/users/latency"}},
+	}
+	for i, c := range cases {
+		if names := chainNames(t, Chain(r, c.mw)); !reflect.DeepEqual(names, c.exp) {
+			t.Errorf("%d: Expected %v. Got %v", i, c.exp, names)
+		}
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	r := NewRegistry()
+	r.Add("app/http/users/latency", NewUnbiasedHistogram())
+	r.Add("app/requests", NewMeter())
+	r.Add("app/errors", NewCounter())
+	r.Add("other", NewCounter())
+
+	out := make(map[string]Metadata)
+	c := Chain(r,
+		IncludeRegexp(regexp.MustCompile("^app/")),
+		ExcludeType[*Meter](),
+		StripPrefix("app/"),
+		TagsFromName("http/{route}/latency"),
+	)
+	Visit(c, func(name string, metric any, md Metadata) error {
+		out[name] = md
+		return nil
+	})
+	exp := map[string]Metadata{
+		"http/latency": {Tags: map[string]string{"route": "users"}},
+		"errors":       {},
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Fatalf("Expected %+v. Got %+v", exp, out)
+	}
+
+	// Writes go through by the original names
+	c.Scope("app").Remove("errors")
+	if names := chainNames(t, r); len(names) != 3 {
+		t.Fatalf("Expected metric to be removed. Got %v", names)
+	}
+}
+
+func TestMiddlewareSwitch(t *testing.T) {
+	r := NewRegistry()
+	r.Add("a", 1)
+	r.Add("b", 2)
+	sw := NewMiddlewareSwitch()
+	c := Chain(r, sw.Middleware)
+	if names := chainNames(t, c); len(names) != 2 {
+		t.Fatalf("Expected an empty switch to pass everything. Got %v", names)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			sw.Set(ExcludeGlob("a"))
+			sw.Set()
+		}
+	}()
+	for range 100 {
+		c.Do(func(name string, metric any) error { return nil })
+	}
+	wg.Wait()
+
+	sw.Set(ExcludeGlob("a"))
+	if names := chainNames(t, c); !reflect.DeepEqual(names, []string{"b"}) {
+		t.Fatalf("Expected [b] after switching. Got %v", names)
+	}
+
+	var zero MiddlewareSwitch
+	if names := chainNames(t, Chain(r, zero.Middleware)); len(names) != 2 {
+		t.Fatalf("Expected the zero switch to pass everything. Got %v", names)
+	}
+}
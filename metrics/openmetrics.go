@@ -0,0 +1,301 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenMetricsContentType is the content type of the OpenMetrics text format.
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// OpenMetricsFamily is a metric converted to an OpenMetrics metric family.
+// It holds the values of the family's samples rather than the samples
+// themselves so that exporters of formats with the same data model, such
+// as Prometheus, can convert metrics the same way as WriteOpenMetrics.
+type OpenMetricsFamily struct {
+	// Name is the sanitized name including the unit. It doesn't include
+	// the _total suffix of counters.
+	Name string
+	// Type is counter, gauge, summary, or histogram.
+	Type     string
+	Metadata Metadata
+	// Value is the value of counters and gauges.
+	Value float64
+	// Count and Sum are the count and sum of summaries and histograms.
+	Count uint64
+	Sum   float64
+	// Quantiles and QuantileValues are the quantiles of summaries.
+	Quantiles      []float64
+	QuantileValues []float64
+	// Buckets are the upper bounds of the buckets of histograms not
+	// including +Inf, and BucketCounts the cumulative count of each one.
+	Buckets      []float64
+	BucketCounts []uint64
+}
+
+// WriteOpenMetrics writes the metrics in the registry using the OpenMetrics
+// text format. Metrics are converted by OpenMetricsFamilies. Counters are
+// written as running totals and histograms as summaries, so any latched
+// snapshot of the same registry will cause them to go backwards.
+func WriteOpenMetrics(w io.Writer, reg Registry) error {
+	var families []OpenMetricsFamily
+	err := Visit(reg, func(name string, metric any, md Metadata) error {
+		families = append(families, OpenMetricsFamilies(name, metric, md)...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(families, func(i, j int) bool {
+		return families[i].Name < families[j].Name
+	})
+
+	bw := bufio.NewWriter(w)
+	var family *OpenMetricsFamily
+	var labelSets map[string]bool
+	for i := range families {
+		f := &families[i]
+		labels := openMetricsLabels(f.Metadata.Tags)
+		if family != nil && f.Name == family.Name {
+			// Different names such as a/b and a_b are sanitized to the same
+			// family. Its samples can only be written once for every set
+			// of labels and the family can't change type.
+			if f.Type != family.Type || f.Metadata.Unit != family.Metadata.Unit || labelSets[labels] {
+				log.Printf("metrics: skipping metric that collides with OpenMetrics family %s", f.Name)
+				continue
+			}
+		} else {
+			family = f
+			labelSets = make(map[string]bool)
+			bw.WriteString("# TYPE " + f.Name + " " + f.Type + "\n")
+			if f.Metadata.Unit != "" {
+				bw.WriteString("# UNIT " + f.Name + " " + openMetricsName(f.Metadata.Unit) + "\n")
+			}
+			if f.Metadata.Description != "" {
+				bw.WriteString("# HELP " + f.Name + " " + openMetricsHelpEscaper.Replace(f.Metadata.Description) + "\n")
+			}
+		}
+		labelSets[labels] = true
+		writeOpenMetricsSamples(bw, f, labels)
+	}
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
+
+func writeOpenMetricsSamples(bw *bufio.Writer, f *OpenMetricsFamily, labels string) {
+	sample := func(suffix, label string, value float64) {
+		bw.WriteString(f.Name + suffix)
+		switch {
+		case label != "" && labels != "":
+			bw.WriteString("{" + labels + "," + label + "}")
+		case label != "":
+			bw.WriteString("{" + label + "}")
+		case labels != "":
+			bw.WriteString("{" + labels + "}")
+		}
+		bw.WriteString(" " + openMetricsValue(value) + "\n")
+	}
+	switch f.Type {
+	case "counter":
+		sample("_total", "", f.Value)
+	case "gauge":
+		sample("", "", f.Value)
+	case "summary":
+		for i, q := range f.Quantiles {
+			sample("", `quantile="`+strconv.FormatFloat(q, 'g', -1, 64)+`"`, f.QuantileValues[i])
+		}
+		sample("_count", "", float64(f.Count))
+		sample("_sum", "", f.Sum)
+	case "histogram":
+		for i, le := range f.Buckets {
+			sample("_bucket", `le="`+openMetricsValue(le)+`"`, float64(f.BucketCounts[i]))
+		}
+		sample("_bucket", `le="+Inf"`, float64(f.Count))
+		sample("_count", "", float64(f.Count))
+		sample("_sum", "", f.Sum)
+	}
+}
+
+// OpenMetricsFamilies converts a metric to the OpenMetrics families that
+// WriteOpenMetrics writes for it. It returns nil for unknown types.
+//
+// Names are sanitized and, if the metric has a unit, suffixed with it as
+// the format requires. Counters and gauges whose metadata has the counter
+// kind are counters, and the _total suffix is removed from their names.
+// Meters are gauges of their rates named with _1m, _5m, and _15m appended.
+// Histograms and distributions are summaries, with the percentiles of a
+// HistogramExport or DefaultPercentiles as quantiles, and
+// *Float64Histogram are histograms.
+//
+// A NoClearHistogram such as RollingHistogram only describes a recent
+// window so its count and sum fall as values leave it, which a summary's
+// mustn't. It's written as gauges instead: the name (with its unit) with
+// _count, _sum, and the names of the percentiles, such as _p50, appended.
+// They have no unit in their metadata since it isn't at the end of their
+// names.
+func OpenMetricsFamilies(name string, metric any, md Metadata) []OpenMetricsFamily {
+	name = openMetricsName(name)
+	gauge := func(name string, value float64) OpenMetricsFamily {
+		if md.HasKind && md.Kind == KindCounter {
+			return OpenMetricsFamily{Name: strings.TrimSuffix(name, "_total"), Type: "counter", Metadata: md, Value: value}
+		}
+		return OpenMetricsFamily{Name: name, Type: "gauge", Metadata: md, Value: value}
+	}
+	counter := func(value float64) OpenMetricsFamily {
+		if md.HasKind && md.Kind != KindCounter {
+			return gauge(name, value)
+		}
+		return OpenMetricsFamily{Name: strings.TrimSuffix(name, "_total"), Type: "counter", Metadata: md, Value: value}
+	}
+	summary := func(v DistributionValue, percentiles []float64, values []int64) OpenMetricsFamily {
+		f := OpenMetricsFamily{Name: name, Type: "summary", Metadata: md, Count: v.Count, Sum: v.Sum}
+		if len(percentiles) != 0 {
+			f.Quantiles = percentiles
+			f.QuantileValues = make([]float64, len(values))
+			for i, v := range values {
+				f.QuantileValues[i] = float64(v)
+			}
+		}
+		return f
+	}
+	histogram := func(h *Float64Histogram) OpenMetricsFamily {
+		f := OpenMetricsFamily{Name: name, Type: "histogram", Metadata: md, Sum: h.Value().Sum}
+		for i, c := range h.Counts {
+			f.Count += c
+			if le := h.Buckets[i+1]; !math.IsInf(le, 1) {
+				f.Buckets = append(f.Buckets, le)
+				f.BucketCounts = append(f.BucketCounts, f.Count)
+			}
+		}
+		return f
+	}
+
+	var families []OpenMetricsFamily
+	switch m := metric.(type) {
+	case *Float64Histogram:
+		families = []OpenMetricsFamily{histogram(m)}
+	case *EWMA:
+		families = []OpenMetricsFamily{gauge(name, m.Rate())}
+	case *EWMAGauge:
+		families = []OpenMetricsFamily{gauge(name, m.Mean())}
+	case *Meter:
+		families = []OpenMetricsFamily{
+			gauge(name+"_1m", m.OneMinuteRate()),
+			gauge(name+"_5m", m.FiveMinuteRate()),
+			gauge(name+"_15m", m.FifteenMinuteRate()),
+		}
+	case *HistogramExport:
+		if _, ok := m.Histogram.(NoClearHistogram); ok {
+			return windowGauges(name, m.Histogram, m, md)
+		}
+		v, values := DistributionAndPercentiles(m.Histogram, m.Percentiles)
+		families = []OpenMetricsFamily{summary(v, m.Percentiles, values)}
+	case NoClearHistogram:
+		return windowGauges(name, m, defaultHistogramExport(), md)
+	case Histogram:
+		v, values := DistributionAndPercentiles(m, DefaultPercentiles)
+		families = []OpenMetricsFamily{summary(v, DefaultPercentiles, values)}
+	case CounterMetric:
+		families = []OpenMetricsFamily{counter(float64(m.Count()))}
+	case Float64CounterValue:
+		families = []OpenMetricsFamily{counter(float64(m))}
+	case GaugeMetric:
+		families = []OpenMetricsFamily{gauge(name, m.Value())}
+	case DistributionMetric:
+		families = []OpenMetricsFamily{summary(m.Value(), nil, nil)}
+	case NamedDistribution:
+		families = []OpenMetricsFamily{summary(m.Value, nil, nil)}
+	}
+	return withOpenMetricsUnit(md.Unit, families)
+}
+
+// withOpenMetricsUnit appends the unit to the names of families that don't
+// already end with it.
+func withOpenMetricsUnit(unit string, families []OpenMetricsFamily) []OpenMetricsFamily {
+	if unit == "" {
+		return families
+	}
+	unit = openMetricsName(unit)
+	for i := range families {
+		if !strings.HasSuffix(families[i].Name, "_"+unit) {
+			families[i].Name += "_" + unit
+		}
+	}
+	return families
+}
+
+// windowGauges converts a NoClearHistogram to the gauges of its count, sum
+// and percentiles. The unit goes before the suffixes, as in
+// latency_seconds_p50.
+func windowGauges(name string, h Histogram, spec *HistogramExport, md Metadata) []OpenMetricsFamily {
+	name = withOpenMetricsUnit(md.Unit, []OpenMetricsFamily{{Name: name}})[0].Name
+	md.Unit = ""
+	v, values := DistributionAndPercentiles(h, spec.Percentiles)
+	families := []OpenMetricsFamily{
+		{Name: name + "_count", Type: "gauge", Metadata: md, Value: float64(v.Count)},
+		{Name: name + "_sum", Type: "gauge", Metadata: md, Value: v.Sum},
+	}
+	for i, p := range spec.percentileNames() {
+		families = append(families, OpenMetricsFamily{Name: name + "_" + openMetricsName(p), Type: "gauge", Metadata: md, Value: float64(values[i])})
+	}
+	return families
+}
+
+// openMetricsName replaces characters that aren't allowed in OpenMetrics
+// names with underscores.
+func openMetricsName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+var (
+	openMetricsHelpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	openMetricsLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// openMetricsLabels formats tags as labels sorted by name without the
+// surrounding braces.
+func openMetricsLabels(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(tags))
+	for k := range tags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for i, k := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(openMetricsName(k) + `="` + openMetricsLabelEscaper.Replace(tags[k]) + `"`)
+	}
+	return b.String()
+}
+
+func openMetricsValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,319 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// userHZ is the unit of CPU times in /proc which is fixed at 100 on all
+// mainstream architectures.
+const userHZ = 100
+
+// cgroupUnlimited is the threshold above which a cgroup v1 memory limit
+// means there's no limit. The kernel reports the largest multiple of the
+// page size below 2^63.
+const cgroupUnlimited = 1 << 62
+
+// ProcessCollection is a Collection of metrics about the current process
+// read from /proc/self and the process's cgroup. It's only useful on Linux.
+// Elsewhere, or when a file can't be read, the metrics it provides are
+// omitted.
+//
+// The metrics are:
+//
+//	cpu/user_seconds, cpu/system_seconds         CPU time used (counters)
+//	memory/rss_bytes, memory/virtual_bytes       resident and virtual memory
+//	fds/open, fds/limit                          open file descriptors and the soft limit
+//	threads                                      number of threads
+//	context_switches/voluntary                   (counter)
+//	context_switches/involuntary                 (counter)
+//	io/read_bytes, io/write_bytes                bytes read from and written to storage (counters)
+//	cgroup/memory/usage_bytes                    memory used by the cgroup
+//	cgroup/memory/limit_bytes                    memory limit of the cgroup if it has one
+//	cgroup/cpu/limit_cores                       CPU quota of the cgroup if it has one
+//	cgroup/cpu/periods                           enforcement periods elapsed (counter)
+//	cgroup/cpu/throttled_periods                 periods in which the cgroup was throttled (counter)
+//	cgroup/cpu/throttled_seconds                 time spent throttled (counter)
+type ProcessCollection struct {
+	fsys fs.FS
+}
+
+// NewProcessCollection returns a collection of metrics about the current
+// process.
+func NewProcessCollection() *ProcessCollection {
+	return newProcessCollection(os.DirFS("/"))
+}
+
+// newProcessCollection reads from fsys as if it were the root of the
+// filesystem.
+func newProcessCollection(fsys fs.FS) *ProcessCollection {
+	return &ProcessCollection{fsys: fsys}
+}
+
+func (c *ProcessCollection) Metrics() map[string]any {
+	out := make(map[string]any)
+	c.readStat(out)
+	c.readStatus(out)
+	c.readFDs(out)
+	c.readIO(out)
+	c.readCgroup(out)
+	return out
+}
+
+func (c *ProcessCollection) readStat(out map[string]any) {
+	b, err := fs.ReadFile(c.fsys, "proc/self/stat")
+	if err != nil {
+		return
+	}
+	// The command name is in parentheses and may contain spaces
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return
+	}
+	// Fields after the name start with the state which is field 3
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 13 {
+		return
+	}
+	if utime, err := strconv.ParseUint(fields[11], 10, 64); err == nil {
+		out["cpu/user_seconds"] = Float64CounterValue(float64(utime) / userHZ)
+	}
+	if stime, err := strconv.ParseUint(fields[12], 10, 64); err == nil {
+		out["cpu/system_seconds"] = Float64CounterValue(float64(stime) / userHZ)
+	}
+}
+
+func (c *ProcessCollection) readStatus(out map[string]any) {
+	c.readKeyValues("proc/self/status", ":", func(key, value string) {
+		switch key {
+		case "VmRSS":
+			if n, ok := parseKB(value); ok {
+				out["memory/rss_bytes"] = GaugeValue(n)
+			}
+		case "VmSize":
+			if n, ok := parseKB(value); ok {
+				out["memory/virtual_bytes"] = GaugeValue(n)
+			}
+		case "Threads":
+			if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+				out["threads"] = GaugeValue(n)
+			}
+		case "voluntary_ctxt_switches":
+			if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+				out["context_switches/voluntary"] = CounterValue(n)
+			}
+		case "nonvoluntary_ctxt_switches":
+			if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+				out["context_switches/involuntary"] = CounterValue(n)
+			}
+		}
+	})
+}
+
+// parseKB parses a value such as "1320 kB" from /proc/self/status.
+func parseKB(value string) (uint64, bool) {
+	n, err := strconv.ParseUint(strings.TrimSuffix(value, " kB"), 10, 64)
+	return n * 1024, err == nil
+}
+
+func (c *ProcessCollection) readFDs(out map[string]any) {
+	if entries, err := fs.ReadDir(c.fsys, "proc/self/fd"); err == nil {
+		out["fds/open"] = GaugeValue(len(entries))
+	}
+	b, err := fs.ReadFile(c.fsys, "proc/self/limits")
+	if err != nil {
+		return
+	}
+	for line := range strings.Lines(string(b)) {
+		rest, ok := strings.CutPrefix(line, "Max open files")
+		if !ok {
+			continue
+		}
+		if fields := strings.Fields(rest); len(fields) != 0 {
+			if n, err := strconv.ParseUint(fields[0], 10, 64); err == nil {
+				out["fds/limit"] = GaugeValue(n)
+			}
+		}
+	}
+}
+
+func (c *ProcessCollection) readIO(out map[string]any) {
+	c.readKeyValues("proc/self/io", ":", func(key, value string) {
+		switch key {
+		case "read_bytes":
+			if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+				out["io/read_bytes"] = CounterValue(n)
+			}
+		case "write_bytes":
+			if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+				out["io/write_bytes"] = CounterValue(n)
+			}
+		}
+	})
+}
+
+// readCgroup reads the memory and CPU controllers of the process's cgroup.
+// A controller that's mounted as cgroup v1 is preferred over the unified
+// v2 hierarchy since in hybrid setups the latter doesn't manage it.
+func (c *ProcessCollection) readCgroup(out map[string]any) {
+	b, err := fs.ReadFile(c.fsys, "proc/self/cgroup")
+	if err != nil {
+		return
+	}
+	v1 := make(map[string]string)
+	var v2 string
+	hasV2 := false
+	for line := range strings.Lines(string(b)) {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			v2, hasV2 = parts[2], true
+			continue
+		}
+		for ctrl := range strings.SplitSeq(parts[1], ",") {
+			v1[ctrl] = c.cgroupDir(path.Join("sys/fs/cgroup", parts[1]), parts[2])
+		}
+	}
+	var v2Dir string
+	if hasV2 {
+		v2Dir = c.cgroupDir("sys/fs/cgroup", v2)
+	}
+
+	if dir, ok := v1["memory"]; ok {
+		c.readCgroupV1Memory(dir, out)
+	} else if hasV2 {
+		c.readCgroupV2Memory(v2Dir, out)
+	}
+	if dir, ok := v1["cpu"]; ok {
+		c.readCgroupV1CPU(dir, out)
+	} else if hasV2 {
+		c.readCgroupV2CPU(v2Dir, out)
+	}
+}
+
+// cgroupDir returns the directory of the cgroup under the mount point. In a
+// container with its own cgroup namespace the path from /proc/self/cgroup
+// may be relative to the host's hierarchy, in which case the mount point is
+// the process's cgroup.
+func (c *ProcessCollection) cgroupDir(mount, cgroup string) string {
+	dir := path.Join(mount, strings.TrimPrefix(cgroup, "/"))
+	if _, err := fs.Stat(c.fsys, dir); err != nil {
+		return mount
+	}
+	return dir
+}
+
+func (c *ProcessCollection) readCgroupV1Memory(dir string, out map[string]any) {
+	if n, ok := c.readUint(path.Join(dir, "memory.usage_in_bytes")); ok {
+		out["cgroup/memory/usage_bytes"] = GaugeValue(n)
+	}
+	if n, ok := c.readUint(path.Join(dir, "memory.limit_in_bytes")); ok && n < cgroupUnlimited {
+		out["cgroup/memory/limit_bytes"] = GaugeValue(n)
+	}
+}
+
+func (c *ProcessCollection) readCgroupV2Memory(dir string, out map[string]any) {
+	if n, ok := c.readUint(path.Join(dir, "memory.current")); ok {
+		out["cgroup/memory/usage_bytes"] = GaugeValue(n)
+	}
+	// memory.max is "max" when there's no limit
+	if n, ok := c.readUint(path.Join(dir, "memory.max")); ok {
+		out["cgroup/memory/limit_bytes"] = GaugeValue(n)
+	}
+}
+
+func (c *ProcessCollection) readCgroupV1CPU(dir string, out map[string]any) {
+	// The quota is -1 when there's no limit
+	quota, qok := c.readInt(path.Join(dir, "cpu.cfs_quota_us"))
+	period, pok := c.readInt(path.Join(dir, "cpu.cfs_period_us"))
+	if qok && pok && quota > 0 && period > 0 {
+		out["cgroup/cpu/limit_cores"] = GaugeValue(float64(quota) / float64(period))
+	}
+	c.readKeyValues(path.Join(dir, "cpu.stat"), " ", func(key, value string) {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return
+		}
+		switch key {
+		case "nr_periods":
+			out["cgroup/cpu/periods"] = CounterValue(n)
+		case "nr_throttled":
+			out["cgroup/cpu/throttled_periods"] = CounterValue(n)
+		case "throttled_time":
+			out["cgroup/cpu/throttled_seconds"] = Float64CounterValue(float64(n) / 1e9)
+		}
+	})
+}
+
+func (c *ProcessCollection) readCgroupV2CPU(dir string, out map[string]any) {
+	// cpu.max is "$MAX $PERIOD" where $MAX is "max" when there's no limit
+	if b, err := fs.ReadFile(c.fsys, path.Join(dir, "cpu.max")); err == nil {
+		if fields := strings.Fields(string(b)); len(fields) == 2 {
+			quota, qerr := strconv.ParseUint(fields[0], 10, 64)
+			period, perr := strconv.ParseUint(fields[1], 10, 64)
+			if qerr == nil && perr == nil && period > 0 {
+				out["cgroup/cpu/limit_cores"] = GaugeValue(float64(quota) / float64(period))
+			}
+		}
+	}
+	c.readKeyValues(path.Join(dir, "cpu.stat"), " ", func(key, value string) {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return
+		}
+		switch key {
+		case "nr_periods":
+			out["cgroup/cpu/periods"] = CounterValue(n)
+		case "nr_throttled":
+			out["cgroup/cpu/throttled_periods"] = CounterValue(n)
+		case "throttled_usec":
+			out["cgroup/cpu/throttled_seconds"] = Float64CounterValue(float64(n) / 1e6)
+		}
+	})
+}
+
+// readKeyValues calls f with every line of the file split at the first sep
+// with surrounding space trimmed.
+func (c *ProcessCollection) readKeyValues(name, sep string, f func(key, value string)) {
+	file, err := c.fsys.Open(name)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		if key, value, ok := strings.Cut(s.Text(), sep); ok {
+			f(strings.TrimSpace(key), strings.TrimSpace(value))
+		}
+	}
+}
+
+func (c *ProcessCollection) readUint(name string) (uint64, bool) {
+	b, err := fs.ReadFile(c.fsys, name)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	return n, err == nil
+}
+
+func (c *ProcessCollection) readInt(name string) (int64, bool) {
+	b, err := fs.ReadFile(c.fsys, name)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	return n, err == nil
+}
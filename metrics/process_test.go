@@ -0,0 +1,81 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestProcessCollection(t *testing.T) {
+	common := map[string]any{
+		"cpu/user_seconds":             Float64CounterValue(12.34),
+		"cpu/system_seconds":           Float64CounterValue(5.67),
+		"memory/rss_bytes":             GaugeValue(21728 * 1024),
+		"memory/virtual_bytes":         GaugeValue(1075200 * 1024),
+		"threads":                      GaugeValue(9),
+		"context_switches/voluntary":   CounterValue(1500),
+		"context_switches/involuntary": CounterValue(27),
+		"fds/open":                     GaugeValue(4),
+		"fds/limit":                    GaugeValue(1024),
+		"io/read_bytes":                CounterValue(409600),
+		"io/write_bytes":               CounterValue(8192),
+	}
+	cases := []struct {
+		dir    string
+		cgroup map[string]any
+	}{
+		{
+			// cgroup v1 in a container where the cgroup's path is that of
+			// the host
+			dir: "testdata/process/v1",
+			cgroup: map[string]any{
+				"cgroup/memory/usage_bytes":    GaugeValue(104857600),
+				"cgroup/memory/limit_bytes":    GaugeValue(536870912),
+				"cgroup/cpu/limit_cores":       GaugeValue(1.5),
+				"cgroup/cpu/periods":           CounterValue(500),
+				"cgroup/cpu/throttled_periods": CounterValue(20),
+				"cgroup/cpu/throttled_seconds": Float64CounterValue(1.5),
+			},
+		},
+		{
+			// cgroup v2 without a memory limit
+			dir: "testdata/process/v2",
+			cgroup: map[string]any{
+				"cgroup/memory/usage_bytes":    GaugeValue(52428800),
+				"cgroup/cpu/limit_cores":       GaugeValue(2),
+				"cgroup/cpu/periods":           CounterValue(300),
+				"cgroup/cpu/throttled_periods": CounterValue(12),
+				"cgroup/cpu/throttled_seconds": Float64CounterValue(0.25),
+			},
+		},
+	}
+	for _, c := range cases {
+		exp := make(map[string]any)
+		for k, v := range common {
+			exp[k] = v
+		}
+		for k, v := range c.cgroup {
+			exp[k] = v
+		}
+		m := newProcessCollection(os.DirFS(c.dir)).Metrics()
+		if !reflect.DeepEqual(m, exp) {
+			t.Fatalf("%s: Expected %+v. Got %+v", c.dir, exp, m)
+		}
+	}
+
+	if m := newProcessCollection(os.DirFS("testdata/missing")).Metrics(); len(m) != 0 {
+		t.Fatalf("Expected no metrics without /proc. Got %+v", m)
+	}
+
+	if runtime.GOOS == "linux" {
+		m := NewProcessCollection().Metrics()
+		if g, _ := m["threads"].(GaugeValue); g < 1 {
+			t.Fatalf("Expected at least 1 thread. Got %+v", m["threads"])
+		}
+	}
+}
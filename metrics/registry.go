@@ -5,31 +5,180 @@
 package metrics
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"net/http"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Registry interface {
+	// Scope returns a registry for the named scope which may contain
+	// slashes to refer to a descendant. Metrics added to it are prefixed
+	// with the scope's name and walking it with Do or Visit visits only
+	// the metrics within the scope. Scopes of earlier versions of this
+	// package walked the whole registry.
 	Scope(scope string) Registry
 	Add(name string, metric any)
 	Remove(name string)
 	Do(f Doer) error
 }
 
+// The registries in this package also implement the optional interfaces
+// below. Use the functions of the same names, such as Visit, to call them
+// on any Registry. Those fall back to the methods of Registry when they
+// aren't implemented.
+
+// VisitRegistry is implemented by registries that keep metadata for their
+// metrics.
+type VisitRegistry interface {
+	Registry
+	// Visit is like Do but also provides the metadata the metric was
+	// added with.
+	Visit(f Visitor) error
+	// VisitContext is like Visit but stops early with the context's error
+	// if it's cancelled.
+	VisitContext(ctx context.Context, f Visitor) error
+}
+
+// OptionsRegistry is implemented by registries that accept metadata for
+// metrics and options for scopes.
+type OptionsRegistry interface {
+	Registry
+	// AddWithOptions is like Add but also sets the metric's metadata.
+	AddWithOptions(name string, metric any, opts ...AddOption)
+	// ScopeWithOptions is like Scope but also configures the scope. The
+	// options apply to the scope itself so they're shared with every other
+	// registry for the same scope.
+	ScopeWithOptions(scope string, opts ...ScopeOption) Registry
+}
+
+// GetOrAddRegistry is implemented by registries that can atomically look
+// up or add a metric.
+type GetOrAddRegistry interface {
+	Registry
+	// GetOrAdd returns the metric with the given name. If there's no such
+	// metric it adds the one returned by factory. The factory is called
+	// while the registry is locked so it must not use the registry.
+	GetOrAdd(name string, factory func() any, opts ...AddOption) any
+}
+
+// ScopesRegistry is implemented by registries that keep track of their
+// scopes.
+type ScopesRegistry interface {
+	Registry
+	// RemoveScope removes the named child scope along with all the metrics
+	// and scopes within it.
+	RemoveScope(scope string)
+	// Scopes returns the sorted names of the immediate child scopes.
+	Scopes() []string
+}
+
+// Visit calls f for every metric in the registry along with its metadata.
+// Metrics of a registry that isn't a VisitRegistry have no metadata.
+func Visit(r Registry, f Visitor) error {
+	return VisitContext(context.Background(), r, f)
+}
+
+// VisitContext is like Visit but stops early with the context's error if
+// it's cancelled.
+func VisitContext(ctx context.Context, r Registry, f Visitor) error {
+	if vr, ok := r.(VisitRegistry); ok {
+		return vr.VisitContext(ctx, f)
+	}
+	return r.Do(func(name string, metric any) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return f(name, metric, Metadata{})
+	})
+}
+
+// AddWithOptions adds a metric to the registry with metadata. The metadata
+// is dropped if the registry isn't an OptionsRegistry.
+func AddWithOptions(r Registry, name string, metric any, opts ...AddOption) {
+	if or, ok := r.(OptionsRegistry); ok {
+		or.AddWithOptions(name, metric, opts...)
+		return
+	}
+	r.Add(name, metric)
+}
+
+// ScopeWithOptions returns a registry for the named scope configured by
+// the options. The options are ignored if the registry isn't an
+// OptionsRegistry.
+func ScopeWithOptions(r Registry, scope string, opts ...ScopeOption) Registry {
+	if or, ok := r.(OptionsRegistry); ok {
+		return or.ScopeWithOptions(scope, opts...)
+	}
+	return r.Scope(scope)
+}
+
+// GetOrAdd returns the metric with the given name, adding the one returned
+// by factory if there's no such metric. If the registry isn't a
+// GetOrAddRegistry it's searched using Do for a metric with exactly the
+// given name, so the lookup and add aren't atomic.
+func GetOrAdd(r Registry, name string, factory func() any, opts ...AddOption) any {
+	if gr, ok := r.(GetOrAddRegistry); ok {
+		return gr.GetOrAdd(name, factory, opts...)
+	}
+	var found any
+	r.Do(func(n string, metric any) error {
+		if n == name {
+			found = metric
+			return errStopWalk
+		}
+		return nil
+	})
+	if found != nil {
+		return found
+	}
+	metric := factory()
+	AddWithOptions(r, name, metric, opts...)
+	return metric
+}
+
+// errStopWalk stops walking a registry early.
+var errStopWalk = errors.New("metrics: stop walk")
+
 type registry struct {
-	scope   string
-	metrics map[string]any
+	scope string
+	*registryRoot
+}
+
+// registryRoot is shared by a registry and all of its scopes. Metrics are
+// indexed by their full name as well as kept in the tree of scopes.
+type registryRoot struct {
+	metrics map[string]*registryEntry
+	tree    scopeNode
+	strict  bool
+	expiry  registryExpiry
 	mutex   sync.RWMutex
 }
 
-type filteredRegistry struct {
-	registry Registry
-	include  []*regexp.Regexp
-	exclude  []*regexp.Regexp
+// RegistryOption configures a registry created by NewRegistry.
+type RegistryOption func(r *registryRoot)
+
+// Strict makes Add panic if a metric with the same name already exists
+// rather than replacing it.
+func Strict() RegistryOption {
+	return func(r *registryRoot) {
+		r.strict = true
+	}
+}
+
+type registryEntry struct {
+	metric   any
+	metadata Metadata
+	activity entryActivity
 }
 
 type Collection interface {
@@ -38,12 +187,86 @@ type Collection interface {
 
 type Doer func(name string, metric any) error
 
+type Visitor func(name string, metric any, md Metadata) error
+
+// Metadata describes a metric for exporters that can make use of it.
+// Metrics belonging to a Collection only have the collection's tags.
+type Metadata struct {
+	// Description is a short human readable explanation of the metric.
+	Description string
+	// Unit is the unit of the metric's values such as UnitSeconds. Other
+	// than the predefined units it should be the plural, lowercase name of
+	// the unit.
+	Unit string
+	// Kind is only meaningful if HasKind is set. Otherwise the kind is
+	// inferred from the metric's type.
+	Kind    Kind
+	HasKind bool
+	// Tags are key/value pairs that qualify the metric, including those
+	// inherited from its scopes. The map must not be modified.
+	Tags map[string]string
+	// noExpiry exempts the metric from the registry's TTL
+	noExpiry bool
+}
+
+// Units that exporters know how to translate for their backends
+const (
+	UnitSeconds      = "seconds"
+	UnitMilliseconds = "milliseconds"
+	UnitMicroseconds = "microseconds"
+	UnitNanoseconds  = "nanoseconds"
+	UnitBytes        = "bytes"
+	UnitPercent      = "percent"
+	UnitRatio        = "ratio"
+)
+
+// AddOption sets metadata for a metric that's being added to a registry
+// by AddWithOptions or GetOrAdd.
+type AddOption func(md *Metadata)
+
+// WithDescription sets the description of a metric.
+func WithDescription(description string) AddOption {
+	return func(md *Metadata) {
+		md.Description = description
+	}
+}
+
+// WithUnit sets the unit of a metric's values.
+func WithUnit(unit string) AddOption {
+	return func(md *Metadata) {
+		md.Unit = unit
+	}
+}
+
+// WithTags sets tags for a metric. They take precedence over tags with the
+// same keys inherited from the metric's scopes.
+func WithTags(tags map[string]string) AddOption {
+	return func(md *Metadata) {
+		md.Tags = maps.Clone(tags)
+	}
+}
+
+// WithKind overrides the kind that would otherwise be inferred from the
+// metric's type, for instance to report a GaugeFunc that returns a
+// running total as a counter. It doesn't change how the value is read.
+func WithKind(kind Kind) AddOption {
+	return func(md *Metadata) {
+		md.Kind = kind
+		md.HasKind = true
+	}
+}
+
 // Registry
 
-func NewRegistry() Registry {
-	return &registry{
-		metrics: make(map[string]any),
+func NewRegistry(opts ...RegistryOption) Registry {
+	root := &registryRoot{
+		metrics: make(map[string]*registryEntry),
+		expiry:  registryExpiry{now: time.Now},
+	}
+	for _, o := range opts {
+		o(root)
 	}
+	return &registry{registryRoot: root}
 }
 
 func (r *registry) scopedName(name string) string {
@@ -54,107 +277,269 @@ func (r *registry) scopedName(name string) string {
 }
 
 func (r *registry) Scope(scope string) Registry {
+	return r.ScopeWithOptions(scope)
+}
+
+func (r *registry) ScopeWithOptions(scope string, opts ...ScopeOption) Registry {
+	scope = r.scopedName(scope)
+	if len(opts) != 0 {
+		r.mutex.Lock()
+		n := r.tree.find(scope, true)
+		n.explicit = true
+		for _, o := range opts {
+			o(n)
+		}
+		r.mutex.Unlock()
+	}
 	return &registry{
-		scope:   r.scopedName(scope),
-		metrics: r.metrics,
+		scope:        scope,
+		registryRoot: r.registryRoot,
+	}
+}
+
+func (r *registry) RemoveScope(scope string) {
+	if scope == "" {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n := r.tree.find(r.scopedName(scope), false)
+	if n == nil {
+		return
+	}
+	for _, e := range n.collect(nil, nil, nil) {
+		delete(r.metrics, e.name)
 	}
+	delete(n.parent.children, n.key)
+	n.parent.prune()
+}
+
+func (r *registry) Scopes() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if n := r.tree.find(r.scope, false); n != nil {
+		return n.childScopes()
+	}
+	return nil
+}
+
+// addLocked adds or replaces a metric. The registry must be locked.
+func (r *registryRoot) addLocked(name string, e *registryEntry) {
+	n := r.tree.find(metricScope(name), true)
+	if n.metrics == nil {
+		n.metrics = make(map[string]*registryEntry)
+	}
+	n.metrics[name] = e
+	r.metrics[name] = e
+}
+
+// removeLocked removes a metric. The registry must be locked.
+func (r *registryRoot) removeLocked(name string) {
+	if _, ok := r.metrics[name]; !ok {
+		return
+	}
+	delete(r.metrics, name)
+	if n := r.tree.find(metricScope(name), false); n != nil {
+		delete(n.metrics, name)
+		n.prune()
+	}
+}
+
+func (r *registryRoot) newEntry(metric any, opts []AddOption) *registryEntry {
+	e := &registryEntry{metric: metric}
+	for _, o := range opts {
+		o(&e.metadata)
+	}
+	if r.expiry.ttl > 0 && !e.metadata.noExpiry {
+		e.activity.init(metric, r.expiry.now())
+	}
+	return e
 }
 
 func (r *registry) Add(name string, metric any) {
+	r.AddWithOptions(name, metric)
+}
+
+func (r *registry) AddWithOptions(name string, metric any, opts ...AddOption) {
+	r.expire()
+	e := r.newEntry(metric, opts)
+	name = r.scopedName(name)
 	r.mutex.Lock()
-	r.metrics[r.scopedName(name)] = metric
-	r.mutex.Unlock()
+	defer r.mutex.Unlock()
+	if _, ok := r.metrics[name]; ok && r.strict {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.addLocked(name, e)
+}
+
+func (r *registry) GetOrAdd(name string, factory func() any, opts ...AddOption) any {
+	r.expire()
+	name = r.scopedName(name)
+	r.mutex.RLock()
+	e, ok := r.metrics[name]
+	r.mutex.RUnlock()
+	if ok {
+		r.touch(e)
+		return e.metric
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if e, ok := r.metrics[name]; ok {
+		r.touch(e)
+		return e.metric
+	}
+	e = r.newEntry(factory(), opts)
+	r.addLocked(name, e)
+	return e.metric
 }
 
 func (r *registry) Remove(name string) {
 	r.mutex.Lock()
-	delete(r.metrics, r.scopedName(name))
+	r.removeLocked(r.scopedName(name))
 	r.mutex.Unlock()
 }
 
 func (r *registry) Do(f Doer) error {
-	r.mutex.RLock()
-	defer r.mutex.RUnlock()
-	return do("", r.metrics, f)
+	return r.Visit(func(name string, metric any, _ Metadata) error {
+		return f(name, metric)
+	})
 }
 
-// FilteredRegistry
-
-func NewFilterdRegistry(registry Registry, include []*regexp.Regexp, exclude []*regexp.Regexp) Registry {
-	return &filteredRegistry{registry, include, exclude}
+func (r *registry) Visit(f Visitor) error {
+	return r.VisitContext(context.Background(), f)
 }
 
-func (r *filteredRegistry) Do(f Doer) error {
-	return r.registry.Do(func(name string, metric any) error {
-		if r.exclude != nil {
-			for _, re := range r.exclude {
-				if re.MatchString(name) {
-					return nil
-				}
-			}
-		}
-		if r.include == nil {
-			return f(name, metric)
-		}
-		for _, re := range r.include {
-			if re.MatchString(name) {
-				return f(name, metric)
-			}
-		}
-		return nil
+// VisitContext calls f for each metric within the scope in name order. The
+// registry isn't locked while f or Collection.Metrics is called so either
+// may use the registry. Metrics that are added or removed during the walk
+// may or may not be visited.
+func (r *registry) VisitContext(ctx context.Context, f Visitor) error {
+	r.expire()
+	var entries []scopedEntry
+	r.mutex.RLock()
+	if n := r.tree.find(r.scope, false); n != nil {
+		tags, filters := r.tree.ancestry(r.scope)
+		entries = n.collect(tags, filters, nil)
+	}
+	r.mutex.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
 	})
+	for _, e := range entries {
+		md := e.metadata
+		md.Tags = mergeTags(e.tags, md.Tags)
+		if err := visit(ctx, e.name, e.metric, md, e.filters, f); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (r *filteredRegistry) Scope(scope string) Registry {
-	return &filteredRegistry{r.registry.Scope(scope), r.include, r.exclude}
+// mergeTags returns the union of the tags with those in b taking precedence.
+// It avoids allocating when either is empty so the result must not be
+// modified.
+func mergeTags(a, b map[string]string) map[string]string {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	merged := make(map[string]string, len(a)+len(b))
+	maps.Copy(merged, a)
+	maps.Copy(merged, b)
+	return merged
 }
 
-func (r *filteredRegistry) Add(name string, metric any) {
-	r.registry.Add(name, metric)
+// FilteredRegistry
+
+// NewFilteredRegistry returns a registry that only includes metrics whose
+// name matches one of the include expressions and none of the exclude
+// expressions. A nil include matches everything. Use Chain for more
+// flexible filtering.
+func NewFilteredRegistry(registry Registry, include []*regexp.Regexp, exclude []*regexp.Regexp) Registry {
+	var middleware []Middleware
+	if exclude != nil {
+		middleware = append(middleware, ExcludeRegexp(exclude...))
+	}
+	if include != nil {
+		middleware = append(middleware, IncludeRegexp(include...))
+	}
+	return Chain(registry, middleware...)
 }
 
-func (r *filteredRegistry) Remove(name string) {
-	r.registry.Remove(name)
+// NewFilterdRegistry is the original, misspelled name of NewFilteredRegistry.
+//
+// Deprecated: Use NewFilteredRegistry.
+func NewFilterdRegistry(registry Registry, include []*regexp.Regexp, exclude []*regexp.Regexp) Registry {
+	return NewFilteredRegistry(registry, include, exclude)
 }
 
-func do(scope string, metrics map[string]any, f Doer) error {
-	for name, metric := range metrics {
-		if scope != "" {
-			name = scope + "/" + name
-		}
-		if collection, ok := metric.(Collection); ok {
-			met := collection.Metrics()
-			if met != nil {
-				if err := do(name, met, f); err != nil {
-					return err
-				}
+// visit calls f for the metric or, if it's a Collection, for each of the
+// metrics it contains.
+func visit(ctx context.Context, name string, metric any, md Metadata, filters []scopeFilter, f Visitor) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	collection, ok := metric.(Collection)
+	if !ok {
+		for _, filter := range filters {
+			if !filter.match(name) {
+				return nil
 			}
-		} else if err := f(name, metric); err != nil {
+		}
+		return f(name, metric, md)
+	}
+	metrics := collection.Metrics()
+	names := make([]string, 0, len(metrics))
+	for n := range metrics {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if err := visit(ctx, name+"/"+n, metrics[n], Metadata{Tags: md.Tags}, filters, f); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// RegistryHandler serves the metrics in the registry as JSON, or in the
+// OpenMetrics text format if the request accepts it.
 func RegistryHandler(reg Registry) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		fmt.Fprint(w, "{\n")
-		first := true
-		enc := json.NewEncoder(w)
-		reg.Do(func(name string, metric any) error {
-			if !first {
-				fmt.Fprint(w, ",")
-			}
-			first = false
-			fmt.Fprintf(w, "%q: ", name)
-			// Ignore any error since there's not much that can
-			// be done at this point since the headers have been sent
-			if err := enc.Encode(metric); err != nil {
-				log.Printf("metrics: failed to encode metric of type %T: %s", metric, err.Error())
+		if strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+			w.Header().Set("Content-Type", OpenMetricsContentType)
+			if err := WriteOpenMetrics(w, reg); err != nil {
+				log.Printf("metrics: failed to write OpenMetrics: %s", err.Error())
 			}
-			return nil
-		})
-		fmt.Fprint(w, "\n}\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		writeJSON(w, reg)
+		fmt.Fprint(w, "\n")
+	})
+}
+
+// writeJSON writes the metrics in the registry as a JSON object.
+func writeJSON(w io.Writer, reg Registry) {
+	fmt.Fprint(w, "{\n")
+	first := true
+	enc := json.NewEncoder(w)
+	reg.Do(func(name string, metric any) error {
+		if !first {
+			fmt.Fprint(w, ",")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: ", name)
+		// There's not much that can be done about an error at this
+		// point since part of the output has been written. Nothing is
+		// written by a failed Encode so null keeps the output valid.
+		if err := enc.Encode(metric); err != nil {
+			log.Printf("metrics: failed to encode metric of type %T: %s", metric, err.Error())
+			fmt.Fprint(w, "null")
+		}
+		return nil
 	})
+	fmt.Fprint(w, "\n}")
 }
@@ -0,0 +1,149 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type registryExpiry struct {
+	ttl       time.Duration
+	onEvict   func(name string, metric any)
+	evictions *Counter
+	now       func() time.Time
+	lastSweep atomic.Int64
+}
+
+type entryActivity struct {
+	// tracked is false for metrics whose activity can't be detected
+	tracked     bool
+	fingerprint uint64
+	// active is when activity was last seen in nanoseconds since the epoch
+	active atomic.Int64
+}
+
+type stopper interface {
+	Stop()
+}
+
+// WithTTL removes metrics that haven't been active for the given duration.
+// A counter, meter or histogram is active when its count changes, or when
+// it's added or returned by GetOrAdd. Gauges and metrics whose activity
+// can't be detected, such as collections and EWMAs, never expire since a
+// gauge that's set to the same value isn't idle. Expired metrics that have
+// a Stop method, such as meters, are stopped.
+//
+// Expiry is lazy. It's only checked by Add, GetOrAdd and Visit (which
+// includes Do and taking a snapshot), at most every quarter of the TTL,
+// so nothing expires from a registry that isn't used. Changes are only
+// noticed if the counts differ between checks, so a counter that's latched
+// by a snapshot and updated by the same amount in every interval may
+// appear idle.
+func WithTTL(ttl time.Duration) RegistryOption {
+	return func(r *registryRoot) {
+		r.expiry.ttl = ttl
+	}
+}
+
+// WithoutExpiry exempts a metric from the registry's TTL. It's for metrics
+// whose owner keeps using them after they're added, such as those cached
+// by instrumentation, which would otherwise go on being updated after
+// they've expired and been removed.
+func WithoutExpiry() AddOption {
+	return func(md *Metadata) {
+		md.noExpiry = true
+	}
+}
+
+// WithEvictionCallback sets a function that's called with every metric
+// that expires. It's called after the metric is removed and stopped and
+// while the registry isn't locked.
+func WithEvictionCallback(f func(name string, metric any)) RegistryOption {
+	return func(r *registryRoot) {
+		r.expiry.onEvict = f
+	}
+}
+
+// WithEvictionCounter sets a counter that's incremented for every metric
+// that expires.
+func WithEvictionCounter(c *Counter) RegistryOption {
+	return func(r *registryRoot) {
+		r.expiry.evictions = c
+	}
+}
+
+// activityFingerprint returns a value that changes whenever the metric is
+// updated. It returns false for metrics that shouldn't expire.
+func activityFingerprint(metric any) (uint64, bool) {
+	switch m := metric.(type) {
+	case *HistogramExport:
+		return m.Histogram.Distribution().Count, true
+	case Histogram:
+		return m.Distribution().Count, true
+	case CounterMetric:
+		return m.Count(), true
+	case DistributionMetric:
+		return m.Value().Count, true
+	}
+	return 0, false
+}
+
+func (a *entryActivity) init(metric any, now time.Time) {
+	a.fingerprint, a.tracked = activityFingerprint(metric)
+	a.active.Store(now.UnixNano())
+}
+
+func (r *registryRoot) touch(e *registryEntry) {
+	if r.expiry.ttl > 0 {
+		e.activity.active.Store(r.expiry.now().UnixNano())
+	}
+}
+
+// expire removes metrics that have been idle for longer than the TTL.
+func (r *registryRoot) expire() {
+	if r.expiry.ttl <= 0 {
+		return
+	}
+	now := r.expiry.now().UnixNano()
+	last := r.expiry.lastSweep.Load()
+	if now-last < int64(r.expiry.ttl/4) || !r.expiry.lastSweep.CompareAndSwap(last, now) {
+		return
+	}
+
+	type evicted struct {
+		name   string
+		metric any
+	}
+	var expired []evicted
+	r.mutex.Lock()
+	for name, e := range r.metrics {
+		if !e.activity.tracked {
+			continue
+		}
+		if fp, _ := activityFingerprint(e.metric); fp != e.activity.fingerprint {
+			e.activity.fingerprint = fp
+			e.activity.active.Store(now)
+			continue
+		}
+		if now-e.activity.active.Load() >= int64(r.expiry.ttl) {
+			r.removeLocked(name)
+			expired = append(expired, evicted{name, e.metric})
+		}
+	}
+	r.mutex.Unlock()
+
+	for _, e := range expired {
+		if s, ok := e.metric.(stopper); ok {
+			s.Stop()
+		}
+		if r.expiry.evictions != nil {
+			r.expiry.evictions.Inc(1)
+		}
+		if r.expiry.onEvict != nil {
+			r.expiry.onEvict(e.name, e.metric)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRegistryExpiry(t *testing.T) {
+	var evicted []string
+	evictions := NewCounter()
+	r := NewRegistry(
+		WithTTL(time.Minute),
+		WithEvictionCounter(evictions),
+		WithEvictionCallback(func(name string, metric any) {
+			evicted = append(evicted, name)
+		}),
+	)
+	now := time.Unix(1700000000, 0)
+	r.(*registry).expiry.now = func() time.Time { return now }
+
+	idle := NewCounter()
+	r.Add("idle", idle)
+	busy := NewCounter()
+	r.Add("busy", busy)
+	stopped := &stoppableCounter{}
+	r.Add("stoppable", stopped)
+	r.Add("ewma", NewEWMA(time.Second, M1Alpha))
+	r.Add("gauge", GaugeValue(1))
+	// The meter is stopped when it expires
+	r.Add("meter", NewMeter())
+	r.Scope("route").Add("touched", NewCounter())
+	AddWithOptions(r, "cached", NewCounter(), WithoutExpiry())
+
+	names := func() []string {
+		var out []string
+		r.Do(func(name string, metric any) error {
+			out = append(out, name)
+			return nil
+		})
+		sort.Strings(out)
+		return out
+	}
+
+	for range 4 {
+		now = now.Add(time.Second * 20)
+		busy.Inc(1)
+		if _, err := GetOrAddCounter(r, "route/touched"); err != nil {
+			t.Fatal(err)
+		}
+		names()
+	}
+	exp := []string{"busy", "cached", "ewma", "gauge", "route/touched"}
+	if n := names(); !reflect.DeepEqual(n, exp) {
+		t.Fatalf("Expected %v. Got %v", exp, n)
+	}
+	sort.Strings(evicted)
+	if exp := []string{"idle", "meter", "stoppable"}; !reflect.DeepEqual(evicted, exp) {
+		t.Fatalf("Expected eviction callback for %v. Got %v", exp, evicted)
+	}
+	if evictions.Count() != 3 {
+		t.Fatalf("Expected 3 evictions. Got %d", evictions.Count())
+	}
+	if !stopped.stopped {
+		t.Fatal("Expected evicted metric to be stopped")
+	}
+}
+
+type stoppableCounter struct {
+	CounterValue
+	stopped bool
+}
+
+func (c *stoppableCounter) Stop() {
+	c.stopped = true
+}
+
+func TestRegistryExpiryDisabled(t *testing.T) {
+	r := NewRegistry()
+	r.Add("idle", NewCounter())
+	r.(*registry).expiry.now = func() time.Time { return time.Now().Add(time.Hour * 24 * 365) }
+	n := 0
+	r.Do(func(name string, metric any) error {
+		n++
+		return nil
+	})
+	if n != 1 {
+		t.Fatalf("Expected metrics not to expire without a TTL. Got %d metrics", n)
+	}
+}
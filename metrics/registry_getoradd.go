@@ -0,0 +1,59 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTypeMismatch is returned (wrapped) by the GetOrAdd functions when a
+// metric with the name already exists but has a different type.
+var ErrTypeMismatch = errors.New("metrics: metric already exists with a different type")
+
+// GetOrAddMetric returns the metric with the given name if it has type T.
+// If there's no such metric it adds the one returned by factory. Options
+// only apply when the metric is added.
+func GetOrAddMetric[T any](r Registry, name string, factory func() T, opts ...AddOption) (T, error) {
+	m := GetOrAdd(r, name, func() any { return factory() }, opts...)
+	if t, ok := m.(T); ok {
+		return t, nil
+	}
+	var zero T
+	return zero, fmt.Errorf("%w: %s is a %T", ErrTypeMismatch, name, m)
+}
+
+// GetOrAddCounter returns the *Counter with the given name, adding a new
+// one if needed.
+func GetOrAddCounter(r Registry, name string, opts ...AddOption) (*Counter, error) {
+	return GetOrAddMetric(r, name, NewCounter, opts...)
+}
+
+// GetOrAddIntegerGauge returns the *IntegerGauge with the given name,
+// adding a new one if needed.
+func GetOrAddIntegerGauge(r Registry, name string, opts ...AddOption) (*IntegerGauge, error) {
+	return GetOrAddMetric(r, name, NewIntegerGauge, opts...)
+}
+
+// GetOrAddMeter returns the *Meter with the given name, adding a new one
+// if needed. A new meter is only created, and its ticker started, if it's
+// actually added.
+func GetOrAddMeter(r Registry, name string, opts ...AddOption) (*Meter, error) {
+	return GetOrAddMetric(r, name, NewMeter, opts...)
+}
+
+// GetOrAddHistogram returns the histogram with the given name, adding the
+// one returned by factory if needed. If the existing metric is a
+// *HistogramExport the histogram it wraps is returned.
+func GetOrAddHistogram(r Registry, name string, factory func() Histogram, opts ...AddOption) (Histogram, error) {
+	m := GetOrAdd(r, name, func() any { return factory() }, opts...)
+	switch h := m.(type) {
+	case Histogram:
+		return h, nil
+	case *HistogramExport:
+		return h.Histogram, nil
+	}
+	return nil, fmt.Errorf("%w: %s is a %T", ErrTypeMismatch, name, m)
+}
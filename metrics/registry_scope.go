@@ -0,0 +1,177 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"maps"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ScopeOption configures a scope returned by ScopeWithOptions. Options
+// replace any previously set for the same scope.
+type ScopeOption func(n *scopeNode)
+
+// WithScopeTags sets tags that apply to every metric in the scope and its
+// child scopes. Tags of a child scope take precedence over those of its
+// parents, and tags of a metric over those of its scopes.
+func WithScopeTags(tags map[string]string) ScopeOption {
+	return func(n *scopeNode) {
+		n.tags = maps.Clone(tags)
+	}
+}
+
+// WithScopeFilter only includes metrics in the scope and its child scopes
+// whose full name matches one of the include expressions (if any) and none
+// of the exclude expressions. It applies to anyone that walks the registry.
+func WithScopeFilter(include, exclude []*regexp.Regexp) ScopeOption {
+	return func(n *scopeNode) {
+		n.filter = scopeFilter{include, exclude}
+	}
+}
+
+// scopeNode is a node in the tree of scopes. Metrics belong to the scope
+// given by their name up to the last slash regardless of which scope they
+// were added through.
+type scopeNode struct {
+	parent   *scopeNode
+	key      string
+	children map[string]*scopeNode
+	metrics  map[string]*registryEntry
+	tags     map[string]string
+	filter   scopeFilter
+	// explicit nodes were configured with options so they're kept even
+	// when empty
+	explicit bool
+}
+
+type scopeFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func (f scopeFilter) empty() bool {
+	return f.include == nil && f.exclude == nil
+}
+
+func (f scopeFilter) match(name string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if f.include == nil {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// metricScope returns the path of the scope a metric belongs to.
+func metricScope(name string) string {
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// find returns the node for the scope path relative to n. If create is
+// false and the node doesn't exist it returns nil.
+func (n *scopeNode) find(path string, create bool) *scopeNode {
+	if path == "" {
+		return n
+	}
+	for key := range strings.SplitSeq(path, "/") {
+		c := n.children[key]
+		if c == nil {
+			if !create {
+				return nil
+			}
+			c = &scopeNode{parent: n, key: key}
+			if n.children == nil {
+				n.children = make(map[string]*scopeNode)
+			}
+			n.children[key] = c
+		}
+		n = c
+	}
+	return n
+}
+
+// prune removes the node and its ancestors while they're no longer needed.
+func (n *scopeNode) prune() {
+	for n.parent != nil && !n.explicit && len(n.children) == 0 && len(n.metrics) == 0 {
+		delete(n.parent.children, n.key)
+		n = n.parent
+	}
+}
+
+// childScopes returns the sorted names of the node's children.
+func (n *scopeNode) childScopes() []string {
+	names := make([]string, 0, len(n.children))
+	for key := range n.children {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scopedEntry is a metric along with what it inherits from its scopes.
+type scopedEntry struct {
+	name string
+	*registryEntry
+	tags    map[string]string
+	filters []scopeFilter
+}
+
+// collect appends the metrics in the node and its children to entries.
+func (n *scopeNode) collect(tags map[string]string, filters []scopeFilter, entries []scopedEntry) []scopedEntry {
+	tags, filters = n.inherit(tags, filters)
+	for name, e := range n.metrics {
+		entries = append(entries, scopedEntry{name, e, tags, filters})
+	}
+	for _, c := range n.children {
+		entries = c.collect(tags, filters, entries)
+	}
+	return entries
+}
+
+// inherit adds the node's tags and filter to those of its parents. The
+// parents' map and slice are never modified since they're shared.
+func (n *scopeNode) inherit(tags map[string]string, filters []scopeFilter) (map[string]string, []scopeFilter) {
+	if len(n.tags) != 0 {
+		merged := make(map[string]string, len(tags)+len(n.tags))
+		maps.Copy(merged, tags)
+		maps.Copy(merged, n.tags)
+		tags = merged
+	}
+	if !n.filter.empty() {
+		filters = append(filters[:len(filters):len(filters)], n.filter)
+	}
+	return tags, filters
+}
+
+// ancestry returns what the node at path inherits from its ancestors, not
+// including the node itself.
+func (n *scopeNode) ancestry(path string) (map[string]string, []scopeFilter) {
+	var tags map[string]string
+	var filters []scopeFilter
+	if path == "" {
+		return nil, nil
+	}
+	keys := strings.Split(path, "/")
+	for _, key := range keys[:len(keys)-1] {
+		tags, filters = n.inherit(tags, filters)
+		if n = n.children[key]; n == nil {
+			return tags, filters
+		}
+	}
+	return n.inherit(tags, filters)
+}
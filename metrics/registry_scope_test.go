@@ -0,0 +1,117 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func visitedMetadata(t *testing.T, r Registry) map[string]Metadata {
+	out := make(map[string]Metadata)
+	if err := Visit(r, func(name string, metric any, md Metadata) error {
+		out[name] = md
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestRegistryScopes(t *testing.T) {
+	r := NewRegistry()
+	r.Add("uptime", 1)
+	cache := r.Scope("cache")
+	cache.Scope("redis").Add("hits", 1)
+	cache.Add("redis/misses", 2)
+	cache.Scope("memcache").Add("hits", 3)
+	r.Scope("db").Add("queries", 4)
+
+	if s := r.(ScopesRegistry).Scopes(); !reflect.DeepEqual(s, []string{"cache", "db"}) {
+		t.Fatalf("Expected scopes [cache db]. Got %v", s)
+	}
+	if s := cache.(ScopesRegistry).Scopes(); !reflect.DeepEqual(s, []string{"memcache", "redis"}) {
+		t.Fatalf("Expected scopes [memcache redis]. Got %v", s)
+	}
+
+	// Walking a scope only visits its own metrics
+	var names []string
+	cache.Do(func(name string, metric any) error {
+		names = append(names, name)
+		return nil
+	})
+	if exp := []string{"cache/memcache/hits", "cache/redis/hits", "cache/redis/misses"}; !reflect.DeepEqual(names, exp) {
+		t.Fatalf("Expected %v. Got %v", exp, names)
+	}
+
+	r.(ScopesRegistry).RemoveScope("cache/redis")
+	if s := cache.(ScopesRegistry).Scopes(); !reflect.DeepEqual(s, []string{"memcache"}) {
+		t.Fatalf("Expected scopes [memcache] after removal. Got %v", s)
+	}
+	if _, ok := visitedMetadata(t, r)["cache/redis/hits"]; ok {
+		t.Fatal("Expected metrics in removed scope to be gone")
+	}
+	// Scopes that become empty disappear unless they were configured
+	r.Scope("db").Remove("queries")
+	if s := r.(ScopesRegistry).Scopes(); !reflect.DeepEqual(s, []string{"cache"}) {
+		t.Fatalf("Expected scopes [cache] after removing the last metric. Got %v", s)
+	}
+	ScopeWithOptions(r, "configured", WithScopeTags(map[string]string{"a": "b"}))
+	if s := r.(ScopesRegistry).Scopes(); !reflect.DeepEqual(s, []string{"cache", "configured"}) {
+		t.Fatalf("Expected configured scope to be kept. Got %v", s)
+	}
+}
+
+func TestRegistryScopeTags(t *testing.T) {
+	r := NewRegistry()
+	svc := ScopeWithOptions(r, "svc", WithScopeTags(map[string]string{"service": "api", "env": "prod"}))
+	db := ScopeWithOptions(svc, "db", WithScopeTags(map[string]string{"env": "test"}))
+	db.Add("queries", NewCounter())
+	AddWithOptions(db, "errors", NewCounter(), WithTags(map[string]string{"service": "db"}))
+	svc.Add("requests", NewCounter())
+	r.Add("uptime", NewCounter())
+
+	md := visitedMetadata(t, r)
+	exp := map[string]map[string]string{
+		"svc/db/queries": {"service": "api", "env": "test"},
+		"svc/db/errors":  {"service": "db", "env": "test"},
+		"svc/requests":   {"service": "api", "env": "prod"},
+		"uptime":         nil,
+	}
+	for name, tags := range exp {
+		if !reflect.DeepEqual(md[name].Tags, tags) {
+			t.Errorf("Expected tags %v for %s. Got %v", tags, name, md[name].Tags)
+		}
+	}
+	// Tags are inherited when walking a child scope too
+	if tags := visitedMetadata(t, db)["svc/db/queries"].Tags; !reflect.DeepEqual(tags, exp["svc/db/queries"]) {
+		t.Errorf("Expected tags %v when walking the scope. Got %v", exp["svc/db/queries"], tags)
+	}
+
+	snap := NewRegistrySnapshot(false)
+	snap.Snapshot(r)
+	for _, v := range snap.Values {
+		if !reflect.DeepEqual(v.Tags, exp[v.Name]) {
+			t.Errorf("Expected snapshot tags %v for %s. Got %v", exp[v.Name], v.Name, v.Tags)
+		}
+	}
+}
+
+func TestRegistryScopeFilter(t *testing.T) {
+	r := NewRegistry()
+	ScopeWithOptions(r, "cache", WithScopeFilter(nil, []*regexp.Regexp{regexp.MustCompile("debug")}))
+	r.Add("cache/hits", 1)
+	r.Add("cache/debug/evictions", 2)
+	r.Add("debug/enabled", 3)
+
+	md := visitedMetadata(t, r)
+	if _, ok := md["cache/debug/evictions"]; ok {
+		t.Fatal("Expected filtered metric to be excluded")
+	}
+	if len(md) != 2 {
+		t.Fatalf("Expected filter to only apply to its scope. Got %v", md)
+	}
+}
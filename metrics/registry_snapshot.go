@@ -1,10 +1,73 @@
 package metrics
 
-import "log"
+import (
+	"context"
+	"log"
+	"maps"
+	"strconv"
+	"time"
+)
+
+// Kind describes how a reported value should be interpreted.
+type Kind int
+
+const (
+	// KindGauge is a point in time value. It's the zero value so values
+	// that aren't otherwise identified are treated as gauges.
+	KindGauge Kind = iota
+	// KindCounter is a count of events. Whether it's the count since the
+	// previous snapshot or since the counter was created depends on the
+	// snapshot's Temporality.
+	KindCounter
+	// KindRate is a rate of events per second.
+	KindRate
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindGauge:
+		return "gauge"
+	case KindCounter:
+		return "counter"
+	case KindRate:
+		return "rate"
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Temporality selects whether counters and histograms are reported as the
+// change over each snapshot's interval or as running totals.
+type Temporality int
+
+const (
+	// TemporalityDelta reports the change since the previous snapshot.
+	// This is what StatsD style backends such as Datadog and CloudWatch
+	// expect.
+	TemporalityDelta Temporality = iota
+	// TemporalityCumulative reports monotonic totals since the first
+	// snapshot. This is what Prometheus style backends expect.
+	TemporalityCumulative
+)
+
+func (t Temporality) String() string {
+	switch t {
+	case TemporalityDelta:
+		return "delta"
+	case TemporalityCumulative:
+		return "cumulative"
+	}
+	return "Temporality(" + strconv.Itoa(int(t)) + ")"
+}
 
 type NamedValue struct {
 	Name  string
 	Value float64
+	Kind  Kind
+	// Description, Unit, and Tags come from the metric's Metadata. Tags
+	// may be shared so they must not be modified.
+	Description string
+	Unit        string
+	Tags        map[string]string
 }
 
 type NamedGroup struct {
@@ -15,82 +78,410 @@ type NamedGroup struct {
 type NamedDistribution struct {
 	Name  string
 	Value DistributionValue
+	// Stats are the statistics that should be reported for the
+	// distribution. If zero the reporter uses its defaults.
+	Stats Stats
+	// Description, Unit, and Tags come from the metric's Metadata. Tags
+	// may be shared so they must not be modified.
+	Description string
+	Unit        string
+	Tags        map[string]string
 }
 
 type RegistrySnapshot struct {
 	Values        []NamedValue
 	Distributions []NamedDistribution
+	// Start is the beginning of the interval covered by the most recent
+	// snapshot. That's when the previous snapshot was taken, or for the
+	// first snapshot when the RegistrySnapshot was created.
+	Start time.Time
+	// Time is when the most recent snapshot was taken. Reporters should use
+	// it to timestamp values rather than the time they get around to sending.
+	// Both Start and Time include a monotonic clock reading.
+	Time time.Time
 
-	resetOnSnapshot bool
+	options     SnapshotOptions
+	snapshotted bool
+	// pass counts the calls to Snapshot. seen holds the names read by the
+	// latest one and windows the pass that last read each window so that
+	// the state of metrics that are gone can be dropped.
+	pass            uint64
+	seen            map[string]struct{}
+	windows         map[WindowedHistogram]uint64
 	counterValues   map[string]uint64
+	float64Values   map[string]*Float64Histogram
+	float64Counters map[string]float64
+	counterTotals   map[string]float64
+	histogramCounts map[string]cumulativeHistogram
+}
+
+// cumulativeHistogram tracks a histogram's count so that a histogram that
+// was cleared behind the snapshot's back keeps reporting monotonic totals.
+type cumulativeHistogram struct {
+	count, countOffset uint64
+	sum, sumOffset     float64
+	clears             uint64
+}
+
+// clearedHistogram is implemented by the histograms in this package to
+// count the calls to Clear so that a cumulative snapshot notices that a
+// histogram was cleared even if it has since recorded more values than it
+// had before.
+type clearedHistogram interface {
+	clears() uint64
 }
 
+// SnapshotOptions control how a RegistrySnapshot reads metrics.
+type SnapshotOptions struct {
+	// ResetOnSnapshot latches the snapshot: counters with a Reset method,
+	// such as *Counter and *StripedCounter, are reset every time they're
+	// read.
+	ResetOnSnapshot bool
+	// KeepHistograms stops the snapshot from clearing plain histograms
+	// every time it reads them, so they report every value recorded since
+	// their creation and several snapshots can read the same registry. A
+	// WindowedHistogram gives every snapshot the values since its own
+	// previous snapshot instead. A NoClearHistogram such as
+	// RollingHistogram is never cleared since it already covers a window.
+	KeepHistograms bool
+	// CounterRates reports counters as a per-second rate over the interval
+	// covered by the snapshot instead of as a delta or total. A counter has
+	// no rate the first time it's read since its count covers more than
+	// the interval, so it's left out of that snapshot.
+	CounterRates bool
+	// Temporality selects between reporting deltas (the default) and
+	// cumulative totals. With TemporalityCumulative nothing is ever reset
+	// or cleared, regardless of ResetOnSnapshot, except that latched
+	// counters are still reset with their values added to the reported
+	// total. A WindowedHistogram is read in full rather than by window, and
+	// a NoClearHistogram is reported as it is since its values cover a
+	// window rather than its lifetime.
+	Temporality Temporality
+}
+
+// NewRegistrySnapshot returns a snapshot that reports counters as the delta
+// since the previous snapshot and clears plain histograms every time it
+// reads them. If resetOnSnapshot is true the snapshot is latched: a
+// *Counter is reset every time it's read. Use a WindowedHistogram, or
+// SnapshotOptions.KeepHistograms, to have several snapshots read the same
+// histograms.
 func NewRegistrySnapshot(resetOnSnapshot bool) *RegistrySnapshot {
+	return NewRegistrySnapshotWithOptions(SnapshotOptions{ResetOnSnapshot: resetOnSnapshot})
+}
+
+// NewRegistrySnapshotWithOptions returns a snapshot configured by options.
+func NewRegistrySnapshotWithOptions(options SnapshotOptions) *RegistrySnapshot {
 	return &RegistrySnapshot{
-		resetOnSnapshot: resetOnSnapshot,
-		counterValues:   make(map[string]uint64),
+		Time:          time.Now(),
+		options:       options,
+		counterValues: make(map[string]uint64),
+	}
+}
+
+// Release discards the windows the snapshot has in WindowedHistograms. It
+// should be called once the snapshot is no longer used, otherwise every
+// value recorded in a windowed histogram keeps being added to the
+// snapshot's window. If the snapshot is used again its windows start over.
+func (rs *RegistrySnapshot) Release() {
+	for w := range rs.windows {
+		w.Release(rs)
+	}
+	rs.windows = nil
+}
+
+// Temporality returns whether the snapshot reports deltas or cumulative
+// totals for counters and histograms.
+func (rs *RegistrySnapshot) Temporality() Temporality {
+	return rs.options.Temporality
+}
+
+// Interval returns the duration covered by the most recent snapshot
+// measured using the monotonic clock.
+func (rs *RegistrySnapshot) Interval() time.Duration {
+	if rs.Start.IsZero() {
+		return 0
 	}
+	return rs.Time.Sub(rs.Start)
+}
+
+// Clone returns a copy of the values from the most recent snapshot that
+// isn't affected by later calls to Snapshot.
+func (rs *RegistrySnapshot) Clone() *RegistrySnapshot {
+	c := NewRegistrySnapshotWithOptions(rs.options)
+	c.Values = append([]NamedValue(nil), rs.Values...)
+	c.Distributions = append([]NamedDistribution(nil), rs.Distributions...)
+	c.Start = rs.Start
+	c.Time = rs.Time
+	return c
 }
 
+// resettableCounter is a counter such as *Counter or *StripedCounter that
+// latched snapshots reset.
+type resettableCounter interface {
+	CounterMetric
+	Reset() uint64
+}
+
+// Snapshot reads the metrics in the registry. The state kept to compute
+// deltas, rates and cumulative totals for a metric is dropped once the
+// metric is no longer in the registry, for instance when it expires, so a
+// metric that comes back starts over.
 func (rs *RegistrySnapshot) Snapshot(registry Registry) {
 	rs.Values = rs.Values[:0]
 	rs.Distributions = rs.Distributions[:0]
-	registry.Do(func(name string, metric any) error {
+	rs.Start = rs.Time
+	rs.Time = time.Now()
+	rs.pass++
+	if rs.seen == nil {
+		rs.seen = make(map[string]struct{})
+	}
+	clear(rs.seen)
+	Visit(registry, func(name string, metric any, md Metadata) error {
+		rs.seen[name] = struct{}{}
+		nValues, nDistributions := len(rs.Values), len(rs.Distributions)
 		switch m := metric.(type) {
 		case *EWMA:
-			rs.Values = append(rs.Values, NamedValue{Name: name, Value: m.Rate()})
+			rs.Values = append(rs.Values, NamedValue{Name: name, Value: m.Rate(), Kind: KindRate})
 		case *EWMAGauge:
 			rs.Values = append(rs.Values, NamedValue{Name: name, Value: m.Mean()})
 		case *Meter:
 			rs.Values = append(rs.Values,
-				NamedValue{Name: name + "/1m", Value: m.OneMinuteRate()},
-				NamedValue{Name: name + "/5m", Value: m.FiveMinuteRate()},
-				NamedValue{Name: name + "/15m", Value: m.FifteenMinuteRate()},
+				NamedValue{Name: name + "/1m", Value: m.OneMinuteRate(), Kind: KindRate},
+				NamedValue{Name: name + "/5m", Value: m.FiveMinuteRate(), Kind: KindRate},
+				NamedValue{Name: name + "/15m", Value: m.FifteenMinuteRate(), Kind: KindRate},
 			)
+		case *HistogramExport:
+			rs.addHistogram(name, m.Histogram, m)
 		case Histogram:
-			v := m.Distribution()
-			if v.Count > 0 {
-				perc := m.Percentiles(DefaultPercentiles)
-				m.Clear()
-				rs.Distributions = append(rs.Distributions, NamedDistribution{Name: name, Value: v})
-				for i, p := range perc {
-					rs.Values = append(rs.Values, NamedValue{
-						Name:  name + "/" + DefaultPercentileNames[i],
-						Value: float64(p),
-					})
-				}
-			}
-		case *Counter:
-			if rs.resetOnSnapshot {
-				rs.Values = append(rs.Values, NamedValue{Name: name, Value: float64(m.Reset())})
+			rs.addHistogram(name, m, defaultHistogramExport())
+		case resettableCounter:
+			if rs.options.ResetOnSnapshot {
+				rs.addCounter(name, float64(m.Reset()), !rs.snapshotted)
 			} else {
-				oldValue := rs.counterValues[name]
-				newValue := m.Count()
-				rs.counterValues[name] = newValue
-				delta := newValue
-				if newValue >= oldValue {
-					delta = newValue - oldValue
-				}
-				rs.Values = append(rs.Values, NamedValue{Name: name, Value: float64(delta)})
+				delta, first := rs.counterDelta(name, m.Count())
+				rs.addCounter(name, float64(delta), first)
 			}
 		case CounterMetric:
-			oldValue := rs.counterValues[name]
-			newValue := m.Count()
-			rs.counterValues[name] = newValue
-			delta := newValue
-			if newValue >= oldValue {
-				delta = newValue - oldValue
-			}
-			rs.Values = append(rs.Values, NamedValue{Name: name, Value: float64(delta)})
+			delta, first := rs.counterDelta(name, m.Count())
+			rs.addCounter(name, float64(delta), first)
+		case Float64CounterValue:
+			delta, first := rs.float64CounterDelta(name, float64(m))
+			rs.addCounter(name, delta, first)
 		case GaugeMetric:
 			rs.Values = append(rs.Values, NamedValue{Name: name, Value: m.Value()})
+		case *Float64Histogram:
+			rs.addFloat64Histogram(name, m, defaultHistogramExport())
 		case DistributionMetric:
 			rs.Distributions = append(rs.Distributions, NamedDistribution{Name: name, Value: m.Value()})
 		default:
 			log.Printf("metrics.RegistrySnapshot: unrecognized metric type for %s: %T %+v", name, m, m)
 		}
+		rs.applyMetadata(metric, md, rs.Values[nValues:], rs.Distributions[nDistributions:])
 		return nil
 	})
+	rs.snapshotted = true
+	rs.forget()
+}
+
+// forget drops the state kept for metrics that the latest pass didn't read
+// so that it doesn't grow without bound as metrics come and go.
+func (rs *RegistrySnapshot) forget() {
+	maps.DeleteFunc(rs.counterValues, func(name string, _ uint64) bool { return !rs.wasSeen(name) })
+	maps.DeleteFunc(rs.counterTotals, func(name string, _ float64) bool { return !rs.wasSeen(name) })
+	maps.DeleteFunc(rs.float64Values, func(name string, _ *Float64Histogram) bool { return !rs.wasSeen(name) })
+	maps.DeleteFunc(rs.float64Counters, func(name string, _ float64) bool { return !rs.wasSeen(name) })
+	maps.DeleteFunc(rs.histogramCounts, func(name string, _ cumulativeHistogram) bool { return !rs.wasSeen(name) })
+	maps.DeleteFunc(rs.windows, func(w WindowedHistogram, pass uint64) bool {
+		if pass != rs.pass {
+			w.Release(rs)
+			return true
+		}
+		return false
+	})
+}
+
+func (rs *RegistrySnapshot) wasSeen(name string) bool {
+	_, ok := rs.seen[name]
+	return ok
+}
+
+// applyMetadata copies a metric's metadata to the values and distributions
+// it produced. An explicit kind doesn't apply to histograms since their
+// values are all percentiles.
+func (rs *RegistrySnapshot) applyMetadata(metric any, md Metadata, values []NamedValue, distributions []NamedDistribution) {
+	isHistogram := false
+	switch metric.(type) {
+	case Histogram, *HistogramExport, *Float64Histogram:
+		isHistogram = true
+	}
+	for i := range values {
+		values[i].Description = md.Description
+		values[i].Unit = md.Unit
+		values[i].Tags = md.Tags
+		if md.HasKind && !isHistogram {
+			values[i].Kind = md.Kind
+		}
+	}
+	for i := range distributions {
+		distributions[i].Description = md.Description
+		distributions[i].Unit = md.Unit
+		distributions[i].Tags = md.Tags
+	}
+}
+
+// counterDelta returns the change in a counter since the previous snapshot
+// and whether this is the first time the counter is seen. A counter that
+// went backwards is assumed to have been reset, so the delta is its entire
+// new value.
+func (rs *RegistrySnapshot) counterDelta(name string, newValue uint64) (uint64, bool) {
+	oldValue, seen := rs.counterValues[name]
+	rs.counterValues[name] = newValue
+	if newValue >= oldValue {
+		return newValue - oldValue, !seen
+	}
+	return newValue, !seen
+}
+
+// float64CounterDelta is like counterDelta for counters that aren't whole
+// numbers.
+func (rs *RegistrySnapshot) float64CounterDelta(name string, newValue float64) (float64, bool) {
+	if rs.float64Counters == nil {
+		rs.float64Counters = make(map[string]float64)
+	}
+	oldValue, seen := rs.float64Counters[name]
+	rs.float64Counters[name] = newValue
+	if newValue >= oldValue {
+		return newValue - oldValue, !seen
+	}
+	return newValue, !seen
+}
+
+// addCounter adds a counter's delta. First is true when the delta may
+// include values from before the snapshot's interval, such as a counter's
+// lifetime count the first time it's read.
+func (rs *RegistrySnapshot) addCounter(name string, delta float64, first bool) {
+	if rs.options.Temporality == TemporalityCumulative && !rs.options.CounterRates {
+		// Summing the deltas keeps the total monotonic across resets
+		if rs.counterTotals == nil {
+			rs.counterTotals = make(map[string]float64)
+		}
+		total := rs.counterTotals[name] + delta
+		rs.counterTotals[name] = total
+		rs.Values = append(rs.Values, NamedValue{Name: name, Value: total, Kind: KindCounter})
+		return
+	}
+	if rs.options.CounterRates {
+		if first {
+			// The delta doesn't belong to the interval so it has no rate
+			return
+		}
+		rate := 0.0
+		if secs := rs.Interval().Seconds(); secs > 0 {
+			rate = delta / secs
+		}
+		rs.Values = append(rs.Values, NamedValue{Name: name, Value: rate, Kind: KindRate})
+		return
+	}
+	rs.Values = append(rs.Values, NamedValue{Name: name, Value: delta, Kind: KindCounter})
+}
+
+func (rs *RegistrySnapshot) addHistogram(name string, h Histogram, spec *HistogramExport) {
+	cumulative := rs.options.Temporality == TemporalityCumulative
+	_, noClear := h.(NoClearHistogram)
+	if w, ok := h.(WindowedHistogram); ok && !cumulative {
+		if rs.windows == nil {
+			rs.windows = make(map[WindowedHistogram]uint64)
+		}
+		rs.windows[w] = rs.pass
+		h = w.Window(rs)
+	} else if !ok && !noClear && !cumulative && !rs.options.KeepHistograms {
+		defer h.Clear()
+	}
+	var v DistributionValue
+	var perc []int64
+	if cumulative && !noClear {
+		v, perc = rs.cumulativeDistribution(name, h, spec.Percentiles)
+	} else {
+		v, perc = DistributionAndPercentiles(h, spec.Percentiles)
+	}
+	if v.Count == 0 && !spec.ReportEmpty {
+		return
+	}
+	names := spec.percentileNames()
+	rs.Distributions = append(rs.Distributions, NamedDistribution{Name: name, Value: v, Stats: spec.Stats})
+	for i, p := range perc {
+		rs.Values = append(rs.Values, NamedValue{
+			Name:  name + "/" + names[i],
+			Value: float64(p),
+		})
+	}
+}
+
+// addFloat64Histogram adds a bucketed histogram which, like a counter, is
+// converted to the change since the previous snapshot unless the
+// temporality is cumulative. Its percentiles and stats are those of spec.
+func (rs *RegistrySnapshot) addFloat64Histogram(name string, h *Float64Histogram, spec *HistogramExport) {
+	if rs.options.Temporality != TemporalityCumulative {
+		if rs.float64Values == nil {
+			rs.float64Values = make(map[string]*Float64Histogram)
+		}
+		prev := rs.float64Values[name]
+		rs.float64Values[name] = h.Clone()
+		h = h.Delta(prev)
+	}
+	v := h.Value()
+	if v.Count == 0 && !spec.ReportEmpty {
+		return
+	}
+	names := spec.percentileNames()
+	rs.Distributions = append(rs.Distributions, NamedDistribution{Name: name, Value: v, Stats: spec.Stats})
+	for i, q := range h.Quantiles(spec.Percentiles) {
+		rs.Values = append(rs.Values, NamedValue{Name: name + "/" + names[i], Value: q})
+	}
+}
+
+// cumulativeDistribution reads a histogram's distribution and percentiles
+// together and keeps its count and sum monotonic when it's been cleared
+// since the previous snapshot. The remaining values only describe what's
+// been recorded since it was last cleared. Clears are detected by the
+// histogram's clear count if it has one, otherwise by its count going
+// backwards.
+func (rs *RegistrySnapshot) cumulativeDistribution(name string, h Histogram, percentiles []float64) (DistributionValue, []int64) {
+	if rs.histogramCounts == nil {
+		rs.histogramCounts = make(map[string]cumulativeHistogram)
+	}
+	c := rs.histogramCounts[name]
+	var v DistributionValue
+	var perc []int64
+	var reset bool
+	if ch, ok := h.(clearedHistogram); ok {
+		// Read the clear count on both sides of the distribution so that
+		// they agree unless the histogram is being cleared constantly
+		clears := ch.clears()
+		for i := 0; i < 3; i++ {
+			v, perc = DistributionAndPercentiles(h, percentiles)
+			n := ch.clears()
+			if n == clears {
+				break
+			}
+			clears = n
+		}
+		reset = clears != c.clears
+		c.clears = clears
+	} else {
+		v, perc = DistributionAndPercentiles(h, percentiles)
+		reset = v.Count < c.count
+	}
+	if reset {
+		c.countOffset += c.count
+		c.sumOffset += c.sum
+	}
+	c.count = v.Count
+	c.sum = v.Sum
+	rs.histogramCounts[name] = c
+	v.Count += c.countOffset
+	v.Sum += c.sumOffset
+	return v, perc
 }
 
 func (rs *RegistrySnapshot) Scope(scope string) Registry {
@@ -106,13 +497,30 @@ func (rs *RegistrySnapshot) Remove(name string) {
 }
 
 func (rs *RegistrySnapshot) Do(f Doer) error {
+	return rs.Visit(func(name string, metric any, _ Metadata) error {
+		return f(name, metric)
+	})
+}
+
+func (rs *RegistrySnapshot) Visit(f Visitor) error {
+	return rs.VisitContext(context.Background(), f)
+}
+
+func (rs *RegistrySnapshot) VisitContext(ctx context.Context, f Visitor) error {
 	for _, v := range rs.Values {
-		if err := f(v.Name, GaugeValue(v.Value)); err != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		md := Metadata{Description: v.Description, Unit: v.Unit, Kind: v.Kind, HasKind: true, Tags: v.Tags}
+		if err := f(v.Name, GaugeValue(v.Value), md); err != nil {
 			return err
 		}
 	}
 	for _, v := range rs.Distributions {
-		if err := f(v.Name, v); err != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := f(v.Name, v, Metadata{Description: v.Description, Unit: v.Unit, Tags: v.Tags}); err != nil {
 			return err
 		}
 	}
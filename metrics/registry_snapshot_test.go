@@ -1,8 +1,10 @@
 package metrics
 
 import (
+	"reflect"
 	"sort"
 	"testing"
+	"time"
 )
 
 type namedValueSlice []NamedValue
@@ -44,10 +46,10 @@ func TestRegistrySnapshot(t *testing.T) {
 	if len(snap.Values) != 8 {
 		t.Fatalf("Expected 8 values. Got %d", len(snap.Values))
 	}
-	if e := (NamedValue{Name: "counter", Value: 2}); snap.Values[0] != e {
+	if e := (NamedValue{Name: "counter", Value: 2, Kind: KindCounter}); !reflect.DeepEqual(snap.Values[0], e) {
 		t.Errorf("Expected %+v. Got %+v", e, snap.Values[0])
 	}
-	if e := (NamedValue{Name: "gauge", Value: 3}); snap.Values[1] != e {
+	if e := (NamedValue{Name: "gauge", Value: 3}); !reflect.DeepEqual(snap.Values[1], e) {
 		t.Errorf("Expected %+v. Got %+v", e, snap.Values[1])
 	}
 
@@ -59,10 +61,382 @@ func TestRegistrySnapshot(t *testing.T) {
 	sort.Sort(namedValueSlice(snap.Values))
 	t.Logf("%+v", snap)
 
-	if e := (NamedValue{Name: "counter", Value: 1}); snap.Values[0] != e {
+	if e := (NamedValue{Name: "counter", Value: 1, Kind: KindCounter}); !reflect.DeepEqual(snap.Values[0], e) {
 		t.Errorf("Expected %+v. Got %+v", e, snap.Values[0])
 	}
-	if e := (NamedValue{Name: "gauge", Value: 4}); snap.Values[1] != e {
+	if e := (NamedValue{Name: "gauge", Value: 4}); !reflect.DeepEqual(snap.Values[1], e) {
 		t.Errorf("Expected %+v. Got %+v", e, snap.Values[1])
 	}
 }
+
+func TestRegistrySnapshotHistograms(t *testing.T) {
+	reg := NewRegistry()
+	hist := NewUnbiasedHistogram()
+	hist.Update(1)
+	reg.Add("hist", hist)
+	whist := NewWindowedHistogram(NewUnbiasedHistogram)
+	whist.Update(1)
+	reg.Add("whist", whist)
+
+	counts := func(snap *RegistrySnapshot) map[string]uint64 {
+		out := make(map[string]uint64)
+		for _, d := range snap.Distributions {
+			out[d.Name] = d.Value.Count
+		}
+		return out
+	}
+
+	// Snapshots that keep histograms don't clear them
+	snap1 := NewRegistrySnapshotWithOptions(SnapshotOptions{KeepHistograms: true})
+	snap2 := NewRegistrySnapshotWithOptions(SnapshotOptions{KeepHistograms: true})
+	snap1.Snapshot(reg)
+	hist.Update(2)
+	whist.Update(2)
+	snap2.Snapshot(reg)
+	if c := counts(snap2); c["hist"] != 2 || c["whist"] != 2 {
+		t.Fatalf("Expected second snapshot to see all values. Got %+v", c)
+	}
+	whist.Update(3)
+	snap1.Snapshot(reg)
+	if c := counts(snap1); c["hist"] != 2 || c["whist"] != 2 {
+		t.Fatalf("Expected first snapshot to see lifetime hist and its own whist window. Got %+v", c)
+	}
+
+	// Other snapshots clear plain histograms but not windowed ones
+	clearing := NewRegistrySnapshot(false)
+	clearing.Snapshot(reg)
+	if c := counts(clearing); c["hist"] != 2 || c["whist"] != 3 {
+		t.Fatalf("Expected clearing snapshot counts hist=2 whist=3. Got %+v", c)
+	}
+	snap2.Snapshot(reg)
+	if c := counts(snap2); c["hist"] != 0 || c["whist"] != 1 {
+		t.Fatalf("Expected hist to be cleared and whist window of 1. Got %+v", c)
+	}
+}
+
+func TestRegistrySnapshotRelease(t *testing.T) {
+	reg := NewRegistry()
+	whist := NewWindowedHistogram(NewUnbiasedHistogram)
+	reg.Add("whist", whist)
+	windows := func() int {
+		w := whist.(*windowedHistogram)
+		w.mu.RLock()
+		defer w.mu.RUnlock()
+		return len(w.windows)
+	}
+
+	snap1 := NewRegistrySnapshot(false)
+	snap2 := NewRegistrySnapshot(false)
+	snap1.Snapshot(reg)
+	snap2.Snapshot(reg)
+	if n := windows(); n != 2 {
+		t.Fatalf("Expected 2 windows. Got %d", n)
+	}
+	snap1.Release()
+	if n := windows(); n != 1 {
+		t.Fatalf("Expected 1 window after release. Got %d", n)
+	}
+	snap2.Release()
+	snap2.Release()
+	if n := windows(); n != 0 {
+		t.Fatalf("Expected no windows after release. Got %d", n)
+	}
+
+	// A released snapshot starts over with a window of every value
+	whist.Update(1)
+	snap1.Snapshot(reg)
+	if c := snap1.Distributions[0].Value.Count; c != 1 {
+		t.Fatalf("Expected count 1. Got %d", c)
+	}
+}
+
+func TestRegistrySnapshotForgetsRemovedMetrics(t *testing.T) {
+	reg := NewRegistry()
+	whist := NewWindowedHistogram(NewUnbiasedHistogram)
+	reg.Add("whist", whist)
+	reg.Add("counter", NewCounter())
+	reg.Add("float", Float64CounterValue(1))
+	reg.Add("buckets", &Float64Histogram{Counts: []uint64{1}, Buckets: []float64{0, 1}})
+	hist := NewUnbiasedHistogram()
+	hist.Update(1)
+	reg.Add("hist", hist)
+
+	snap := NewRegistrySnapshotWithOptions(SnapshotOptions{Temporality: TemporalityCumulative})
+	snap.Snapshot(reg)
+	delta := NewRegistrySnapshot(false)
+	delta.Snapshot(reg)
+	if n := len(delta.counterValues) + len(delta.float64Counters) + len(delta.float64Values) + len(delta.windows); n != 4 {
+		t.Fatalf("Expected state for 4 metrics. Got %d", n)
+	}
+	if n := len(snap.counterTotals) + len(snap.histogramCounts); n != 4 {
+		t.Fatalf("Expected cumulative state for 4 metrics. Got %d", n)
+	}
+
+	for _, name := range []string{"whist", "counter", "float", "buckets", "hist"} {
+		reg.Remove(name)
+	}
+	snap.Snapshot(reg)
+	delta.Snapshot(reg)
+	if n := len(delta.counterValues) + len(delta.float64Counters) + len(delta.float64Values) + len(delta.windows); n != 0 {
+		t.Fatalf("Expected no state for removed metrics. Got %d", n)
+	}
+	if n := len(snap.counterTotals) + len(snap.histogramCounts); n != 0 {
+		t.Fatalf("Expected no cumulative state for removed metrics. Got %d", n)
+	}
+	w := whist.(*windowedHistogram)
+	if n := len(w.windows); n != 0 {
+		t.Fatalf("Expected the removed histogram's window to be released. Got %d", n)
+	}
+}
+
+func TestRegistrySnapshotFloat64HistogramSpec(t *testing.T) {
+	snap := NewRegistrySnapshot(false)
+	h := &Float64Histogram{Counts: []uint64{2, 2}, Buckets: []float64{0, 1, 2}}
+	snap.addFloat64Histogram("h", h, &HistogramExport{Percentiles: []float64{0.5}, Stats: StatCount})
+	expected := []NamedValue{{Name: "h/p50", Value: 1}}
+	if !reflect.DeepEqual(snap.Values, expected) {
+		t.Fatalf("Expected %+v. Got %+v", expected, snap.Values)
+	}
+	if len(snap.Distributions) != 1 || snap.Distributions[0].Stats != StatCount {
+		t.Fatalf("Expected the spec's stats. Got %+v", snap.Distributions)
+	}
+
+	snap = NewRegistrySnapshot(false)
+	snap.addFloat64Histogram("h", &Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}, &HistogramExport{ReportEmpty: true})
+	if len(snap.Distributions) != 1 {
+		t.Fatalf("Expected an empty histogram to be reported. Got %+v", snap.Distributions)
+	}
+}
+
+func TestRegistrySnapshotHistogramSpec(t *testing.T) {
+	reg := NewRegistry()
+	hist := NewUnbiasedHistogram()
+	hist.Update(10)
+	reg.Add("hist", &HistogramExport{
+		Histogram:   hist,
+		Percentiles: []float64{0.5, 0.999},
+		Stats:       StatCount | StatMax,
+	})
+	reg.Add("empty", &HistogramExport{
+		Histogram:   NewUnbiasedHistogram(),
+		Percentiles: []float64{0.9},
+		Stats:       StatCount,
+		ReportEmpty: true,
+	})
+	reg.Add("skipped", &HistogramExport{Histogram: NewUnbiasedHistogram()})
+
+	snap := NewRegistrySnapshot(false)
+	snap.Snapshot(reg)
+	sort.Sort(namedValueSlice(snap.Values))
+	exp := []NamedValue{
+		{Name: "empty/p90", Value: 0},
+		{Name: "hist/p50", Value: 10},
+		{Name: "hist/p999", Value: 10},
+	}
+	if !reflect.DeepEqual(snap.Values, exp) {
+		t.Fatalf("Expected values %+v. Got %+v", exp, snap.Values)
+	}
+	if len(snap.Distributions) != 2 {
+		t.Fatalf("Expected 2 distributions. Got %+v", snap.Distributions)
+	}
+	for _, d := range snap.Distributions {
+		switch d.Name {
+		case "hist":
+			if d.Stats != StatCount|StatMax || d.Value.Count != 1 {
+				t.Errorf("Unexpected distribution %+v", d)
+			}
+		case "empty":
+			if d.Stats != StatCount || d.Value.Count != 0 {
+				t.Errorf("Unexpected distribution %+v", d)
+			}
+		default:
+			t.Errorf("Unexpected distribution %+v", d)
+		}
+	}
+}
+
+func TestRegistrySnapshotFloat64Counter(t *testing.T) {
+	reg := NewRegistry()
+	reg.Add("cpu", Float64CounterValue(0.25))
+	snap := NewRegistrySnapshot(false)
+
+	snap.Snapshot(reg)
+	if e := (NamedValue{Name: "cpu", Value: 0.25, Kind: KindCounter}); !reflect.DeepEqual(snap.Values, []NamedValue{e}) {
+		t.Fatalf("Expected %+v. Got %+v", e, snap.Values)
+	}
+	reg.Add("cpu", Float64CounterValue(0.75))
+	snap.Snapshot(reg)
+	if e := (NamedValue{Name: "cpu", Value: 0.5, Kind: KindCounter}); !reflect.DeepEqual(snap.Values, []NamedValue{e}) {
+		t.Fatalf("Expected %+v. Got %+v", e, snap.Values)
+	}
+}
+
+func TestRegistrySnapshotCounterRates(t *testing.T) {
+	reg := NewRegistry()
+	counter := NewCounter()
+	reg.Add("counter", counter)
+
+	snap := NewRegistrySnapshotWithOptions(SnapshotOptions{CounterRates: true})
+	created := snap.Time
+	time.Sleep(time.Millisecond * 10)
+	counter.Inc(10)
+	snap.Snapshot(reg)
+	if !snap.Start.Equal(created) {
+		t.Fatalf("Expected first snapshot to start at creation %s. Got %s", created, snap.Start)
+	}
+	if snap.Interval() < time.Millisecond*10 {
+		t.Fatalf("Expected interval of at least 10ms. Got %s", snap.Interval())
+	}
+	// The lifetime count read the first time has no rate
+	if len(snap.Values) != 0 {
+		t.Fatalf("Expected no rate on the first snapshot. Got %+v", snap.Values)
+	}
+
+	prev := snap.Time
+	time.Sleep(time.Millisecond * 10)
+	counter.Inc(5)
+	snap.Snapshot(reg)
+	if !snap.Start.Equal(prev) {
+		t.Fatalf("Expected snapshot to start at the previous snapshot time %s. Got %s", prev, snap.Start)
+	}
+	exp := NamedValue{Name: "counter", Value: 5 / snap.Interval().Seconds(), Kind: KindRate}
+	if !reflect.DeepEqual(snap.Values[0], exp) {
+		t.Fatalf("Expected %+v. Got %+v", exp, snap.Values[0])
+	}
+
+	// Neither does a counter added after the first snapshot
+	reg.Add("late", NewCounter())
+	snap.Snapshot(reg)
+	if len(snap.Values) != 1 || snap.Values[0].Name != "counter" {
+		t.Fatalf("Expected only the counter's rate. Got %+v", snap.Values)
+	}
+}
+
+func TestRegistrySnapshotCumulative(t *testing.T) {
+	reg := NewRegistry()
+	latchedCounter := NewCounter()
+	reg.Add("latched", latchedCounter)
+	counter := NewCounter()
+	reg.Add("counter", counter)
+	hist := NewUnbiasedHistogram()
+	reg.Add("hist", &HistogramExport{Histogram: hist, Stats: StatCount | StatSum})
+
+	snap := NewRegistrySnapshotWithOptions(SnapshotOptions{ResetOnSnapshot: true, Temporality: TemporalityCumulative})
+	if snap.Temporality() != TemporalityCumulative {
+		t.Fatalf("Expected cumulative temporality. Got %s", snap.Temporality())
+	}
+	values := func() map[string]float64 {
+		snap.Snapshot(reg)
+		m := make(map[string]float64)
+		for _, v := range snap.Values {
+			m[v.Name] = v.Value
+		}
+		for _, d := range snap.Distributions {
+			m[d.Name+"/count"] = float64(d.Value.Count)
+			m[d.Name+"/sum"] = d.Value.Sum
+		}
+		return m
+	}
+
+	latchedCounter.Inc(2)
+	counter.Inc(2)
+	hist.Update(10)
+	values()
+	latchedCounter.Inc(3)
+	counter.Inc(3)
+	hist.Update(20)
+	v := values()
+	if latchedCounter.Count() != 0 {
+		t.Fatalf("Expected latched counter to be reset. Got %d", latchedCounter.Count())
+	}
+	if v["latched"] != 5 || v["counter"] != 5 {
+		t.Fatalf("Expected totals of 5. Got %v", v)
+	}
+	if v["hist/count"] != 2 || v["hist/sum"] != 30 {
+		t.Fatalf("Expected histogram not to be cleared. Got %v", v)
+	}
+
+	// Totals must stay monotonic when a metric is reset behind the snapshot's back
+	counter.Reset()
+	counter.Inc(1)
+	hist.Clear()
+	hist.Update(5)
+	v = values()
+	if v["counter"] != 6 {
+		t.Fatalf("Expected counter total of 6 after reset. Got %v", v["counter"])
+	}
+	if v["hist/count"] != 3 || v["hist/sum"] != 35 {
+		t.Fatalf("Expected histogram count 3 and sum 35 after clear. Got %v", v)
+	}
+	// A clear is noticed even when the histogram has since recorded more
+	// values than it had before
+	hist.Clear()
+	hist.Update(1)
+	hist.Update(1)
+	hist.Update(1)
+	hist.Update(1)
+	v = values()
+	if v["hist/count"] != 7 || v["hist/sum"] != 39 {
+		t.Fatalf("Expected histogram count 7 and sum 39 after clear. Got %v", v)
+	}
+}
+
+// pairedHistogram fails the test if its distribution or percentiles are
+// read on their own rather than together.
+type pairedHistogram struct {
+	Histogram
+	t *testing.T
+}
+
+func (h pairedHistogram) Distribution() DistributionValue {
+	h.t.Fatal("Expected the distribution to be read with the percentiles")
+	return DistributionValue{}
+}
+
+func (h pairedHistogram) Percentiles(percentiles []float64) []int64 {
+	h.t.Fatal("Expected the percentiles to be read with the distribution")
+	return nil
+}
+
+func (h pairedHistogram) DistributionAndPercentiles(percentiles []float64) (DistributionValue, []int64) {
+	return DistributionAndPercentiles(h.Histogram, percentiles)
+}
+
+func TestRegistrySnapshotCumulativeReadsOnce(t *testing.T) {
+	reg := NewRegistry()
+	hist := NewUnbiasedHistogram()
+	reg.Add("hist", pairedHistogram{hist, t})
+	snap := NewRegistrySnapshotWithOptions(SnapshotOptions{Temporality: TemporalityCumulative})
+
+	hist.Update(10)
+	snap.Snapshot(reg)
+	hist.Update(20)
+	snap.Snapshot(reg)
+	if v := snap.Distributions[0].Value; v.Count != 2 || v.Sum != 30 {
+		t.Fatalf("Expected count 2 and sum 30. Got %+v", v)
+	}
+	if len(snap.Values) != len(DefaultPercentiles) {
+		t.Fatalf("Expected %d percentiles. Got %+v", len(DefaultPercentiles), snap.Values)
+	}
+}
+
+func TestRegistrySnapshotCumulativeWindowed(t *testing.T) {
+	reg := NewRegistry()
+	hist := NewWindowedHistogram(NewUnbiasedHistogram)
+	reg.Add("hist", hist)
+	delta := NewRegistrySnapshot(false)
+	cumulative := NewRegistrySnapshotWithOptions(SnapshotOptions{Temporality: TemporalityCumulative})
+
+	hist.Update(1)
+	delta.Snapshot(reg)
+	cumulative.Snapshot(reg)
+	hist.Update(2)
+	delta.Snapshot(reg)
+	cumulative.Snapshot(reg)
+	if n := delta.Distributions[0].Value.Count; n != 1 {
+		t.Fatalf("Expected delta count of 1. Got %d", n)
+	}
+	if n := cumulative.Distributions[0].Value.Count; n != 2 {
+		t.Fatalf("Expected cumulative count of 2. Got %d", n)
+	}
+}
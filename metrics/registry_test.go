@@ -5,13 +5,21 @@
 package metrics
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestRegistry(t *testing.T) {
@@ -97,3 +105,361 @@ func TestRegistryHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRegistryHandlerScope(t *testing.T) {
+	r := NewRegistry()
+	r.Add("uptime", GaugeValue(1))
+	db := r.Scope("db")
+	db.Add("queries", GaugeValue(2))
+	db.Add("pool/open", GaugeValue(3))
+	h := RegistryHandler(db)
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+	var out map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	jsonNames := slices.Sorted(maps.Keys(out))
+
+	res = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	h.ServeHTTP(res, req)
+	var omNames []string
+	for _, line := range strings.Split(res.Body.String(), "\n") {
+		if name, _, ok := strings.Cut(line, " "); ok && !strings.HasPrefix(line, "#") {
+			omNames = append(omNames, name)
+		}
+	}
+
+	if exp := []string{"db/pool/open", "db/queries"}; !reflect.DeepEqual(jsonNames, exp) {
+		t.Fatalf("Expected JSON for %v. Got %v", exp, jsonNames)
+	}
+	if exp := []string{"db_pool_open", "db_queries"}; !reflect.DeepEqual(omNames, exp) {
+		t.Fatalf("Expected OpenMetrics for %v. Got %v", exp, omNames)
+	}
+}
+
+func TestRegistryVisitMetadata(t *testing.T) {
+	r := NewRegistry()
+	AddWithOptions(r.Scope("http"), "latency", NewUnbiasedHistogram(), WithDescription("Request latency"), WithUnit(UnitMilliseconds))
+	AddWithOptions(r, "total", GaugeFunc(func() float64 { return 3 }), WithKind(KindCounter))
+	r.Add("plain", NewCounter())
+
+	out := make(map[string]Metadata)
+	if err := Visit(r, func(name string, metric any, md Metadata) error {
+		out[name] = md
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]Metadata{
+		"http/latency": {Description: "Request latency", Unit: UnitMilliseconds},
+		"total":        {Kind: KindCounter, HasKind: true},
+		"plain":        {},
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Fatalf("registry.Visit should have returned %+v instead of %+v", exp, out)
+	}
+
+	snap := NewRegistrySnapshot(false)
+	snap.Snapshot(r)
+	for _, v := range snap.Values {
+		switch {
+		case v.Name == "total":
+			if v.Kind != KindCounter {
+				t.Errorf("Expected explicit kind for %s. Got %+v", v.Name, v)
+			}
+		case v.Name == "plain":
+		case v.Unit != UnitMilliseconds || v.Description != "Request latency" || v.Kind != KindGauge:
+			t.Errorf("Expected percentile %s to have the histogram's metadata. Got %+v", v.Name, v)
+		}
+	}
+}
+
+func TestRegistryHandlerOpenMetrics(t *testing.T) {
+	r := NewRegistry()
+	counter := NewCounter()
+	counter.Inc(3)
+	AddWithOptions(r, "requests", counter, WithDescription("Requests\nserved"))
+	AddWithOptions(r, "heap", GaugeValue(1024), WithUnit(UnitBytes))
+	AddWithOptions(r, "total", GaugeFunc(func() float64 { return 5 }), WithKind(KindCounter))
+	hist := NewUnbiasedHistogram()
+	hist.Update(2)
+	AddWithOptions(r.Scope("http"), "latency", &HistogramExport{Histogram: hist, Percentiles: []float64{0.5}}, WithUnit(UnitSeconds))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	RegistryHandler(r).ServeHTTP(res, req)
+	if ct := res.Header().Get("Content-Type"); ct != OpenMetricsContentType {
+		t.Fatalf("Expected content type %q. Got %q", OpenMetricsContentType, ct)
+	}
+	exp := `# TYPE heap_bytes gauge
+# UNIT heap_bytes bytes
+heap_bytes 1024
+# TYPE http_latency_seconds summary
+# UNIT http_latency_seconds seconds
+http_latency_seconds{quantile="0.5"} 2
+http_latency_seconds_count 1
+http_latency_seconds_sum 2
+# TYPE requests counter
+# HELP requests Requests\nserved
+requests_total 3
+# TYPE total counter
+total_total 5
+# EOF
+`
+	if s := res.Body.String(); s != exp {
+		t.Fatalf("Expected\n%s\ngot\n%s", exp, s)
+	}
+}
+
+func TestRegistryGetOrAdd(t *testing.T) {
+	r := NewRegistry()
+	c1, err := GetOrAddCounter(r.Scope("db"), "queries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := GetOrAddCounter(r, "db/queries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 {
+		t.Fatal("Expected the existing counter to be returned")
+	}
+	if _, err := GetOrAddMeter(r, "db/queries"); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("Expected ErrTypeMismatch. Got %v", err)
+	}
+
+	hist := NewUnbiasedHistogram()
+	r.Add("latency", &HistogramExport{Histogram: hist})
+	h, err := GetOrAddHistogram(r, "latency", NewUnbiasedHistogram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != hist {
+		t.Fatal("Expected the exported histogram to be returned")
+	}
+
+	// Concurrent callers must all get the same metric
+	var wg sync.WaitGroup
+	gauges := make([]*IntegerGauge, 8)
+	for i := range gauges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gauges[i], _ = GetOrAddIntegerGauge(r, "gauge")
+		}()
+	}
+	wg.Wait()
+	for _, g := range gauges {
+		if g == nil || g != gauges[0] {
+			t.Fatalf("Expected every caller to get the same gauge. Got %v", gauges)
+		}
+	}
+}
+
+func TestRegistryStrict(t *testing.T) {
+	r := NewRegistry(Strict())
+	r.Add("num", 1)
+	r.Remove("num")
+	r.Add("num", 2)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected duplicate Add to panic")
+		}
+	}()
+	r.Scope("x").Add("y", 1)
+	r.Add("x/y", 2)
+}
+
+type testCollection map[string]any
+
+func (c testCollection) Metrics() map[string]any {
+	return c
+}
+
+func TestRegistryVisitOrder(t *testing.T) {
+	r := NewRegistry()
+	r.Add("c", 1)
+	r.Add("a", testCollection{"z": 1, "y": 2})
+	r.Add("b", 3)
+	var names []string
+	r.Do(func(name string, metric any) error {
+		names = append(names, name)
+		// Registering from the callback must not deadlock
+		r.Add("added/"+name, 0)
+		return nil
+	})
+	exp := []string{"a/y", "a/z", "b", "c"}
+	if !reflect.DeepEqual(names, exp) {
+		t.Fatalf("Expected %v. Got %v", exp, names)
+	}
+}
+
+func TestRegistryVisitContext(t *testing.T) {
+	r := NewRegistry()
+	r.Add("a", 1)
+	r.Add("b", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	n := 0
+	err := VisitContext(ctx, r, func(name string, metric any, md Metadata) error {
+		n++
+		cancel()
+		return nil
+	})
+	if err != context.Canceled || n != 1 {
+		t.Fatalf("Expected walk to stop with context.Canceled after 1 metric. Got %v after %d", err, n)
+	}
+}
+
+func TestWriteOpenMetricsLabels(t *testing.T) {
+	r := NewRegistry()
+	s := ScopeWithOptions(r, "db", WithScopeTags(map[string]string{"shard": `a"1`}))
+	hist := NewUnbiasedHistogram()
+	hist.Update(3)
+	s.Add("latency", &HistogramExport{Histogram: hist, Percentiles: []float64{0.5}})
+	var b bytes.Buffer
+	if err := WriteOpenMetrics(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	exp := `# TYPE db_latency summary
+db_latency{shard="a\"1",quantile="0.5"} 3
+db_latency_count{shard="a\"1"} 1
+db_latency_sum{shard="a\"1"} 3
+# EOF
+`
+	if b.String() != exp {
+		t.Fatalf("Expected\n%s\ngot\n%s", exp, b.String())
+	}
+}
+
+func TestWriteOpenMetricsCollisions(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter()
+	c.Inc(1)
+	r.Add("a/b", c)
+	r.Add("a_b", GaugeValue(2))
+	AddWithOptions(r, "c/d", GaugeValue(3), WithTags(map[string]string{"route": "x"}))
+	AddWithOptions(r, "c_d", GaugeValue(4), WithTags(map[string]string{"route": "y"}))
+	AddWithOptions(r, "c-d", GaugeValue(5), WithTags(map[string]string{"route": "y"}))
+	var b bytes.Buffer
+	if err := WriteOpenMetrics(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	// Metrics sanitized to the same family share it when they can and are
+	// otherwise skipped. The first metric in name order wins.
+	exp := `# TYPE a_b counter
+a_b_total 1
+# TYPE c_d gauge
+c_d{route="y"} 5
+c_d{route="x"} 3
+# EOF
+`
+	if b.String() != exp {
+		t.Fatalf("Expected\n%s\ngot\n%s", exp, b.String())
+	}
+}
+
+func TestOpenMetricsFamiliesRollingHistogram(t *testing.T) {
+	h := NewDefaultRollingHistogram(time.Minute, 10*time.Second)
+	advance := fakeClock(&h.clock)
+	h.Update(2)
+	h.Update(4)
+	md := Metadata{Unit: UnitSeconds}
+	families := func(metric any) map[string]OpenMetricsFamily {
+		m := make(map[string]OpenMetricsFamily)
+		for _, f := range OpenMetricsFamilies("latency", metric, md) {
+			if f.Type != "gauge" || f.Metadata.Unit != "" {
+				t.Fatalf("Expected a gauge without a unit. Got %+v", f)
+			}
+			m[f.Name] = f
+		}
+		return m
+	}
+
+	f := families(&HistogramExport{Histogram: h, Percentiles: []float64{0.5, 0.999}})
+	names := slices.Sorted(maps.Keys(f))
+	if exp := []string{"latency_seconds_count", "latency_seconds_p50", "latency_seconds_p999", "latency_seconds_sum"}; !reflect.DeepEqual(names, exp) {
+		t.Fatalf("Expected %v. Got %v", exp, names)
+	}
+	if c, s := f["latency_seconds_count"].Value, f["latency_seconds_sum"].Value; c != 2 || s != 6 {
+		t.Fatalf("Expected count 2 and sum 6. Got %f and %f", c, s)
+	}
+
+	// The count falls as the values leave the window which a gauge may do
+	advance(2 * time.Minute)
+	f = families(h)
+	if len(f) != 2+len(DefaultPercentiles) {
+		t.Fatalf("Expected gauges of the default percentiles. Got %+v", f)
+	}
+	if c := f["latency_seconds_count"].Value; c != 0 {
+		t.Fatalf("Expected count 0 after the window. Got %f", c)
+	}
+}
+
+// mapRegistry only implements Registry like registries written against
+// the original interface.
+type mapRegistry map[string]any
+
+func (r mapRegistry) Scope(scope string) Registry { panic("not implemented") }
+func (r mapRegistry) Add(name string, metric any) { r[name] = metric }
+func (r mapRegistry) Remove(name string)          { delete(r, name) }
+
+func (r mapRegistry) Do(f Doer) error {
+	for _, name := range slices.Sorted(maps.Keys(r)) {
+		if err := f(name, r[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	_ VisitRegistry    = NewRegistry().(*registry)
+	_ OptionsRegistry  = NewRegistry().(*registry)
+	_ GetOrAddRegistry = NewRegistry().(*registry)
+	_ ScopesRegistry   = NewRegistry().(*registry)
+	_ VisitRegistry    = Chain(NewRegistry()).(*chainRegistry)
+	_ OptionsRegistry  = Chain(NewRegistry()).(*chainRegistry)
+	_ GetOrAddRegistry = Chain(NewRegistry()).(*chainRegistry)
+	_ ScopesRegistry   = Chain(NewRegistry()).(*chainRegistry)
+	_ VisitRegistry    = &RegistrySnapshot{}
+)
+
+func TestRegistryFallbacks(t *testing.T) {
+	r := mapRegistry{}
+	AddWithOptions(r, "gauge", GaugeValue(1), WithDescription("dropped"))
+	c, err := GetOrAddCounter(r, "counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2, err := GetOrAddCounter(r, "counter"); err != nil || c2 != c {
+		t.Fatalf("Expected the existing counter. Got %p %v", c2, err)
+	}
+	if _, err := GetOrAddCounter(r, "gauge"); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("Expected ErrTypeMismatch. Got %v", err)
+	}
+
+	var names []string
+	if err := Visit(Chain(r, ExcludeGlob("gauge")), func(name string, metric any, md Metadata) error {
+		if md.Description != "" {
+			t.Errorf("Expected no metadata for %s. Got %+v", name, md)
+		}
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"counter"}) {
+		t.Fatalf("Expected [counter]. Got %v", names)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := VisitContext(ctx, r, func(string, any, Metadata) error { return nil }); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled. Got %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rollingClock divides time since a start into periods of a fixed length
+// which map onto the slots of a ring covering a window.
+type rollingClock struct {
+	granularity time.Duration
+	slots       int64
+	start       time.Time
+	now         func() time.Time
+}
+
+// newRollingClock returns a clock with enough slots of granularity to
+// cover window. A granularity that's not positive or longer than the
+// window is set to the window.
+func newRollingClock(window, granularity time.Duration) rollingClock {
+	if window <= 0 {
+		panic("metrics: rolling window must be positive")
+	}
+	if granularity <= 0 || granularity > window {
+		granularity = window
+	}
+	return rollingClock{
+		granularity: granularity,
+		slots:       int64((window + granularity - 1) / granularity),
+		start:       time.Now(),
+		now:         time.Now,
+	}
+}
+
+// period returns the number of the current period and how long it's been
+// since the start.
+func (c *rollingClock) period() (int64, time.Duration) {
+	elapsed := c.now().Sub(c.start)
+	return int64(elapsed / c.granularity), elapsed
+}
+
+// RollingHistogram is a Histogram of the values recorded over the last
+// window of time such as the p99 latency over the last minute. It's a ring
+// of bucketed histograms that each cover a period of the window's
+// granularity. The oldest is dropped as time passes, so the window slides
+// in steps of the granularity and covers up to one period less than the
+// window while the current period is in progress.
+//
+// It's a NoClearHistogram so unlike other histograms it's never cleared
+// by a RegistrySnapshot.
+type RollingHistogram struct {
+	clock         rollingClock
+	bucketOffsets []int64
+	slots         []rollingHistogramSlot
+	mu            sync.Mutex
+}
+
+type rollingHistogramSlot struct {
+	period int64
+	values bucketedValues
+}
+
+// NewRollingHistogram returns a histogram of the values recorded over the
+// last window that's advanced every granularity. Values are counted in
+// buckets as by NewBucketedHistogram.
+func NewRollingHistogram(window, granularity time.Duration, bucketOffsets []int64) *RollingHistogram {
+	h := &RollingHistogram{
+		clock:         newRollingClock(window, granularity),
+		bucketOffsets: bucketOffsets,
+	}
+	h.slots = make([]rollingHistogramSlot, h.clock.slots)
+	for i := range h.slots {
+		h.slots[i].values = newBucketedValues(len(bucketOffsets) + 1)
+	}
+	return h
+}
+
+// NewDefaultRollingHistogram returns a rolling histogram with an error of
+// 5%
+func NewDefaultRollingHistogram(window, granularity time.Duration) *RollingHistogram {
+	return NewRollingHistogram(window, granularity, MakeBucketsForError(0.05))
+}
+
+// Window returns the duration covered by the histogram.
+func (h *RollingHistogram) Window() time.Duration {
+	return time.Duration(h.clock.slots) * h.clock.granularity
+}
+
+func (h *RollingHistogram) Clear() {
+	h.mu.Lock()
+	for i := range h.slots {
+		h.slots[i].values.reset()
+	}
+	h.mu.Unlock()
+}
+
+// NoClearOnSnapshot marks the histogram as a NoClearHistogram.
+func (h *RollingHistogram) NoClearOnSnapshot() {}
+
+func (h *RollingHistogram) Update(value int64) {
+	bucket := bucketIndex(h.bucketOffsets, value)
+	h.mu.Lock()
+	period, _ := h.clock.period()
+	s := &h.slots[period%h.clock.slots]
+	if s.period != period {
+		s.period = period
+		s.values.reset()
+	}
+	s.values.update(value, bucket)
+	h.mu.Unlock()
+}
+
+// values returns the merged values of the slots in the window.
+func (h *RollingHistogram) values() bucketedValues {
+	v := newBucketedValues(len(h.bucketOffsets) + 1)
+	h.mu.Lock()
+	period, _ := h.clock.period()
+	for i := range h.slots {
+		if s := &h.slots[i]; s.period > period-h.clock.slots {
+			v.merge(&s.values)
+		}
+	}
+	h.mu.Unlock()
+	return v
+}
+
+func (h *RollingHistogram) Distribution() DistributionValue {
+	v := h.values()
+	return v.distribution()
+}
+
+func (h *RollingHistogram) Percentiles(percentiles []float64) []int64 {
+	v := h.values()
+	return bucketPercentiles(h.bucketOffsets, v.buckets, v.count, v.min, percentiles)
+}
+
+func (h *RollingHistogram) DistributionAndPercentiles(percentiles []float64) (DistributionValue, []int64) {
+	v := h.values()
+	return v.distribution(), bucketPercentiles(h.bucketOffsets, v.buckets, v.count, v.min, percentiles)
+}
+
+func (h *RollingHistogram) String() string {
+	return histogramToJSON(h, DefaultPercentiles, DefaultPercentileNames, 0)
+}
+
+func (h *RollingHistogram) MarshalJSON() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *RollingHistogram) MarshalText() ([]byte, error) {
+	return h.MarshalJSON()
+}
+
+// RollingCounter is a counter that also keeps the counts over the last
+// window of time, such as the last minute, from which it provides rates.
+// Like RollingHistogram the window is a ring of periods of the
+// granularity. Count is the total since creation, so snapshots report it
+// like any other CounterMetric.
+type RollingCounter struct {
+	count uint64
+	clock rollingClock
+	slots []rollingCounterSlot
+	mu    sync.Mutex
+}
+
+type rollingCounterSlot struct {
+	period int64
+	count  uint64
+}
+
+// NewRollingCounter returns a counter that keeps the counts over the last
+// window that's advanced every granularity.
+func NewRollingCounter(window, granularity time.Duration) *RollingCounter {
+	c := &RollingCounter{clock: newRollingClock(window, granularity)}
+	c.slots = make([]rollingCounterSlot, c.clock.slots)
+	return c
+}
+
+// Window returns the duration covered by the counter.
+func (c *RollingCounter) Window() time.Duration {
+	return time.Duration(c.clock.slots) * c.clock.granularity
+}
+
+func (c *RollingCounter) Inc(delta uint64) {
+	atomic.AddUint64(&c.count, delta)
+	c.mu.Lock()
+	period, _ := c.clock.period()
+	s := &c.slots[period%c.clock.slots]
+	if s.period != period {
+		*s = rollingCounterSlot{period: period}
+	}
+	s.count += delta
+	c.mu.Unlock()
+}
+
+// Count returns the total since the counter was created.
+func (c *RollingCounter) Count() uint64 {
+	return atomic.LoadUint64(&c.count)
+}
+
+// WindowCount returns the count over the window.
+func (c *RollingCounter) WindowCount() uint64 {
+	count, _ := c.windowCount(c.Window())
+	return count
+}
+
+// Rate returns the rate per second over the given part of the window,
+// which is rounded up to a multiple of the granularity and limited to the
+// window. The current period is included for as long as it's been in
+// progress, as is the time since the counter was created if that's
+// shorter.
+func (c *RollingCounter) Rate(window time.Duration) float64 {
+	count, covered := c.windowCount(window)
+	if covered <= 0 {
+		return 0
+	}
+	return float64(count) / covered.Seconds()
+}
+
+// windowCount returns the count over the periods that cover window and the
+// time that they've covered so far.
+func (c *RollingCounter) windowCount(window time.Duration) (uint64, time.Duration) {
+	n := min(max(int64((window+c.clock.granularity-1)/c.clock.granularity), 1), c.clock.slots)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	period, elapsed := c.clock.period()
+	var count uint64
+	for _, s := range c.slots {
+		if s.period > period-n {
+			count += s.count
+		}
+	}
+	covered := time.Duration(n-1)*c.clock.granularity + elapsed - time.Duration(period)*c.clock.granularity
+	return count, min(covered, elapsed)
+}
+
+func (c *RollingCounter) String() string {
+	return strconv.FormatUint(c.Count(), 10)
+}
+
+func (c *RollingCounter) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *RollingCounter) MarshalText() ([]byte, error) {
+	return c.MarshalJSON()
+}
@@ -0,0 +1,189 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	_ NoClearHistogram  = &RollingHistogram{}
+	_ SnapshotHistogram = &RollingHistogram{}
+	_ CounterMetric     = &RollingCounter{}
+)
+
+// fakeClock replaces the clock's time with one that only moves when
+// advanced.
+func fakeClock(c *rollingClock) func(time.Duration) {
+	now := c.start
+	c.now = func() time.Time { return now }
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRollingHistogram(t *testing.T) {
+	h := NewDefaultRollingHistogram(time.Minute, 10*time.Second)
+	advance := fakeClock(&h.clock)
+	if w := h.Window(); w != time.Minute {
+		t.Fatalf("Expected a window of 1m. Got %s", w)
+	}
+
+	h.Update(1000)
+	advance(30 * time.Second)
+	for i := range int64(100) {
+		h.Update(i)
+	}
+	v := h.Distribution()
+	if v.Count != 101 || v.Min != 0 || v.Max != 1000 || v.Sum != 5950 {
+		t.Fatalf("Expected 101 values from 0 to 1000 summing to 5950. Got %+v", v)
+	}
+	if p := h.Percentiles([]float64{1}); p[0] < 950 {
+		t.Fatalf("Expected p100 in the bucket of 1000. Got %d", p[0])
+	}
+
+	// The first value drops out a window after its period started
+	advance(30 * time.Second)
+	v = h.Distribution()
+	if v.Count != 100 || v.Max != 99 {
+		t.Fatalf("Expected 100 values up to 99. Got %+v", v)
+	}
+
+	// A slot that's reused only has the values of its new period
+	advance(50 * time.Second)
+	h.Update(7)
+	v = h.Distribution()
+	if v.Count != 1 || v.Sum != 7 {
+		t.Fatalf("Expected a single 7. Got %+v", v)
+	}
+
+	advance(time.Hour)
+	if v := h.Distribution(); v != (DistributionValue{}) {
+		t.Fatalf("Expected an empty distribution. Got %+v", v)
+	}
+	if p := h.Percentiles([]float64{0.5}); p[0] != 0 {
+		t.Fatalf("Expected p50 of 0. Got %d", p[0])
+	}
+
+	h.Update(5)
+	h.Clear()
+	if v := h.Distribution(); v.Count != 0 {
+		t.Fatalf("Expected an empty distribution after clear. Got %+v", v)
+	}
+}
+
+func TestRollingHistogramLatchedSnapshot(t *testing.T) {
+	r := NewRegistry()
+	h := NewDefaultRollingHistogram(time.Minute, time.Second)
+	r.Add("latency", h)
+	h.Update(10)
+	rs := NewRegistrySnapshot(true)
+	rs.Snapshot(r)
+	rs.Snapshot(r)
+	if len(rs.Distributions) != 1 || rs.Distributions[0].Value.Count != 1 {
+		t.Fatalf("Expected the rolling histogram not to be cleared. Got %+v", rs.Distributions)
+	}
+}
+
+// wrappedHistogram wraps a histogram the way users may, for instance to
+// record to several at once.
+type wrappedHistogram struct {
+	Histogram
+}
+
+func (h wrappedHistogram) NoClearOnSnapshot() {}
+
+func TestRollingHistogramWrappedSnapshot(t *testing.T) {
+	r := NewRegistry()
+	h := NewDefaultRollingHistogram(time.Minute, time.Second)
+	r.Add("latency", wrappedHistogram{h})
+	h.Update(10)
+	rs := NewRegistrySnapshot(false)
+	rs.Snapshot(r)
+	rs.Snapshot(r)
+	if len(rs.Distributions) != 1 || rs.Distributions[0].Value.Count != 1 {
+		t.Fatalf("Expected the wrapped rolling histogram not to be cleared. Got %+v", rs.Distributions)
+	}
+}
+
+func TestRollingHistogramCumulativeSnapshot(t *testing.T) {
+	r := NewRegistry()
+	h := NewDefaultRollingHistogram(time.Minute, time.Second)
+	advance := fakeClock(&h.clock)
+	r.Add("latency", h)
+	rs := NewRegistrySnapshotWithOptions(SnapshotOptions{Temporality: TemporalityCumulative})
+
+	h.Update(10)
+	h.Update(20)
+	rs.Snapshot(r)
+	// The first values leave the window which mustn't look like a reset
+	advance(time.Minute)
+	h.Update(30)
+	rs.Snapshot(r)
+	if v := rs.Distributions[0].Value; v.Count != 1 || v.Sum != 30 {
+		t.Fatalf("Expected only the value in the window. Got %+v", v)
+	}
+}
+
+func TestRollingCounter(t *testing.T) {
+	c := NewRollingCounter(time.Minute, 10*time.Second)
+	advance := fakeClock(&c.clock)
+
+	c.Inc(10)
+	advance(5 * time.Second)
+	if r := c.Rate(time.Minute); r != 2 {
+		t.Fatalf("Expected a rate of 2/s over the 5s since creation. Got %f", r)
+	}
+	advance(25 * time.Second)
+	c.Inc(30)
+	advance(5 * time.Second)
+	// 3 full periods and 5s of the current one
+	if r := c.Rate(time.Minute); r != 40.0/35 {
+		t.Fatalf("Expected a rate of 40/35s. Got %f", r)
+	}
+	if r := c.Rate(10 * time.Second); r != 30.0/5 {
+		t.Fatalf("Expected a rate of 30/5s over the current period. Got %f", r)
+	}
+	if r := c.Rate(15 * time.Second); r != 30.0/15 {
+		t.Fatalf("Expected a rate of 30/15s over 2 periods. Got %f", r)
+	}
+
+	advance(30 * time.Second)
+	if n := c.WindowCount(); n != 30 {
+		t.Fatalf("Expected 30 in the window. Got %d", n)
+	}
+	if r := c.Rate(time.Hour); r != 30.0/55 {
+		t.Fatalf("Expected a rate of 30/55s over the window. Got %f", r)
+	}
+
+	advance(time.Minute)
+	if n, r := c.WindowCount(), c.Rate(time.Minute); n != 0 || r != 0 {
+		t.Fatalf("Expected an empty window. Got %d %f", n, r)
+	}
+	if n := c.Count(); n != 40 {
+		t.Fatalf("Expected a lifetime count of 40. Got %d", n)
+	}
+	if s := c.String(); s != "40" {
+		t.Fatalf("Expected 40. Got %s", s)
+	}
+}
+
+func TestRollingGranularity(t *testing.T) {
+	c := NewRollingCounter(time.Minute, 0)
+	if c.clock.slots != 1 || c.Window() != time.Minute {
+		t.Fatalf("Expected a single slot covering 1m. Got %d of %s", c.clock.slots, c.clock.granularity)
+	}
+	c = NewRollingCounter(time.Minute, 7*time.Second)
+	if c.clock.slots != 9 || c.Window() != 63*time.Second {
+		t.Fatalf("Expected 9 slots covering 63s. Got %d covering %s", c.clock.slots, c.Window())
+	}
+}
+
+func BenchmarkRollingHistogramUpdate(b *testing.B) {
+	benchmarkHistogramUpdate(b, NewDefaultRollingHistogram(time.Minute, time.Second))
+}
+
+func BenchmarkRollingHistogramPercentiles(b *testing.B) {
+	benchmarkHistogramPercentiles(b, NewDefaultRollingHistogram(time.Minute, time.Second))
+}
@@ -4,24 +4,158 @@
 
 package metrics
 
-import "runtime"
+import (
+	"runtime"
+	rtmetrics "runtime/metrics"
+	"strings"
+	"sync"
+)
 
+// Subsets of the runtime metrics for use with NewRuntimeCollection. They're
+// glob patterns as used by IncludeGlob.
+var (
+	RuntimeGC        = []string{"gc/**"}
+	RuntimeMemory    = []string{"memory/**"}
+	RuntimeScheduler = []string{"sched/**"}
+	RuntimeSync      = []string{"sync/**"}
+	RuntimeCPU       = []string{"cpu/**"}
+)
+
+// RuntimeCollection is a Collection of the metrics provided by the
+// runtime/metrics package. Reading them doesn't stop the world.
+type RuntimeCollection struct {
+	mu      sync.Mutex
+	samples []rtmetrics.Sample
+	names   []string
+	// cumulative is whether each metric is a running total
+	cumulative []bool
+}
+
+// NewRuntimeCollection returns a collection of the runtime metrics whose
+// names match any of the glob patterns, or all supported metrics if none
+// are given. Names are those of runtime/metrics without the leading slash
+// and with the unit appended after an underscore, so /gc/pauses:seconds
+// becomes gc/pauses_seconds. Cumulative numbers are counters, with those
+// that aren't integers (such as CPU seconds) being Float64CounterValue,
+// other numbers are gauges, and histograms are *Float64Histogram.
+func NewRuntimeCollection(patterns ...string) *RuntimeCollection {
+	var globs [][]string
+	if len(patterns) != 0 {
+		globs = compileGlobs(patterns)
+	}
+	c := &RuntimeCollection{}
+	for _, d := range rtmetrics.All() {
+		name := runtimeMetricName(d.Name)
+		if globs != nil && !matchAnyGlob(globs, name) {
+			continue
+		}
+		c.samples = append(c.samples, rtmetrics.Sample{Name: d.Name})
+		c.names = append(c.names, name)
+		c.cumulative = append(c.cumulative, d.Cumulative)
+	}
+	return c
+}
+
+// runtimeMetricName converts a runtime/metrics name such as
+// /memory/classes/heap/free:bytes to memory/classes/heap/free_bytes.
+func runtimeMetricName(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	path, unit, ok := strings.Cut(name, ":")
+	if !ok {
+		return name
+	}
+	unit = strings.NewReplacer("/", "_per_", "*", "_").Replace(unit)
+	return path + "_" + unit
+}
+
+func (c *RuntimeCollection) Metrics() map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rtmetrics.Read(c.samples)
+	out := make(map[string]any, len(c.samples))
+	for i, s := range c.samples {
+		switch s.Value.Kind() {
+		case rtmetrics.KindUint64:
+			if c.cumulative[i] {
+				out[c.names[i]] = CounterValue(s.Value.Uint64())
+			} else {
+				out[c.names[i]] = GaugeValue(s.Value.Uint64())
+			}
+		case rtmetrics.KindFloat64:
+			if c.cumulative[i] {
+				out[c.names[i]] = Float64CounterValue(s.Value.Float64())
+			} else {
+				out[c.names[i]] = GaugeValue(s.Value.Float64())
+			}
+		case rtmetrics.KindFloat64Histogram:
+			h := s.Value.Float64Histogram()
+			// The runtime reuses the histogram on the next read
+			out[c.names[i]] = (&Float64Histogram{Counts: h.Counts, Buckets: h.Buckets}).Clone()
+		}
+	}
+	return out
+}
+
+// RuntimeMetrics provides the metrics that used to be read from
+// runtime.MemStats using their original names. It's kept for
+// compatibility. Use NewRuntimeCollection for everything the runtime
+// provides. Reading them doesn't stop the world. The runtime only keeps a
+// histogram of GC pauses so gc/PauseTotalNs is estimated from its buckets
+// as Float64Histogram estimates its sum.
 var RuntimeMetrics = &runtimeMetrics{}
 
 type runtimeMetrics struct {
-	memStats runtime.MemStats
+	mu      sync.Mutex
+	samples []rtmetrics.Sample
+}
+
+var runtimeMetricsSamples = []string{
+	"/gc/heap/allocs:objects",
+	"/gc/heap/tiny/allocs:objects",
+	"/gc/heap/frees:objects",
+	"/memory/classes/heap/objects:bytes",
+	"/gc/heap/objects:objects",
+	"/gc/cycles/total:gc-cycles",
+	"/sched/goroutines:goroutines",
+	"/go/cgo/go-to-c-calls:calls",
+	"/sched/pauses/total/gc:seconds",
 }
 
 func (s *runtimeMetrics) Metrics() map[string]any {
-	runtime.ReadMemStats(&s.memStats)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.samples == nil {
+		s.samples = make([]rtmetrics.Sample, len(runtimeMetricsSamples))
+		for i, name := range runtimeMetricsSamples {
+			s.samples[i].Name = name
+		}
+	}
+	rtmetrics.Read(s.samples)
+	u := func(i int) uint64 {
+		if s.samples[i].Value.Kind() != rtmetrics.KindUint64 {
+			return 0
+		}
+		return s.samples[i].Value.Uint64()
+	}
+	// MemStats counted tiny allocations as both mallocs and frees
+	tiny := u(1)
+	var pauseTotal float64
+	if s.samples[8].Value.Kind() == rtmetrics.KindFloat64Histogram {
+		h := s.samples[8].Value.Float64Histogram()
+		pauseTotal = (&Float64Histogram{Counts: h.Counts, Buckets: h.Buckets}).Value().Sum
+	}
+	goroutines := u(6)
+	if goroutines == 0 {
+		goroutines = uint64(runtime.NumGoroutine())
+	}
 	return map[string]any{
-		"Mallocs":          CounterValue(s.memStats.Mallocs),
-		"Frees":            CounterValue(s.memStats.Frees),
-		"heap/HeapAlloc":   GaugeValue(s.memStats.HeapAlloc),
-		"heap/HeapObjects": GaugeValue(s.memStats.HeapObjects),
-		"gc/NumGC":         CounterValue(s.memStats.NumGC),
-		"gc/PauseTotalNs":  CounterValue(s.memStats.PauseTotalNs),
-		"Goroutines":       GaugeValue(runtime.NumGoroutine()),
-		"CgoCalls":         CounterValue(runtime.NumCgoCall()),
+		"Mallocs":          CounterValue(u(0) + tiny),
+		"Frees":            CounterValue(u(2) + tiny),
+		"heap/HeapAlloc":   GaugeValue(u(3)),
+		"heap/HeapObjects": GaugeValue(u(4)),
+		"gc/NumGC":         CounterValue(u(5)),
+		"gc/PauseTotalNs":  CounterValue(pauseTotal * 1e9),
+		"Goroutines":       GaugeValue(goroutines),
+		"CgoCalls":         CounterValue(u(7)),
 	}
 }
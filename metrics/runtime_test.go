@@ -1,9 +1,70 @@
 package metrics
 
-import "testing"
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
 
 func TestRuntimeMetrics(t *testing.T) {
-	if m := RuntimeMetrics.Metrics(); len(m) == 0 {
+	m := RuntimeMetrics.Metrics()
+	if len(m) == 0 {
 		t.Fatal("RuntimeMetrics returned no values")
 	}
+	if g := m["Goroutines"].(GaugeValue); g < 1 {
+		t.Fatalf("Expected at least 1 goroutine. Got %f", g)
+	}
+	if c := m["Mallocs"].(CounterValue); c == 0 {
+		t.Fatal("Expected non-zero Mallocs")
+	}
+	runtime.GC()
+	m = RuntimeMetrics.Metrics()
+	if c := m["gc/PauseTotalNs"].(CounterValue); c == 0 {
+		t.Fatal("Expected non-zero gc/PauseTotalNs after a GC")
+	}
+}
+
+func TestRuntimeCollection(t *testing.T) {
+	m := NewRuntimeCollection().Metrics()
+	if _, ok := m["gc/cycles/total_gc-cycles"].(CounterValue); !ok {
+		t.Fatalf("Expected gc/cycles/total_gc-cycles to be a counter. Got %T", m["gc/cycles/total_gc-cycles"])
+	}
+	if _, ok := m["sched/goroutines_goroutines"].(GaugeValue); !ok {
+		t.Fatalf("Expected sched/goroutines_goroutines to be a gauge. Got %T", m["sched/goroutines_goroutines"])
+	}
+	if h, ok := m["sched/latencies_seconds"].(*Float64Histogram); !ok || len(h.Buckets) != len(h.Counts)+1 {
+		t.Fatalf("Expected sched/latencies_seconds to be a histogram. Got %T", m["sched/latencies_seconds"])
+	}
+
+	for name := range NewRuntimeCollection(RuntimeGC...).Metrics() {
+		if !strings.HasPrefix(name, "gc/") {
+			t.Fatalf("Expected only gc metrics. Got %s", name)
+		}
+	}
+
+	r := NewRegistry()
+	r.Add("runtime", NewRuntimeCollection(RuntimeScheduler...))
+	snap := NewRegistrySnapshot(false)
+	snap.Snapshot(r)
+	if len(snap.Values) == 0 {
+		t.Fatal("Expected runtime metrics in snapshot")
+	}
+}
+
+func TestRuntimeMetricName(t *testing.T) {
+	cases := map[string]string{
+		"/gc/heap/allocs:bytes":                      "gc/heap/allocs_bytes",
+		"/gc/heap/allocs-by-size:bytes":              "gc/heap/allocs-by-size_bytes",
+		"/cpu/classes/gc/total:cpu-seconds":          "cpu/classes/gc/total_cpu-seconds",
+		"/test/rate:bytes/second":                    "test/rate_bytes_per_second",
+		"/test/product:byte*cpu-seconds":             "test/product_byte_cpu-seconds",
+		"/memory/classes/heap/objects:bytes":         "memory/classes/heap/objects_bytes",
+		"/sched/pauses/total/gc:seconds":             "sched/pauses/total/gc_seconds",
+		"/godebug/non-default-behavior/http2:events": "godebug/non-default-behavior/http2_events",
+	}
+	for in, exp := range cases {
+		if out := runtimeMetricName(in); out != exp {
+			t.Errorf("Expected %s for %s. Got %s", exp, in, out)
+		}
+	}
 }
@@ -0,0 +1,153 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+)
+
+// cacheLineSize is the size that stripes are padded to. It's twice the
+// usual 64 bytes since some CPUs fetch pairs of cache lines.
+const cacheLineSize = 128
+
+type stripe struct {
+	value uint64
+	_     [cacheLineSize - 8]byte
+}
+
+// stripes spreads updates over slots on separate cache lines. Each update
+// goes to a random slot using the runtime's per thread generator, so
+// concurrent updates rarely touch the same line, and reads sum all slots.
+type stripes struct {
+	slots []stripe
+	mask  uint32
+}
+
+// makeStripes returns enough slots for GOMAXPROCS rounded up to a power
+// of two.
+func makeStripes() stripes {
+	n := uint(runtime.GOMAXPROCS(0))
+	if n > 1 {
+		n = 1 << bits.Len(n-1)
+	}
+	return stripes{slots: make([]stripe, n), mask: uint32(n - 1)}
+}
+
+func (s *stripes) add(delta uint64) {
+	atomic.AddUint64(&s.slots[rand.Uint32()&s.mask].value, delta)
+}
+
+func (s *stripes) sum() uint64 {
+	var sum uint64
+	for i := range s.slots {
+		sum += atomic.LoadUint64(&s.slots[i].value)
+	}
+	return sum
+}
+
+func (s *stripes) swap(value uint64) uint64 {
+	old := atomic.SwapUint64(&s.slots[0].value, value)
+	for i := 1; i < len(s.slots); i++ {
+		old += atomic.SwapUint64(&s.slots[i].value, 0)
+	}
+	return old
+}
+
+// StripedCounter is a Counter for counters that are updated from many
+// goroutines at once. Updates are spread over a slot per processor so they
+// don't contend on one cache line at the cost of memory (128 bytes per
+// slot) and slower reads. Slots are sized by GOMAXPROCS when the counter
+// is created.
+type StripedCounter struct {
+	stripes stripes
+}
+
+// NewStripedCounter returns a counter with a slot per processor.
+func NewStripedCounter() *StripedCounter {
+	return &StripedCounter{makeStripes()}
+}
+
+func (c *StripedCounter) Inc(delta uint64) {
+	c.stripes.add(delta)
+}
+
+func (c *StripedCounter) Count() uint64 {
+	return c.stripes.sum()
+}
+
+// Reset sets the count to zero and returns what it was. Increments that
+// happen at the same time are either included in the result or kept but
+// never lost.
+func (c *StripedCounter) Reset() uint64 {
+	return c.stripes.swap(0)
+}
+
+func (c *StripedCounter) String() string {
+	return strconv.FormatUint(c.Count(), 10)
+}
+
+func (c *StripedCounter) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *StripedCounter) MarshalText() ([]byte, error) {
+	return c.MarshalJSON()
+}
+
+// StripedIntegerGauge is an IntegerGauge for gauges that are updated from
+// many goroutines at once such as the number of requests in progress. Like
+// StripedCounter it trades memory and slower reads for uncontended
+// updates. The value is the sum of the slots which are added to with
+// wrapping arithmetic.
+type StripedIntegerGauge struct {
+	stripes stripes
+}
+
+// NewStripedIntegerGauge returns a gauge with a slot per processor.
+func NewStripedIntegerGauge() *StripedIntegerGauge {
+	return &StripedIntegerGauge{makeStripes()}
+}
+
+func (c *StripedIntegerGauge) Inc(delta int64) {
+	c.stripes.add(uint64(delta))
+}
+
+func (c *StripedIntegerGauge) Dec(delta int64) {
+	c.stripes.add(uint64(-delta))
+}
+
+// Set replaces the value. It isn't atomic with respect to concurrent
+// updates which may be applied before or after it.
+func (c *StripedIntegerGauge) Set(value int64) {
+	c.stripes.swap(uint64(value))
+}
+
+func (c *StripedIntegerGauge) Reset() int64 {
+	return int64(c.stripes.swap(0))
+}
+
+func (c *StripedIntegerGauge) IntegerValue() int64 {
+	return int64(c.stripes.sum())
+}
+
+func (c *StripedIntegerGauge) Value() float64 {
+	return float64(c.IntegerValue())
+}
+
+func (c *StripedIntegerGauge) String() string {
+	return strconv.FormatInt(c.IntegerValue(), 10)
+}
+
+func (c *StripedIntegerGauge) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *StripedIntegerGauge) MarshalText() ([]byte, error) {
+	return c.MarshalJSON()
+}
@@ -0,0 +1,128 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+var (
+	_ CounterMetric = &StripedCounter{}
+	_ GaugeMetric   = &StripedIntegerGauge{}
+)
+
+func TestStripedCounter(t *testing.T) {
+	if s := unsafe.Sizeof(stripe{}); s != cacheLineSize {
+		t.Fatalf("Expected stripes of %d bytes. Got %d", cacheLineSize, s)
+	}
+	c := NewStripedCounter()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for range 1000 {
+				c.Inc(2)
+			}
+		})
+	}
+	wg.Wait()
+	if n := c.Count(); n != 16000 {
+		t.Fatalf("Expected 16000. Got %d", n)
+	}
+	if n := c.Reset(); n != 16000 || c.Count() != 0 {
+		t.Fatalf("Expected reset to return 16000 and clear the count. Got %d %d", n, c.Count())
+	}
+	if c.Inc(3); c.String() != "3" {
+		t.Fatalf("Expected 3. Got %s", c.String())
+	}
+}
+
+func TestStripedCounterLatched(t *testing.T) {
+	reg := NewRegistry()
+	c := NewStripedCounter()
+	reg.Add("striped", c)
+	snap := NewRegistrySnapshot(true)
+
+	c.Inc(3)
+	snap.Snapshot(reg)
+	if len(snap.Values) != 1 || snap.Values[0].Value != 3 {
+		t.Fatalf("Expected a count of 3. Got %+v", snap.Values)
+	}
+	if n := c.Count(); n != 0 {
+		t.Fatalf("Expected the counter to be reset by a latched snapshot. Got %d", n)
+	}
+	c.Inc(2)
+	snap.Snapshot(reg)
+	if len(snap.Values) != 1 || snap.Values[0].Value != 2 {
+		t.Fatalf("Expected a count of 2. Got %+v", snap.Values)
+	}
+}
+
+func TestStripedIntegerGauge(t *testing.T) {
+	g := NewStripedIntegerGauge()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for range 1000 {
+				g.Inc(3)
+				g.Dec(1)
+			}
+		})
+	}
+	wg.Wait()
+	if v := g.IntegerValue(); v != 16000 {
+		t.Fatalf("Expected 16000. Got %d", v)
+	}
+	g.Set(-5)
+	g.Dec(2)
+	if v := g.Value(); v != -7 {
+		t.Fatalf("Expected -7. Got %f", v)
+	}
+	if v := g.Reset(); v != -7 || g.IntegerValue() != 0 {
+		t.Fatalf("Expected reset to return -7 and clear the value. Got %d %d", v, g.IntegerValue())
+	}
+}
+
+// benchmarkContended runs inc from GOMAXPROCS goroutines for several
+// values of GOMAXPROCS. The metric is created after GOMAXPROCS is set.
+func benchmarkContended[T any](b *testing.B, factory func() T, inc func(T)) {
+	for _, procs := range []int{1, 4, 16, 64} {
+		b.Run("procs="+strconv.Itoa(procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			m := factory()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					inc(m)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkCounterIncContended(b *testing.B) {
+	benchmarkContended(b, NewCounter, func(c *Counter) { c.Inc(1) })
+}
+
+func BenchmarkStripedCounterIncContended(b *testing.B) {
+	benchmarkContended(b, NewStripedCounter, func(c *StripedCounter) { c.Inc(1) })
+}
+
+func BenchmarkIntegerGaugeIncContended(b *testing.B) {
+	benchmarkContended(b, NewIntegerGauge, func(g *IntegerGauge) { g.Inc(1) })
+}
+
+func BenchmarkStripedIntegerGaugeIncContended(b *testing.B) {
+	benchmarkContended(b, NewStripedIntegerGauge, func(g *StripedIntegerGauge) { g.Inc(1) })
+}
+
+func BenchmarkStripedCounterCount(b *testing.B) {
+	c := NewStripedCounter()
+	for b.Loop() {
+		c.Count()
+	}
+}
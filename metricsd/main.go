@@ -7,7 +7,6 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
-	"expvar"
 	"flag"
 	"fmt"
 	"log"
@@ -49,13 +48,14 @@ var (
 )
 
 func init() {
-	m := expvar.NewMap("metricsd")
-	m.Set("requests", statRequestCount)
-	m.Set("requests_per_sec", statRequestRate)
-	m.Set("graphite_latency_us", &metrics.HistogramExport{Histogram: statGraphiteLatency,
-		Percentiles: []float64{0.5, 0.9, 0.99, 0.999}, PercentileNames: []string{"p50", "p90", "p99", "p999"}})
-	m.Set("stathat_latency_us", &metrics.HistogramExport{Histogram: statStatHatLatency,
-		Percentiles: []float64{0.5, 0.9, 0.99, 0.999}, PercentileNames: []string{"p50", "p90", "p99", "p999"}})
+	r := metrics.NewRegistry()
+	r.Add("requests", statRequestCount)
+	r.Add("requests_per_sec", statRequestRate)
+	r.Add("graphite_latency_us", &metrics.HistogramExport{Histogram: statGraphiteLatency,
+		Percentiles: []float64{0.5, 0.9, 0.99, 0.999}})
+	r.Add("stathat_latency_us", &metrics.HistogramExport{Histogram: statStatHatLatency,
+		Percentiles: []float64{0.5, 0.9, 0.99, 0.999}})
+	metrics.PublishRegistry("metricsd", r)
 }
 
 func main() {
@@ -0,0 +1,103 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package prommetrics
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samuel/go-metrics/metrics"
+)
+
+// Collector is a prometheus.Collector of the metrics in a registry.
+type Collector struct {
+	registry metrics.Registry
+}
+
+var _ prometheus.Collector = &Collector{}
+
+// NewCollector returns a collector of the metrics in the registry, which
+// is walked whenever the collector is collected. Register it with a
+// Prometheus registry to serve this module's metrics with client_golang.
+//
+// Metrics are converted by metrics.OpenMetricsFamilies the same way as for
+// metrics.WriteOpenMetrics. Counters have _total appended, a metric's
+// description is its help, and its tags are labels.
+//
+// The collector is unchecked since the metrics in the registry aren't
+// known in advance, so Prometheus doesn't detect conflicts when it's
+// registered but only when it's gathered.
+func NewCollector(registry metrics.Registry) *Collector {
+	return &Collector{registry}
+}
+
+// Describe sends nothing which makes the collector unchecked.
+func (c *Collector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	metrics.Visit(c.registry, func(name string, metric any, md metrics.Metadata) error {
+		for _, f := range metrics.OpenMetricsFamilies(name, metric, md) {
+			m, err := convert(f)
+			if err != nil {
+				log.Printf("metrics/prommetrics: failed to convert %s: %s", name, err.Error())
+				continue
+			}
+			ch <- m
+		}
+		return nil
+	})
+}
+
+func convert(f metrics.OpenMetricsFamily) (prometheus.Metric, error) {
+	name := f.Name
+	if f.Type == "counter" {
+		name += "_total"
+	}
+	help := f.Metadata.Description
+	if help == "" {
+		help = name
+	}
+	desc := prometheus.NewDesc(name, help, nil, promLabels(f.Metadata.Tags))
+	switch f.Type {
+	case "counter":
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, f.Value)
+	case "summary":
+		quantiles := make(map[float64]float64, len(f.Quantiles))
+		for i, q := range f.Quantiles {
+			quantiles[q] = f.QuantileValues[i]
+		}
+		return prometheus.NewConstSummary(desc, f.Count, f.Sum, quantiles)
+	case "histogram":
+		buckets := make(map[float64]uint64, len(f.Buckets))
+		for i, le := range f.Buckets {
+			buckets[le] = f.BucketCounts[i]
+		}
+		return prometheus.NewConstHistogram(desc, f.Count, f.Sum, buckets)
+	}
+	return prometheus.NewConstMetric(desc, prometheus.GaugeValue, f.Value)
+}
+
+func promLabels(tags map[string]string) prometheus.Labels {
+	if len(tags) == 0 {
+		return nil
+	}
+	labels := make(prometheus.Labels, len(tags))
+	for k, v := range tags {
+		labels[labelName(k)] = v
+	}
+	return labels
+}
+
+// labelName replaces characters that aren't allowed in label names with
+// underscores.
+func labelName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
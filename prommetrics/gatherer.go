@@ -0,0 +1,110 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+// Package prommetrics bridges Prometheus client_golang registries and the
+// registries of this module in both directions.
+package prommetrics
+
+import (
+	"log"
+	"math"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/samuel/go-metrics/metrics"
+)
+
+// GathererCollection is a metrics.Collection of the metrics gathered from
+// a Prometheus Gatherer such as prometheus.DefaultGatherer.
+type GathererCollection struct {
+	gatherer prometheus.Gatherer
+}
+
+// NewGathererCollection returns a collection of the metrics gathered from
+// g. Add it to a registry to report them along with its own metrics.
+//
+// Each metric is named after its family with the values of its labels, in
+// the order of the label names, appended after slashes. For instance
+// http_requests_total with the labels code="200" and method="GET" becomes
+// http_requests_total/200/GET. Use the TagsFromName middleware with a
+// pattern such as http_requests_total/{code}/{method} to turn them back
+// into tags.
+//
+// Counters are metrics.Float64CounterValue, and gauges and untyped metrics
+// are gauges.
+// Histograms are *metrics.Float64Histogram. Summaries are split into a
+// counter named /count, a gauge named /sum, and a gauge for each quantile
+// named like metrics.PercentileName (for instance /p99).
+func NewGathererCollection(g prometheus.Gatherer) *GathererCollection {
+	return &GathererCollection{g}
+}
+
+func (c *GathererCollection) Metrics() map[string]any {
+	families, err := c.gatherer.Gather()
+	if err != nil {
+		// Gather returns as much as it can along with the error
+		log.Printf("metrics/prommetrics: failed to gather metrics: %s", err.Error())
+	}
+	out := make(map[string]any)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			name := metricName(f.GetName(), m.GetLabel())
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				out[name] = metrics.Float64CounterValue(m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				out[name] = metrics.GaugeValue(m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				out[name] = metrics.GaugeValue(m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				out[name] = float64Histogram(m.GetHistogram())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				out[name+"/count"] = metrics.CounterValue(s.GetSampleCount())
+				out[name+"/sum"] = metrics.GaugeValue(s.GetSampleSum())
+				for _, q := range s.GetQuantile() {
+					out[name+"/"+metrics.PercentileName(q.GetQuantile())] = metrics.GaugeValue(q.GetValue())
+				}
+			}
+		}
+	}
+	return out
+}
+
+func metricName(family string, labels []*dto.LabelPair) string {
+	if len(labels) == 0 {
+		return family
+	}
+	var b strings.Builder
+	b.WriteString(family)
+	for _, l := range labels {
+		b.WriteByte('/')
+		b.WriteString(l.GetValue())
+	}
+	return b.String()
+}
+
+// float64Histogram converts the cumulative buckets of a Prometheus
+// histogram, whose lower bound is implicitly -Inf, to the counts per bucket
+// of a Float64Histogram.
+func float64Histogram(h *dto.Histogram) *metrics.Float64Histogram {
+	buckets := h.GetBucket()
+	out := &metrics.Float64Histogram{
+		Counts:  make([]uint64, 0, len(buckets)+1),
+		Buckets: append(make([]float64, 0, len(buckets)+2), math.Inf(-1)),
+	}
+	var prev uint64
+	for _, b := range buckets {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			continue
+		}
+		out.Buckets = append(out.Buckets, b.GetUpperBound())
+		out.Counts = append(out.Counts, b.GetCumulativeCount()-prev)
+		prev = b.GetCumulativeCount()
+	}
+	out.Buckets = append(out.Buckets, math.Inf(1))
+	out.Counts = append(out.Counts, h.GetSampleCount()-prev)
+	return out
+}
@@ -0,0 +1,102 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package prommetrics
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/samuel/go-metrics/metrics"
+)
+
+func TestGathererCollection(t *testing.T) {
+	pr := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "http_requests_total", Help: "h"}, []string{"method", "code"})
+	requests.WithLabelValues("GET", "200").Add(3.5)
+	queue := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue", Help: "h"})
+	queue.Set(2.5)
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency", Help: "h", Buckets: []float64{1, 5}})
+	for _, v := range []float64{0.5, 2, 3, 10} {
+		latency.Observe(v)
+	}
+	sizes := prometheus.NewSummary(prometheus.SummaryOpts{Name: "sizes", Help: "h", Objectives: map[float64]float64{0.5: 0.05}})
+	sizes.Observe(4)
+	pr.MustRegister(requests, queue, latency, sizes)
+
+	reg := metrics.NewRegistry()
+	reg.Add("prom", NewGathererCollection(pr))
+	values := make(map[string]any)
+	reg.Do(func(name string, m any) error {
+		values[name] = m
+		return nil
+	})
+
+	expected := map[string]any{
+		"prom/http_requests_total/200/GET": metrics.Float64CounterValue(3.5),
+		"prom/queue":                       metrics.GaugeValue(2.5),
+		"prom/sizes/count":                 metrics.CounterValue(1),
+		"prom/sizes/sum":                   metrics.GaugeValue(4),
+		"prom/sizes/p50":                   metrics.GaugeValue(4),
+		"prom/latency": &metrics.Float64Histogram{
+			Counts:  []uint64{1, 2, 1},
+			Buckets: []float64{math.Inf(-1), 1, 5, math.Inf(1)},
+		},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("Expected %+v. Got %+v", expected, values)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	reg := metrics.NewRegistry()
+	c := metrics.NewCounter()
+	c.Inc(4)
+	metrics.AddWithOptions(reg, "http/requests", c, metrics.WithDescription("Number of requests"), metrics.WithTags(map[string]string{"route": "/"}))
+	reg.Add("queue", metrics.GaugeValue(3))
+	reg.Add("cpu_seconds_total", metrics.Float64CounterValue(0.5))
+	metrics.AddWithOptions(reg, "latency", metrics.NewDefaultBucketedHistogram(), metrics.WithUnit(metrics.UnitMicroseconds))
+	reg.Add("pauses", &metrics.Float64Histogram{
+		Counts:  []uint64{1, 2},
+		Buckets: []float64{0, 1, math.Inf(1)},
+	})
+
+	pr := prometheus.NewRegistry()
+	pr.MustRegister(NewCollector(reg))
+	families, err := pr.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*dto.MetricFamily)
+	for _, f := range families {
+		byName[f.GetName()] = f
+	}
+	if len(byName) != 5 {
+		t.Fatalf("Expected 5 families. Got %v", families)
+	}
+
+	f := byName["http_requests_total"]
+	if f.GetType() != dto.MetricType_COUNTER || f.GetHelp() != "Number of requests" || f.GetMetric()[0].GetCounter().GetValue() != 4 {
+		t.Fatalf("Unexpected counter %v", f)
+	}
+	if l := f.GetMetric()[0].GetLabel(); len(l) != 1 || l[0].GetName() != "route" || l[0].GetValue() != "/" {
+		t.Fatalf("Expected route label. Got %v", l)
+	}
+	if f := byName["queue"]; f.GetType() != dto.MetricType_GAUGE || f.GetMetric()[0].GetGauge().GetValue() != 3 {
+		t.Fatalf("Unexpected gauge %v", f)
+	}
+	if f := byName["cpu_seconds_total"]; f.GetType() != dto.MetricType_COUNTER || f.GetMetric()[0].GetCounter().GetValue() != 0.5 {
+		t.Fatalf("Unexpected float counter %v", f)
+	}
+	if f := byName["latency_microseconds"]; f.GetType() != dto.MetricType_SUMMARY || len(f.GetMetric()[0].GetSummary().GetQuantile()) != len(metrics.DefaultPercentiles) {
+		t.Fatalf("Unexpected summary %v", f)
+	}
+	h := byName["pauses"].GetMetric()[0].GetHistogram()
+	if h.GetSampleCount() != 3 || len(h.GetBucket()) != 1 || h.GetBucket()[0].GetCumulativeCount() != 1 {
+		t.Fatalf("Unexpected histogram %v", h)
+	}
+}
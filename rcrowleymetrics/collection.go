@@ -0,0 +1,142 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+// Package rcrowleymetrics reports the metrics of a github.com/rcrowley/go-metrics
+// registry through a registry of this module.
+package rcrowleymetrics
+
+import (
+	"math"
+	"time"
+
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/samuel/go-metrics/metrics"
+)
+
+// Collection is a metrics.Collection of the metrics in a go-metrics
+// registry.
+type Collection struct {
+	registry gometrics.Registry
+}
+
+// NewCollection returns a collection of the metrics in the go-metrics
+// registry. Add it to a registry to report them along with its own
+// metrics. Names are unchanged so they're usually separated by dots rather
+// than slashes.
+//
+// Metrics are converted as follows:
+//
+//   - Counters, gauges and EWMAs are gauges. Counters can be decremented
+//     so they aren't necessarily increasing.
+//   - Histograms and timers are metrics.Histogram whose values are
+//     snapshots of the originals. Clearing one does nothing so their
+//     values are always the lifetime ones. Timers are in nanoseconds.
+//   - Meters and the rates of timers are gauges of their rates named
+//     after the metric with /1m, /5m, and /15m appended like *metrics.Meter.
+//     A meter's count is a counter named /count.
+//
+// Healthchecks and unknown types are skipped.
+func NewCollection(registry gometrics.Registry) *Collection {
+	return &Collection{registry}
+}
+
+func (c *Collection) Metrics() map[string]any {
+	out := make(map[string]any)
+	c.registry.Each(func(name string, metric any) {
+		switch m := metric.(type) {
+		case gometrics.Counter:
+			out[name] = metrics.GaugeValue(m.Count())
+		case gometrics.Gauge:
+			out[name] = metrics.GaugeValue(m.Value())
+		case gometrics.GaugeFloat64:
+			out[name] = metrics.GaugeValue(m.Value())
+		case gometrics.EWMA:
+			out[name] = metrics.GaugeValue(m.Rate())
+		case gometrics.Histogram:
+			out[name] = &histogram{
+				snapshot: m.Snapshot(),
+				update:   m.Update,
+			}
+		case gometrics.Timer:
+			s := m.Snapshot()
+			out[name] = &histogram{
+				snapshot: s,
+				update:   func(v int64) { m.Update(time.Duration(v)) },
+			}
+			addRates(out, name, s)
+		case gometrics.Meter:
+			s := m.Snapshot()
+			out[name+"/count"] = metrics.CounterValue(max(s.Count(), 0))
+			addRates(out, name, s)
+		}
+	})
+	return out
+}
+
+type rates interface {
+	Rate1() float64
+	Rate5() float64
+	Rate15() float64
+}
+
+func addRates(out map[string]any, name string, r rates) {
+	out[name+"/1m"] = metrics.GaugeValue(r.Rate1())
+	out[name+"/5m"] = metrics.GaugeValue(r.Rate5())
+	out[name+"/15m"] = metrics.GaugeValue(r.Rate15())
+}
+
+// sampled are the methods shared by go-metrics histograms and timers.
+type sampled interface {
+	Count() int64
+	Min() int64
+	Max() int64
+	Sum() int64
+	StdDev() float64
+	Percentiles([]float64) []float64
+}
+
+// histogram is a metrics.Histogram of a snapshot of a go-metrics
+// histogram or timer.
+type histogram struct {
+	snapshot sampled
+	update   func(int64)
+}
+
+var _ metrics.Histogram = &histogram{}
+
+// Clear does nothing so that snapshots don't wipe the original histogram
+// which may have other users. Its values are always the lifetime ones.
+func (h *histogram) Clear() {}
+
+func (h *histogram) Update(value int64) {
+	h.update(value)
+}
+
+func (h *histogram) Distribution() metrics.DistributionValue {
+	s := h.snapshot
+	return metrics.DistributionValue{
+		Count:    uint64(max(s.Count(), 0)),
+		Sum:      float64(s.Sum()),
+		Min:      float64(s.Min()),
+		Max:      float64(s.Max()),
+		Variance: s.StdDev() * s.StdDev(),
+	}
+}
+
+func (h *histogram) Percentiles(percentiles []float64) []int64 {
+	values := h.snapshot.Percentiles(percentiles)
+	out := make([]int64, len(values))
+	for i, v := range values {
+		out[i] = int64(math.Round(v))
+	}
+	return out
+}
+
+func (h *histogram) String() string {
+	return (&metrics.HistogramExport{
+		Histogram:       h,
+		Percentiles:     metrics.DefaultPercentiles,
+		PercentileNames: metrics.DefaultPercentileNames,
+	}).String()
+}
@@ -0,0 +1,85 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package rcrowleymetrics
+
+import (
+	"testing"
+	"time"
+
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/samuel/go-metrics/metrics"
+)
+
+func TestCollection(t *testing.T) {
+	gr := gometrics.NewRegistry()
+	gometrics.NewRegisteredCounter("requests", gr).Inc(3)
+	gometrics.NewRegisteredCounter("negative", gr).Dec(2)
+	gometrics.NewRegisteredGauge("queue", gr).Update(7)
+	gometrics.NewRegisteredGaugeFloat64("load", gr).Update(0.5)
+	h := gometrics.NewRegisteredHistogram("sizes", gr, gometrics.NewUniformSample(100))
+	for i := int64(1); i <= 4; i++ {
+		h.Update(i * 10)
+	}
+	timer := gometrics.NewRegisteredTimer("latency", gr)
+	timer.Update(time.Millisecond)
+	meter := gometrics.NewRegisteredMeter("events", gr)
+	defer meter.Stop()
+	defer timer.Stop()
+	meter.Mark(5)
+	gr.Register("health", gometrics.NewHealthcheck(func(gometrics.Healthcheck) {}))
+
+	reg := metrics.NewRegistry()
+	reg.Add("legacy", NewCollection(gr))
+	values := make(map[string]any)
+	reg.Do(func(name string, m any) error {
+		values[name] = m
+		return nil
+	})
+
+	expected := map[string]any{
+		"legacy/requests":     metrics.GaugeValue(3),
+		"legacy/negative":     metrics.GaugeValue(-2),
+		"legacy/queue":        metrics.GaugeValue(7),
+		"legacy/load":         metrics.GaugeValue(0.5),
+		"legacy/events/count": metrics.CounterValue(5),
+	}
+	for name, exp := range expected {
+		if values[name] != exp {
+			t.Fatalf("Expected %v for %s. Got %v", exp, name, values[name])
+		}
+	}
+	for _, name := range []string{"legacy/events/1m", "legacy/latency/5m", "legacy/latency/15m"} {
+		if _, ok := values[name].(metrics.GaugeValue); !ok {
+			t.Fatalf("Expected a gauge for %s. Got %T", name, values[name])
+		}
+	}
+	if _, ok := values["legacy/health"]; ok {
+		t.Fatal("Expected healthchecks to be skipped")
+	}
+
+	sizes := values["legacy/sizes"].(metrics.Histogram)
+	if v := sizes.Distribution(); v.Count != 4 || v.Sum != 100 || v.Min != 10 || v.Max != 40 {
+		t.Fatalf("Expected 4 sizes from 10 to 40. Got %+v", v)
+	}
+	if p := sizes.Percentiles([]float64{0.5}); p[0] != 25 {
+		t.Fatalf("Expected median of 25. Got %d", p[0])
+	}
+
+	// The collection works with snapshots
+	snap := metrics.NewRegistrySnapshot(false)
+	snap.Snapshot(reg)
+	if len(snap.Distributions) != 2 {
+		t.Fatalf("Expected 2 distributions. Got %+v", snap.Distributions)
+	}
+
+	// Snapshots don't clear the original histogram
+	sizes.Clear()
+	if h.Count() != 4 {
+		t.Fatalf("Expected the original histogram to keep its 4 values. Got %d", h.Count())
+	}
+	if v := values["legacy/latency"].(metrics.Histogram).Distribution(); v.Count != 1 || v.Max != 1e6 {
+		t.Fatalf("Expected a timing of 1ms in nanoseconds. Got %+v", v)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/samuel/go-metrics/rcrowleymetrics
+
+go 1.25
+
+require (
+	github.com/rcrowley/go-metrics v0.0.0-20250401214520-65e299d6c5c9
+	github.com/samuel/go-metrics v0.0.0
+)
+
+replace github.com/samuel/go-metrics => ../
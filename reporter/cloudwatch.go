@@ -24,8 +24,10 @@ type cloudWatchReporter struct {
 }
 
 type cloudWatchMetric struct {
-	value any
-	stats struct {
+	value      any
+	unit       string
+	dimensions map[string]string
+	stats      struct {
 		min         float64
 		max         float64
 		sum         float64
@@ -35,6 +37,34 @@ type cloudWatchMetric struct {
 
 const cloudWatchVersion = "2010-08-01"
 
+const cloudWatchStatisticSet = metrics.StatCount | metrics.StatSum | metrics.StatMin | metrics.StatMax
+
+// CloudWatch names of the units metrics knows about
+var cloudWatchUnits = map[string]string{
+	metrics.UnitSeconds:      "Seconds",
+	metrics.UnitMilliseconds: "Milliseconds",
+	metrics.UnitMicroseconds: "Microseconds",
+	metrics.UnitBytes:        "Bytes",
+	metrics.UnitPercent:      "Percent",
+}
+
+// cloudWatchUnit returns the CloudWatch unit for a value, or an empty
+// string if there's no equivalent.
+func cloudWatchUnit(unit string, kind metrics.Kind) string {
+	if u, ok := cloudWatchUnits[unit]; ok {
+		return u
+	}
+	if unit == "" {
+		switch kind {
+		case metrics.KindCounter:
+			return "Count"
+		case metrics.KindRate:
+			return "Count/Second"
+		}
+	}
+	return ""
+}
+
 type AWSAuthFunc func() (accessKey string, secretKey string, securityToken string)
 
 func NewCloudWatchReporter(registry metrics.Registry, interval time.Duration, latched bool, region string, authFunc AWSAuthFunc, namespace string, dimensions map[string]string, timeout time.Duration) *PeriodicReporter {
@@ -42,6 +72,12 @@ func NewCloudWatchReporter(registry metrics.Registry, interval time.Duration, la
 	return NewPeriodicReporter(registry, interval, true, latched, lr)
 }
 
+// NewCloudWatchBackend returns the reporter used by NewCloudWatchReporter
+// for use with NewPeriodicReporterWithOptions or a MultiReporter.
+func NewCloudWatchBackend(interval time.Duration, region string, authFunc AWSAuthFunc, namespace string, dimensions map[string]string, timeout time.Duration) Reporter {
+	return newCloudWatchReporter(interval, region, authFunc, namespace, dimensions, timeout)
+}
+
 func newCloudWatchReporter(interval time.Duration, region string, authFunc AWSAuthFunc, namespace string, dimensions map[string]string, timeout time.Duration) *cloudWatchReporter {
 	if timeout == 0 {
 		timeout = time.Second * 15
@@ -71,10 +107,24 @@ func (r *cloudWatchReporter) Report(snapshot *metrics.RegistrySnapshot) {
 	mets := make(map[string]cloudWatchMetric)
 
 	for _, v := range snapshot.Values {
-		mets[strings.ReplaceAll(v.Name, "/", ".")] = cloudWatchMetric{value: v.Value}
+		mets[strings.ReplaceAll(v.Name, "/", ".")] = cloudWatchMetric{value: v.Value, unit: cloudWatchUnit(v.Unit, v.Kind), dimensions: mergeTags(r.dimensions, v.Tags)}
 	}
 	for _, v := range snapshot.Distributions {
-		m := cloudWatchMetric{}
+		// A statistic set needs all of count, sum, min, and max so send the
+		// statistics individually if the distribution only wants some of them
+		// or is empty and wants to be reported anyway.
+		if v.Stats != 0 && (v.Stats&cloudWatchStatisticSet != cloudWatchStatisticSet || v.Value.Count == 0) {
+			name := strings.ReplaceAll(v.Name, "/", ".")
+			v.Stats.Each(v.Value, func(s metrics.Stats, stat string, value float64) {
+				unit := cloudWatchUnit(v.Unit, metrics.KindGauge)
+				if s == metrics.StatCount {
+					unit = "Count"
+				}
+				mets[name+"."+stat] = cloudWatchMetric{value: value, unit: unit, dimensions: mergeTags(r.dimensions, v.Tags)}
+			})
+			continue
+		}
+		m := cloudWatchMetric{unit: cloudWatchUnit(v.Unit, metrics.KindGauge), dimensions: mergeTags(r.dimensions, v.Tags)}
 		m.stats.min = v.Value.Min
 		m.stats.max = v.Value.Max
 		m.stats.sum = v.Value.Sum
@@ -88,6 +138,7 @@ func (r *cloudWatchReporter) Report(snapshot *metrics.RegistrySnapshot) {
 		params.Set("Namespace", r.namespace)
 		params.Set("Action", "PutMetricData")
 		params.Set("Version", cloudWatchVersion)
+		timestamp := snapshot.Time.UTC().Format(time.RFC3339)
 		idx := 1
 		for name, m := range mets {
 			prefix := fmt.Sprintf("MetricData.member.%d.", idx)
@@ -112,8 +163,12 @@ func (r *cloudWatchReporter) Report(snapshot *metrics.RegistrySnapshot) {
 				continue
 			}
 			params.Set(prefix+"MetricName", name)
+			if m.unit != "" {
+				params.Set(prefix+"Unit", m.unit)
+			}
+			params.Set(prefix+"Timestamp", timestamp)
 			dIdx := 0
-			for name, value := range r.dimensions {
+			for name, value := range m.dimensions {
 				dIdx++
 				p := fmt.Sprintf("%sDimensions.member.%d.", prefix, dIdx)
 				params.Set(p+"Name", name)
@@ -0,0 +1,55 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import (
+	"net"
+	"time"
+)
+
+// persistentConn is a lazily dialed connection that is reused across
+// reports and re-established after a failure.
+type persistentConn struct {
+	network string
+	addr    string
+	timeout time.Duration
+	conn    net.Conn
+}
+
+// write writes b and returns how much of it was written.
+func (c *persistentConn) write(b []byte) (int, error) {
+	if c.conn == nil {
+		conn, err := net.DialTimeout(c.network, c.addr, c.timeout)
+		if err != nil {
+			return 0, err
+		}
+		c.conn = conn
+	}
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.conn.Write(b)
+}
+
+// writeRetry writes b and if that fails retries once on a new connection
+// since a connection closed by the server while idle is usually only
+// noticed on write.
+func (c *persistentConn) writeRetry(b []byte) error {
+	_, err := c.write(b)
+	if err != nil {
+		c.close()
+		if _, err = c.write(b); err != nil {
+			c.close()
+		}
+	}
+	return err
+}
+
+func (c *persistentConn) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+const (
+	defaultDatadogURL     = "https://api.datadoghq.com"
+	defaultDatadogTimeout = time.Second * 15
+	// The series intake accepts at most 5MB uncompressed and 500KB compressed
+	maxDatadogPayloadSize           = 5000000
+	maxDatadogCompressedPayloadSize = 500000
+	datadogRetries                  = 3
+	datadogRetryDelay               = time.Second
+)
+
+// Datadog v2 metric intake types
+const (
+	datadogCount = 1
+	datadogRate  = 2
+	datadogGauge = 3
+)
+
+// Statistics reported for distributions that don't specify their own
+const datadogDistributionStats = metrics.StatCount | metrics.StatSum | metrics.StatMean | metrics.StatMin | metrics.StatMax
+
+// Datadog names of the units metrics knows about
+var datadogUnits = map[string]string{
+	metrics.UnitSeconds:      "second",
+	metrics.UnitMilliseconds: "millisecond",
+	metrics.UnitMicroseconds: "microsecond",
+	metrics.UnitNanoseconds:  "nanosecond",
+	metrics.UnitBytes:        "byte",
+	metrics.UnitPercent:      "percent",
+	metrics.UnitRatio:        "fraction",
+}
+
+// DatadogConfig configures a Datadog reporter.
+type DatadogConfig struct {
+	// APIKey is sent in the DD-API-KEY header.
+	APIKey string
+	// URL is the base URL of the Datadog API. Defaults to https://api.datadoghq.com
+	URL string
+	// Host is attached to every series as its host resource.
+	Host string
+	// Tags are attached to every series as key:value tags.
+	Tags map[string]string
+	// Timeout for each request. Defaults to 15 seconds.
+	Timeout time.Duration
+	// MaxPayloadSize is the maximum uncompressed size of a request body.
+	// Defaults to and is capped at 5MB.
+	MaxPayloadSize int
+}
+
+type datadogReporter struct {
+	seriesURL      string
+	apiKey         string
+	host           string
+	tagMap         map[string]string
+	tags           []string
+	interval       int64
+	maxPayloadSize int
+	retryDelay     time.Duration
+	client         *http.Client
+}
+
+type datadogPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type datadogResource struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type datadogSeries struct {
+	Metric    string            `json:"metric"`
+	Type      int               `json:"type"`
+	Interval  int64             `json:"interval,omitempty"`
+	Unit      string            `json:"unit,omitempty"`
+	Points    []datadogPoint    `json:"points"`
+	Resources []datadogResource `json:"resources,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+}
+
+// DatadogError is returned when the Datadog API rejects a request.
+type DatadogError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *DatadogError) Error() string {
+	return fmt.Sprintf("datadog: %d %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+func (e *DatadogError) temporary() bool {
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// NewDatadogReporter returns a periodic reporter that sends metrics directly
+// to Datadog's v2 series API without going through an agent.
+func NewDatadogReporter(registry metrics.Registry, interval time.Duration, latched bool, cfg DatadogConfig) *PeriodicReporter {
+	return NewPeriodicReporter(registry, interval, true, latched, newDatadogReporter(interval, cfg))
+}
+
+// NewDatadogBackend returns the reporter used by NewDatadogReporter for use
+// with NewPeriodicReporterWithOptions or a MultiReporter. Interval is the
+// reporting interval sent with each point.
+func NewDatadogBackend(interval time.Duration, cfg DatadogConfig) Reporter {
+	return newDatadogReporter(interval, cfg)
+}
+
+func newDatadogReporter(interval time.Duration, cfg DatadogConfig) *datadogReporter {
+	if cfg.URL == "" {
+		cfg.URL = defaultDatadogURL
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultDatadogTimeout
+	}
+	if cfg.MaxPayloadSize <= 0 || cfg.MaxPayloadSize > maxDatadogPayloadSize {
+		cfg.MaxPayloadSize = maxDatadogPayloadSize
+	}
+	return &datadogReporter{
+		seriesURL:      strings.TrimRight(cfg.URL, "/") + "/api/v2/series",
+		apiKey:         cfg.APIKey,
+		host:           cfg.Host,
+		tagMap:         cfg.Tags,
+		tags:           datadogTags(cfg.Tags),
+		interval:       int64(interval / time.Second),
+		maxPayloadSize: cfg.MaxPayloadSize,
+		retryDelay:     datadogRetryDelay,
+		client:         &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+func (r *datadogReporter) Report(snapshot *metrics.RegistrySnapshot) {
+	if err := r.ReportContext(context.Background(), snapshot); err != nil {
+		log.Printf("metrics/reporter/datadog: failed to send metrics: %s", err.Error())
+	}
+}
+
+func (r *datadogReporter) ReportContext(ctx context.Context, snapshot *metrics.RegistrySnapshot) error {
+	var errs []error
+	for _, payload := range r.payloads(r.series(snapshot)) {
+		if err := r.post(ctx, payload); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func datadogTags(tags map[string]string) []string {
+	out := make([]string, 0, len(tags))
+	for k, v := range tags {
+		out = append(out, k+":"+v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (r *datadogReporter) newSeries(name string, tags []string, typ int, ts, interval int64, value float64) datadogSeries {
+	s := datadogSeries{
+		Metric: strings.ReplaceAll(name, "/", "."),
+		Type:   typ,
+		Points: []datadogPoint{{Timestamp: ts, Value: value}},
+		Tags:   tags,
+	}
+	if typ == datadogCount || typ == datadogRate {
+		s.Interval = interval
+	}
+	if r.host != "" {
+		s.Resources = []datadogResource{{Name: r.host, Type: "host"}}
+	}
+	return s
+}
+
+func (r *datadogReporter) tagsFor(tags map[string]string) []string {
+	if len(tags) == 0 {
+		return r.tags
+	}
+	return datadogTags(mergeTags(r.tagMap, tags))
+}
+
+func (r *datadogReporter) series(snapshot *metrics.RegistrySnapshot) []datadogSeries {
+	ts := snapshot.Time.Unix()
+	if snapshot.Time.IsZero() {
+		ts = time.Now().Unix()
+	}
+	// Prefer the interval the snapshot actually covered
+	interval := r.interval
+	if d := snapshot.Interval().Round(time.Second); d > 0 {
+		interval = int64(d / time.Second)
+	}
+	// Datadog counts are deltas so running totals are sent as gauges
+	countType := datadogCount
+	if snapshot.Temporality() == metrics.TemporalityCumulative {
+		countType = datadogGauge
+	}
+	series := make([]datadogSeries, 0, len(snapshot.Values)+len(snapshot.Distributions)*5)
+	for _, v := range snapshot.Values {
+		typ := datadogGauge
+		switch v.Kind {
+		case metrics.KindCounter:
+			typ = countType
+		case metrics.KindRate:
+			typ = datadogRate
+		}
+		s := r.newSeries(v.Name, r.tagsFor(v.Tags), typ, ts, interval, v.Value)
+		s.Unit = datadogUnits[v.Unit]
+		series = append(series, s)
+	}
+	for _, v := range snapshot.Distributions {
+		tags := r.tagsFor(v.Tags)
+		stats := datadogDistributionStats
+		if v.Stats != 0 {
+			stats = v.Stats
+		}
+		stats.Each(v.Value, func(stat metrics.Stats, name string, value float64) {
+			typ := datadogGauge
+			switch stat {
+			case metrics.StatCount, metrics.StatSum:
+				typ = countType
+			case metrics.StatMean:
+				name = "avg"
+			}
+			s := r.newSeries(v.Name+"/"+name, tags, typ, ts, interval, value)
+			if stat != metrics.StatCount {
+				s.Unit = datadogUnits[v.Unit]
+			}
+			series = append(series, s)
+		})
+	}
+	return series
+}
+
+// payloads encodes the series into as few request bodies as possible
+// without any exceeding the maximum payload size.
+func (r *datadogReporter) payloads(series []datadogSeries) [][]byte {
+	const prefix, suffix = `{"series":[`, `]}`
+	var payloads [][]byte
+	var buf bytes.Buffer
+	for _, s := range series {
+		b, err := json.Marshal(s)
+		if err != nil {
+			log.Printf("metrics/reporter/datadog: failed to encode %s: %s", s.Metric, err.Error())
+			continue
+		}
+		if len(prefix)+len(b)+len(suffix) > r.maxPayloadSize {
+			log.Printf("metrics/reporter/datadog: series %s is larger than the maximum payload size", s.Metric)
+			continue
+		}
+		if buf.Len() > 0 && buf.Len()+1+len(b)+len(suffix) > r.maxPayloadSize {
+			buf.WriteString(suffix)
+			payloads = append(payloads, bytes.Clone(buf.Bytes()))
+			buf.Reset()
+		}
+		if buf.Len() == 0 {
+			buf.WriteString(prefix)
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.Write(b)
+	}
+	if buf.Len() > 0 {
+		buf.WriteString(suffix)
+		payloads = append(payloads, buf.Bytes())
+	}
+	return payloads
+}
+
+func (r *datadogReporter) post(ctx context.Context, payload []byte) error {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	zw.Write(payload)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if body.Len() > maxDatadogCompressedPayloadSize {
+		// Incompressible payloads are rare enough that simply halving is fine
+		var series struct {
+			Series []datadogSeries `json:"series"`
+		}
+		if err := json.Unmarshal(payload, &series); err != nil {
+			return err
+		}
+		if len(series.Series) < 2 {
+			return fmt.Errorf("datadog: compressed payload of %d bytes exceeds the maximum size", body.Len())
+		}
+		half := len(series.Series) / 2
+		for _, part := range [][]datadogSeries{series.Series[:half], series.Series[half:]} {
+			for _, p := range r.payloads(part) {
+				if err := r.post(ctx, p); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	var err error
+	for attempt := 0; attempt < datadogRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(r.retryDelay << (attempt - 1)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err = r.send(ctx, body.Bytes()); err == nil {
+			return nil
+		}
+		if e, ok := err.(*DatadogError); ok && !e.temporary() {
+			return err
+		}
+	}
+	return err
+}
+
+func (r *datadogReporter) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", r.seriesURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("DD-API-KEY", r.apiKey)
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 == 2 {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	e := &DatadogError{StatusCode: res.StatusCode}
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	var errRes struct {
+		Errors []string `json:"errors"`
+	}
+	if json.Unmarshal(b, &errRes) == nil && len(errRes.Errors) != 0 {
+		e.Errors = errRes.Errors
+	} else if len(b) != 0 {
+		e.Errors = []string{strings.TrimSpace(string(b))}
+	}
+	return e
+}
@@ -0,0 +1,203 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+type datadogTestServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests int
+	series   []datadogSeries
+	status   []int
+}
+
+func newDatadogTestServer(t *testing.T, status ...int) *datadogTestServer {
+	s := &datadogTestServer{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		if r.URL.Path != "/api/v2/series" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		if k := r.Header.Get("DD-API-KEY"); k != "key" {
+			t.Errorf("Expected API key 'key'. Got %q", k)
+		}
+		if len(s.status) != 0 {
+			code := s.status[0]
+			s.status = s.status[1:]
+			if code != http.StatusAccepted {
+				w.WriteHeader(code)
+				w.Write([]byte(`{"errors":["nope"]}`))
+				return
+			}
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var body struct {
+			Series []datadogSeries `json:"series"`
+		}
+		if err := json.NewDecoder(zr).Decode(&body); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.series = append(s.series, body.Series...)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"errors":[]}`))
+	}))
+	return s
+}
+
+func TestDatadogReporter(t *testing.T) {
+	s := newDatadogTestServer(t)
+	defer s.Close()
+
+	r := newDatadogReporter(time.Minute, DatadogConfig{APIKey: "key", URL: s.URL, Host: "web1", Tags: map[string]string{"env": "prod"}})
+	r.Report(&metrics.RegistrySnapshot{
+		Values: []metrics.NamedValue{
+			{Name: "requests", Value: 10, Kind: metrics.KindCounter},
+			{Name: "rate/1m", Value: 0.5, Kind: metrics.KindRate},
+			{Name: "goroutines", Value: 3},
+		},
+		Distributions: []metrics.NamedDistribution{
+			{Name: "latency", Value: metrics.DistributionValue{Count: 2, Sum: 10, Min: 4, Max: 6}},
+		},
+		Time: time.Unix(1700000000, 0),
+	})
+
+	if len(s.series) != 8 {
+		t.Fatalf("Expected 8 series. Got %d: %+v", len(s.series), s.series)
+	}
+	exp := map[string]struct {
+		typ      int
+		interval int64
+		value    float64
+	}{
+		"requests":    {datadogCount, 60, 10},
+		"rate.1m":     {datadogRate, 60, 0.5},
+		"goroutines":  {datadogGauge, 0, 3},
+		"latency.sum": {datadogCount, 60, 10},
+		"latency.avg": {datadogGauge, 0, 5},
+	}
+	for _, ser := range s.series {
+		if ser.Points[0].Timestamp != 1700000000 {
+			t.Errorf("Expected timestamp 1700000000 for %s. Got %d", ser.Metric, ser.Points[0].Timestamp)
+		}
+		if len(ser.Resources) != 1 || ser.Resources[0].Name != "web1" || ser.Resources[0].Type != "host" {
+			t.Errorf("Expected host resource for %s. Got %+v", ser.Metric, ser.Resources)
+		}
+		if len(ser.Tags) != 1 || ser.Tags[0] != "env:prod" {
+			t.Errorf("Expected tags [env:prod] for %s. Got %+v", ser.Metric, ser.Tags)
+		}
+		e, ok := exp[ser.Metric]
+		if !ok {
+			continue
+		}
+		if ser.Type != e.typ || ser.Interval != e.interval || ser.Points[0].Value != e.value {
+			t.Errorf("Expected %s to be %+v. Got %+v", ser.Metric, e, ser)
+		}
+	}
+}
+
+func TestDatadogReporterSplitsPayloads(t *testing.T) {
+	s := newDatadogTestServer(t)
+	defer s.Close()
+
+	r := newDatadogReporter(time.Minute, DatadogConfig{APIKey: "key", URL: s.URL, MaxPayloadSize: 256})
+	snapshot := &metrics.RegistrySnapshot{Time: time.Unix(1700000000, 0)}
+	for range 20 {
+		snapshot.Values = append(snapshot.Values, metrics.NamedValue{Name: "some/long/metric/name", Value: 1})
+	}
+	payloads := r.payloads(r.series(snapshot))
+	for _, p := range payloads {
+		if len(p) > 256 {
+			t.Fatalf("Payload of %d bytes exceeds maximum size", len(p))
+		}
+	}
+	r.Report(snapshot)
+	if s.requests != len(payloads) || s.requests < 2 {
+		t.Fatalf("Expected %d (>1) requests. Got %d", len(payloads), s.requests)
+	}
+	if len(s.series) != 20 {
+		t.Fatalf("Expected 20 series. Got %d", len(s.series))
+	}
+}
+
+func TestDatadogReporterErrors(t *testing.T) {
+	s := newDatadogTestServer(t, http.StatusServiceUnavailable, http.StatusAccepted)
+	defer s.Close()
+
+	r := newDatadogReporter(time.Minute, DatadogConfig{APIKey: "key", URL: s.URL})
+	r.retryDelay = time.Millisecond
+	payload := []byte(`{"series":[]}`)
+	if err := r.post(context.Background(), payload); err != nil {
+		t.Fatalf("Expected temporary error to be retried. Got %s", err)
+	}
+	if s.requests != 2 {
+		t.Fatalf("Expected 2 requests. Got %d", s.requests)
+	}
+
+	s.status = []int{http.StatusForbidden}
+	s.requests = 0
+	err := r.post(context.Background(), payload)
+	if e, ok := err.(*DatadogError); !ok || e.StatusCode != http.StatusForbidden || len(e.Errors) != 1 || e.Errors[0] != "nope" {
+		t.Fatalf("Expected DatadogError with status 403. Got %#v", err)
+	}
+	if s.requests != 1 {
+		t.Fatalf("Expected permanent error not to be retried. Got %d requests", s.requests)
+	}
+}
+
+func TestDatadogReporterCumulative(t *testing.T) {
+	reg := metrics.NewRegistry()
+	counter := metrics.NewCounter()
+	counter.Inc(3)
+	reg.Add("counter", counter)
+	snapshot := metrics.NewRegistrySnapshotWithOptions(metrics.SnapshotOptions{Temporality: metrics.TemporalityCumulative})
+	snapshot.Snapshot(reg)
+
+	r := newDatadogReporter(time.Minute, DatadogConfig{})
+	series := r.series(snapshot)
+	if len(series) != 1 || series[0].Type != datadogGauge || series[0].Interval != 0 {
+		t.Fatalf("Expected cumulative counter to be sent as a gauge. Got %+v", series)
+	}
+}
+
+func TestDatadogReporterUnits(t *testing.T) {
+	snapshot := &metrics.RegistrySnapshot{
+		Time:   time.Unix(1700000000, 0),
+		Values: []metrics.NamedValue{{Name: "heap", Value: 1, Unit: metrics.UnitBytes}},
+		Distributions: []metrics.NamedDistribution{
+			{Name: "latency", Value: metrics.DistributionValue{Count: 1, Sum: 2}, Stats: metrics.StatCount | metrics.StatSum, Unit: metrics.UnitSeconds},
+		},
+	}
+	r := newDatadogReporter(time.Minute, DatadogConfig{})
+	units := make(map[string]string)
+	for _, s := range r.series(snapshot) {
+		units[s.Metric] = s.Unit
+	}
+	exp := map[string]string{"heap": "byte", "latency.count": "", "latency.sum": "second"}
+	if !reflect.DeepEqual(units, exp) {
+		t.Fatalf("Expected units %v. Got %v", exp, units)
+	}
+}
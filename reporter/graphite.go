@@ -5,55 +5,324 @@
 package reporter
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/binary"
 	"log"
-	"net"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/samuel/go-metrics/metrics"
 )
 
+// GraphiteProtocol selects the wire format used to send metrics to Carbon.
+type GraphiteProtocol int
+
+const (
+	// GraphitePlaintext sends one "name value timestamp" line per metric.
+	GraphitePlaintext GraphiteProtocol = iota
+	// GraphitePickle sends batches of metrics using Carbon's pickle
+	// protocol. It's only supported over TCP.
+	GraphitePickle
+)
+
+// GraphiteFields selects which statistics are reported for a distribution.
+type GraphiteFields = metrics.Stats
+
+const (
+	GraphiteMean   = metrics.StatMean
+	GraphiteCount  = metrics.StatCount
+	GraphiteMin    = metrics.StatMin
+	GraphiteMax    = metrics.StatMax
+	GraphiteSum    = metrics.StatSum
+	GraphiteStdDev = metrics.StatStdDev
+)
+
+const (
+	defaultGraphiteTimeout   = time.Second * 10
+	defaultGraphiteBatchSize = 500
+	// Keep datagrams under a typical MTU to avoid fragmentation
+	maxGraphiteDatagramSize = 1400
+)
+
+// GraphiteConfig configures a Graphite reporter.
+type GraphiteConfig struct {
+	// Addr is the host:port of Carbon's plaintext, pickle, or UDP receiver.
+	Addr string
+	// Network is either "tcp" (the default) or "udp".
+	Network string
+	// Protocol is the wire format. UDP always uses plaintext.
+	Protocol GraphiteProtocol
+	// Source if not empty is appended to every metric name.
+	Source string
+	// Tags are sent as Graphite 1.1 tagged series (name;tag=value).
+	Tags map[string]string
+	// Fields selects the distribution statistics to send as name.field
+	// unless the distribution specifies its own. If zero only the mean is
+	// sent using the distribution's name.
+	Fields GraphiteFields
+	// Timeout applies to dialing and to each write. Defaults to 10 seconds.
+	Timeout time.Duration
+	// BatchSize is the maximum number of metrics per pickle message.
+	// Defaults to 500.
+	BatchSize int
+}
+
 type graphiteReporter struct {
-	addr   string
-	source string
+	cfg  GraphiteConfig
+	tags string
+	conn persistentConn
+	buf  bytes.Buffer
+	// ends are the offsets in buf at which each line, pickle message, or
+	// datagram ends
+	ends []int
+}
+
+type graphitePoint struct {
+	name  string
+	value float64
 }
 
 func NewGraphiteReporter(registry metrics.Registry, interval time.Duration, latched bool, addr, source string) *PeriodicReporter {
-	gr := &graphiteReporter{
-		addr:   addr,
-		source: source,
+	return NewGraphiteReporterWithConfig(registry, interval, latched, GraphiteConfig{Addr: addr, Source: source})
+}
+
+// NewGraphiteReporterWithConfig returns a periodic reporter that sends metrics
+// to Graphite's Carbon over a persistent connection.
+func NewGraphiteReporterWithConfig(registry metrics.Registry, interval time.Duration, latched bool, cfg GraphiteConfig) *PeriodicReporter {
+	return NewPeriodicReporter(registry, interval, false, latched, newGraphiteReporter(cfg))
+}
+
+// NewGraphiteBackend returns the reporter used by
+// NewGraphiteReporterWithConfig for use with NewPeriodicReporterWithOptions
+// or a MultiReporter.
+func NewGraphiteBackend(cfg GraphiteConfig) Reporter {
+	return newGraphiteReporter(cfg)
+}
+
+func newGraphiteReporter(cfg GraphiteConfig) *graphiteReporter {
+	if cfg.Network == "" {
+		cfg.Network = "tcp"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultGraphiteTimeout
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultGraphiteBatchSize
+	}
+	if cfg.Protocol == GraphitePickle && strings.HasPrefix(cfg.Network, "udp") {
+		log.Printf("graphite: pickle protocol is not supported over UDP, using plaintext")
+		cfg.Protocol = GraphitePlaintext
+	}
+	return &graphiteReporter{
+		cfg:  cfg,
+		tags: graphiteTags(cfg.Tags),
+		conn: persistentConn{network: cfg.Network, addr: cfg.Addr, timeout: cfg.Timeout},
+	}
+}
+
+func graphiteTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteByte(';')
+		b.WriteString(graphiteTagReplacer.Replace(k))
+		b.WriteByte('=')
+		b.WriteString(graphiteTagReplacer.Replace(tags[k]))
+	}
+	return b.String()
+}
+
+// graphiteTagReplacer replaces the characters that separate tags and
+// plaintext fields.
+var graphiteTagReplacer = strings.NewReplacer(";", "_", "=", "_", " ", "_", "\t", "_", "\n", "_")
+
+// tagSuffix returns the tags for a metric in Graphite's tagged series format.
+func (r *graphiteReporter) tagSuffix(tags map[string]string) string {
+	if len(tags) == 0 {
+		return r.tags
 	}
-	return NewPeriodicReporter(registry, interval, false, latched, gr)
+	return graphiteTags(mergeTags(r.cfg.Tags, tags))
 }
 
-func (r *graphiteReporter) sourcedName(name string) string {
-	if r.source != "" {
-		return name + "." + r.source
+func (r *graphiteReporter) metricName(name string) string {
+	name = strings.ReplaceAll(name, "/", ".")
+	if r.cfg.Source != "" {
+		name += "." + r.cfg.Source
 	}
 	return name
 }
 
 func (r *graphiteReporter) Report(snapshot *metrics.RegistrySnapshot) {
-	conn, err := net.Dial("tcp", r.addr)
-	if err != nil {
-		log.Printf("Failed to connect to graphite/carbon: %+v", err)
+	points := r.points(snapshot)
+	if len(points) == 0 {
 		return
 	}
-	defer conn.Close()
+	ts := snapshot.Time.Unix()
+	if snapshot.Time.IsZero() {
+		ts = time.Now().Unix()
+	}
 
-	ts := time.Now().UTC().Unix()
+	r.encode(points, ts)
 
-	for _, v := range snapshot.Values {
-		name := strings.ReplaceAll(v.Name, "/", ".")
-		if _, err := fmt.Fprintf(conn, "%s %f %d\n", r.sourcedName(name), v.Value, ts); err != nil {
-			log.Printf("graphite: failed to post metric %s: %s", name, err.Error())
+	// A connection that Carbon closed while we were idle is usually only
+	// noticed on write, so retry once on a fresh connection. Only messages
+	// that weren't completely written are sent again.
+	sent := 0
+	for attempt := 0; attempt < 2; attempt++ {
+		var err error
+		if sent, err = r.send(sent); err != nil {
+			log.Printf("graphite: failed to send metrics to %s: %s", r.cfg.Addr, err.Error())
+			r.conn.close()
+			continue
 		}
+		return
+	}
+}
+
+func (r *graphiteReporter) points(snapshot *metrics.RegistrySnapshot) []graphitePoint {
+	points := make([]graphitePoint, 0, len(snapshot.Values)+len(snapshot.Distributions))
+	for _, v := range snapshot.Values {
+		points = append(points, graphitePoint{r.metricName(v.Name) + r.tagSuffix(v.Tags), v.Value})
 	}
 	for _, v := range snapshot.Distributions {
-		name := strings.ReplaceAll(v.Name, "/", ".")
-		if _, err := fmt.Fprintf(conn, "%s %f %d\n", r.sourcedName(name), v.Value.Mean(), ts); err != nil {
-			log.Printf("graphite: failed to post metric %s: %s", name, err.Error())
+		tags := r.tagSuffix(v.Tags)
+		fields := r.cfg.Fields
+		if v.Stats != 0 {
+			fields = v.Stats
+		}
+		if fields == 0 {
+			points = append(points, graphitePoint{r.metricName(v.Name) + tags, v.Value.Mean()})
+			continue
+		}
+		fields.Each(v.Value, func(_ metrics.Stats, field string, value float64) {
+			points = append(points, graphitePoint{r.metricName(v.Name+"/"+field) + tags, value})
+		})
+	}
+	return points
+}
+
+// encode writes the points to buf as lines, pickle messages of up to
+// BatchSize points, or datagrams of lines.
+func (r *graphiteReporter) encode(points []graphitePoint, ts int64) {
+	r.buf.Reset()
+	r.ends = r.ends[:0]
+	switch {
+	case r.datagrams():
+		var line bytes.Buffer
+		for _, p := range points {
+			line.Reset()
+			writeGraphiteLine(&line, p, ts)
+			if r.buf.Len() > r.lastEnd() && r.buf.Len()+line.Len()-r.lastEnd() > maxGraphiteDatagramSize {
+				r.ends = append(r.ends, r.buf.Len())
+			}
+			r.buf.Write(line.Bytes())
+		}
+		r.ends = append(r.ends, r.buf.Len())
+	case r.cfg.Protocol == GraphitePickle:
+		for i := 0; i < len(points); i += r.cfg.BatchSize {
+			writeGraphitePickle(&r.buf, points[i:min(i+r.cfg.BatchSize, len(points))], ts)
+			r.ends = append(r.ends, r.buf.Len())
+		}
+	default:
+		for _, p := range points {
+			writeGraphiteLine(&r.buf, p, ts)
+			r.ends = append(r.ends, r.buf.Len())
+		}
+	}
+}
+
+func (r *graphiteReporter) lastEnd() int {
+	if len(r.ends) == 0 {
+		return 0
+	}
+	return r.ends[len(r.ends)-1]
+}
+
+func (r *graphiteReporter) datagrams() bool {
+	return strings.HasPrefix(r.cfg.Network, "udp")
+}
+
+// send writes the encoded messages starting at offset from and returns
+// the offset after the last message that was completely written.
+func (r *graphiteReporter) send(from int) (int, error) {
+	b := r.buf.Bytes()
+	if r.datagrams() {
+		for _, end := range r.ends {
+			if end <= from {
+				continue
+			}
+			if _, err := r.conn.write(b[from:end]); err != nil {
+				return from, err
+			}
+			from = end
+		}
+		return from, nil
+	}
+
+	n, err := r.conn.write(b[from:])
+	if err == nil {
+		return len(b), nil
+	}
+	written := from
+	for _, end := range r.ends {
+		if end > from+n {
+			break
+		}
+		written = end
+	}
+	return written, err
+}
+
+func writeGraphiteLine(b *bytes.Buffer, p graphitePoint, ts int64) {
+	b.WriteString(p.name)
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(p.value, 'f', -1, 64))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(ts, 10))
+	b.WriteByte('\n')
+}
+
+// writeGraphitePickle writes a length prefixed pickle (protocol 2) of a
+// list of (name, (timestamp, value)) tuples as expected by Carbon's
+// pickle receiver.
+func writeGraphitePickle(b *bytes.Buffer, points []graphitePoint, ts int64) {
+	var tmp [8]byte
+	start := b.Len()
+	b.Write([]byte{0, 0, 0, 0}) // length placeholder
+	b.Write([]byte{0x80, 2})    // PROTO 2
+	b.WriteByte(']')            // EMPTY_LIST
+	b.WriteByte('(')            // MARK
+	for _, p := range points {
+		b.WriteByte('X') // BINUNICODE
+		binary.LittleEndian.PutUint32(tmp[:4], uint32(len(p.name)))
+		b.Write(tmp[:4])
+		b.WriteString(p.name)
+		if ts >= math.MinInt32 && ts <= math.MaxInt32 {
+			b.WriteByte('J') // BININT
+			binary.LittleEndian.PutUint32(tmp[:4], uint32(ts))
+			b.Write(tmp[:4])
+		} else {
+			b.Write([]byte{0x8a, 8}) // LONG1
+			binary.LittleEndian.PutUint64(tmp[:], uint64(ts))
+			b.Write(tmp[:])
 		}
+		b.WriteByte('G') // BINFLOAT
+		binary.BigEndian.PutUint64(tmp[:], math.Float64bits(p.value))
+		b.Write(tmp[:])
+		b.WriteByte(0x86) // TUPLE2 (timestamp, value)
+		b.WriteByte(0x86) // TUPLE2 (name, (timestamp, value))
 	}
+	b.WriteByte('e') // APPENDS
+	b.WriteByte('.') // STOP
+	binary.BigEndian.PutUint32(b.Bytes()[start:], uint32(b.Len()-start-4))
 }
@@ -0,0 +1,251 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+func testGraphiteSnapshot() *metrics.RegistrySnapshot {
+	return &metrics.RegistrySnapshot{
+		Values: []metrics.NamedValue{{Name: "a/b", Value: 1.5}},
+		Distributions: []metrics.NamedDistribution{
+			{Name: "d", Value: metrics.DistributionValue{Count: 2, Sum: 10, Min: 4, Max: 6, Variance: 4}},
+		},
+		Time: time.Unix(1700000000, 0),
+	}
+}
+
+func TestGraphitePlaintext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	r := newGraphiteReporter(GraphiteConfig{
+		Addr:   l.Addr().String(),
+		Source: "host1",
+		Tags:   map[string]string{"env": "prod", "dc": "east"},
+		Fields: GraphiteCount | GraphiteMax | GraphiteStdDev,
+	})
+	defer r.conn.close()
+
+	lines := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					lines <- s.Text()
+				}
+			}()
+		}
+	}()
+
+	r.Report(testGraphiteSnapshot())
+	exp := []string{
+		"a.b.host1;dc=east;env=prod 1.5 1700000000",
+		"d.count.host1;dc=east;env=prod 2 1700000000",
+		"d.max.host1;dc=east;env=prod 6 1700000000",
+		"d.stddev.host1;dc=east;env=prod 2 1700000000",
+	}
+	for _, e := range exp {
+		select {
+		case line := <-lines:
+			if line != e {
+				t.Errorf("Expected %q. Got %q", e, line)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Timed out waiting for %q", e)
+		}
+	}
+
+	// The connection should be reused, and re-established if it goes away
+	conn := r.conn.conn
+	r.Report(testGraphiteSnapshot())
+	if r.conn.conn != conn {
+		t.Fatal("Expected connection to be reused")
+	}
+	for range exp {
+		<-lines
+	}
+	conn.Close()
+	r.Report(testGraphiteSnapshot())
+	select {
+	case line := <-lines:
+		if line != exp[0] {
+			t.Errorf("Expected %q after reconnect. Got %q", exp[0], line)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for metrics after reconnect")
+	}
+}
+
+func TestGraphitePickle(t *testing.T) {
+	var b bytes.Buffer
+	writeGraphitePickle(&b, []graphitePoint{{"a.b", 1.5}}, 1700000000)
+	exp := []byte{
+		0x80, 2, ']', '(',
+		'X', 3, 0, 0, 0, 'a', '.', 'b',
+		'J', 0x00, 0xf1, 0x53, 0x65,
+		'G', 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0x86, 0x86, 'e', '.',
+	}
+	out := b.Bytes()
+	if n := binary.BigEndian.Uint32(out); int(n) != len(exp) {
+		t.Fatalf("Expected length prefix of %d. Got %d", len(exp), n)
+	}
+	if !bytes.Equal(out[4:], exp) {
+		t.Fatalf("Expected pickle\n%x\ngot\n%x", exp, out[4:])
+	}
+}
+
+func TestGraphitePickleBatches(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	r := newGraphiteReporter(GraphiteConfig{Addr: l.Addr().String(), Protocol: GraphitePickle, BatchSize: 1})
+	r.Report(testGraphiteSnapshot())
+	r.conn.close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	body, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := 0
+	for len(body) >= 4 {
+		n := binary.BigEndian.Uint32(body)
+		body = body[4+n:]
+		messages++
+	}
+	if messages != 2 {
+		t.Fatalf("Expected 2 pickle messages. Got %d", messages)
+	}
+}
+
+func TestGraphiteUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	r := newGraphiteReporter(GraphiteConfig{Addr: pc.LocalAddr().String(), Network: "udp", Protocol: GraphitePickle})
+	defer r.conn.close()
+	r.Report(testGraphiteSnapshot())
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(time.Second * 5))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "a.b 1.5 1700000000\nd 5 1700000000\n"
+	if s := string(buf[:n]); s != exp {
+		t.Fatalf("Expected datagram %q. Got %q", exp, s)
+	}
+}
+
+func TestGraphiteDistributionStats(t *testing.T) {
+	r := newGraphiteReporter(GraphiteConfig{Fields: GraphiteMean})
+	snapshot := testGraphiteSnapshot()
+	snapshot.Distributions = append(snapshot.Distributions, metrics.NamedDistribution{
+		Name:  "e",
+		Value: metrics.DistributionValue{Count: 1, Sum: 3, Min: 3, Max: 3},
+		Stats: metrics.StatCount | metrics.StatMin,
+	})
+	var names []string
+	for _, p := range r.points(snapshot) {
+		names = append(names, p.name)
+	}
+	exp := []string{"a.b", "d.mean", "e.count", "e.min"}
+	if !reflect.DeepEqual(names, exp) {
+		t.Fatalf("Expected %v. Got %v", exp, names)
+	}
+}
+
+func TestGraphiteMetricTags(t *testing.T) {
+	r := newGraphiteReporter(GraphiteConfig{Tags: map[string]string{"env": "prod", "dc": "east"}})
+	snapshot := testGraphiteSnapshot()
+	snapshot.Values[0].Tags = map[string]string{"env": "test", "service": "db"}
+	exp := "a.b;dc=east;env=test;service=db"
+	if name := r.points(snapshot)[0].name; name != exp {
+		t.Fatalf("Expected %q. Got %q", exp, name)
+	}
+}
+
+func TestGraphiteTagSanitizing(t *testing.T) {
+	r := newGraphiteReporter(GraphiteConfig{})
+	snapshot := testGraphiteSnapshot()
+	snapshot.Values[0].Tags = map[string]string{"a=b": "c;d e"}
+	exp := "a.b;a_b=c_d_e"
+	if name := r.points(snapshot)[0].name; name != exp {
+		t.Fatalf("Expected %q. Got %q", exp, name)
+	}
+}
+
+// partialConn accepts n bytes and then fails.
+type partialConn struct {
+	net.Conn
+	n int
+}
+
+func (c *partialConn) Write(b []byte) (int, error) {
+	return min(c.n, len(b)), io.ErrClosedPipe
+}
+
+func (c *partialConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *partialConn) Close() error                     { return nil }
+
+func TestGraphitePartialWrite(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	r := newGraphiteReporter(GraphiteConfig{Addr: l.Addr().String()})
+	// The first line and part of the second are written before failing
+	first := "a.b 1.5 1700000000\n"
+	r.conn.conn = &partialConn{n: len(first) + 3}
+	r.Report(testGraphiteSnapshot())
+	r.conn.close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	body, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "d 5 1700000000\n"; string(body) != exp {
+		t.Fatalf("Expected only the unwritten line %q to be resent. Got %q", exp, body)
+	}
+}
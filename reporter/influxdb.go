@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -12,41 +13,79 @@ import (
 )
 
 type influxDBReporter struct {
-	writeURL string
-	tags     string
+	writeURL  string
+	tags      map[string]string
+	tagString string
 }
 
 // NewInfluxDBReporter returns a new period reporter that sends metrics to InfluxDB.
 // BaseURL should be of the form http://localhost:8086
 func NewInfluxDBReporter(registry metrics.Registry, interval time.Duration, latched bool, baseURL, dbName string, tags map[string]string) *PeriodicReporter {
+	return NewPeriodicReporter(registry, interval, true, latched, newInfluxDBReporter(baseURL, dbName, tags))
+}
+
+// NewInfluxDBBackend returns the reporter used by NewInfluxDBReporter for
+// use with NewPeriodicReporterWithOptions or a MultiReporter.
+func NewInfluxDBBackend(baseURL, dbName string, tags map[string]string) Reporter {
+	return newInfluxDBReporter(baseURL, dbName, tags)
+}
+
+func newInfluxDBReporter(baseURL, dbName string, tags map[string]string) *influxDBReporter {
 	if len(baseURL) == 0 {
 		baseURL = "http://localhost:8086"
 	} else if baseURL[len(baseURL)-1] == '/' {
 		baseURL = baseURL[:len(baseURL)-1]
 	}
-	var tagStr strings.Builder
-	if len(tags) != 0 {
-		for k, v := range tags {
-			tagStr.WriteString(fmt.Sprintf(",%s=%s", k, v))
-		}
+	return &influxDBReporter{
+		writeURL:  fmt.Sprintf("%s/write?db=%s", baseURL, dbName),
+		tags:      tags,
+		tagString: influxDBTags(tags),
+	}
+}
+
+// influxDBTags formats tags for the line protocol sorted by key as
+// InfluxDB recommends.
+func influxDBTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString("," + k + "=" + tags[k])
 	}
-	lr := &influxDBReporter{
-		writeURL: fmt.Sprintf("%s/write?db=%s", baseURL, dbName),
-		tags:     tagStr.String(),
+	return b.String()
+}
+
+func (r *influxDBReporter) tagsFor(tags map[string]string) string {
+	if len(tags) == 0 {
+		return r.tagString
 	}
-	return NewPeriodicReporter(registry, interval, true, latched, lr)
+	return influxDBTags(mergeTags(r.tags, tags))
 }
 
 func (r *influxDBReporter) Report(snapshot *metrics.RegistrySnapshot) {
+	ts := " " + strconv.FormatInt(snapshot.Time.UnixNano(), 10)
 	var measurements []string
 	for _, v := range snapshot.Values {
 		name := strings.ReplaceAll(v.Name, "/", ".")
-		measurements = append(measurements, name+r.tags+" value="+strconv.FormatFloat(v.Value, 'f', -1, 64))
+		measurements = append(measurements, name+r.tagsFor(v.Tags)+" value="+strconv.FormatFloat(v.Value, 'f', -1, 64)+ts)
 	}
 	for _, v := range snapshot.Distributions {
-		name := strings.ReplaceAll(v.Name, "/", ".")
-		if v.Value.Count != 0 {
-			measurements = append(measurements, name+r.tags+fmt.Sprintf(" count=%di,sum=%f,min=%f,max=%f,variance=%f", v.Value.Count, v.Value.Sum, v.Value.Min, v.Value.Max, v.Value.Variance))
+		name := strings.ReplaceAll(v.Name, "/", ".") + r.tagsFor(v.Tags)
+		if v.Stats != 0 {
+			var fields []string
+			v.Stats.Each(v.Value, func(stat metrics.Stats, field string, value float64) {
+				if stat == metrics.StatCount {
+					fields = append(fields, fmt.Sprintf("count=%di", v.Value.Count))
+				} else {
+					fields = append(fields, field+"="+strconv.FormatFloat(value, 'f', -1, 64))
+				}
+			})
+			measurements = append(measurements, name+" "+strings.Join(fields, ",")+ts)
+		} else if v.Value.Count != 0 {
+			measurements = append(measurements, name+fmt.Sprintf(" count=%di,sum=%f,min=%f,max=%f,variance=%f", v.Value.Count, v.Value.Sum, v.Value.Min, v.Value.Max, v.Value.Variance)+ts)
 		}
 	}
 	body := strings.Join(measurements, "\n")
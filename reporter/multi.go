@@ -0,0 +1,176 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+const defaultBackendQueueSize = 4
+
+// Backend is one of the destinations of a MultiReporter.
+type Backend struct {
+	// Name identifies the backend in log messages.
+	Name     string
+	Reporter Reporter
+	// QueueSize is the number of snapshots that may be waiting to be
+	// reported. When the queue is full the oldest snapshot is dropped.
+	// Defaults to 4.
+	QueueSize int
+	// Timeout bounds how long a single report may take. The context given
+	// to a ContextReporter is cancelled. A plain Reporter that takes too
+	// long is abandoned and, since reporters aren't safe for concurrent
+	// use, snapshots are dropped until it returns.
+	Timeout time.Duration
+}
+
+// MultiReporter delivers each snapshot to several backends. Each backend
+// has its own queue and goroutine so a slow backend can't delay the others.
+type MultiReporter struct {
+	backends []*backendWorker
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	closed   bool
+}
+
+type backendWorker struct {
+	Backend
+	queue   chan *metrics.RegistrySnapshot
+	dropped uint64
+	// abandoned is closed when a report that timed out returns
+	abandoned chan struct{}
+}
+
+// NewMultiReporter returns a reporter that fans out every snapshot to the
+// given backends. Use it with a single PeriodicReporter so that all
+// backends share one snapshot of the registry. Latched counters and
+// histograms are otherwise reset by whichever reporter happens to run first.
+// All backends see the snapshot's Temporality unless their reporter is
+// wrapped by WithTemporality.
+func NewMultiReporter(backends ...Backend) *MultiReporter {
+	r := &MultiReporter{}
+	for i, b := range backends {
+		if b.Name == "" {
+			b.Name = fmt.Sprintf("%T#%d", b.Reporter, i)
+		}
+		if b.QueueSize <= 0 {
+			b.QueueSize = defaultBackendQueueSize
+		}
+		w := &backendWorker{
+			Backend: b,
+			queue:   make(chan *metrics.RegistrySnapshot, b.QueueSize),
+		}
+		r.backends = append(r.backends, w)
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			w.loop()
+		}()
+	}
+	return r
+}
+
+// Report queues a copy of the snapshot for every backend without waiting
+// for any of them. Snapshots reported after Close are dropped.
+func (r *MultiReporter) Report(snapshot *metrics.RegistrySnapshot) {
+	// The snapshot is reused by the PeriodicReporter for the next interval
+	snap := snapshot.Clone()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	for _, w := range r.backends {
+		w.enqueue(snap)
+	}
+}
+
+// Close stops accepting snapshots and waits for the backends to finish
+// reporting those that are already queued, except for reports that have
+// been abandoned after timing out. It may be called more than once.
+func (r *MultiReporter) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		for _, w := range r.backends {
+			close(w.queue)
+		}
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+func (w *backendWorker) enqueue(snapshot *metrics.RegistrySnapshot) {
+	for {
+		select {
+		case w.queue <- snapshot:
+			return
+		default:
+		}
+		// Drop the oldest snapshot to make room
+		select {
+		case <-w.queue:
+			w.dropped++
+			log.Printf("metrics/reporter: backend %s is falling behind, dropped snapshot (%d total)", w.Name, w.dropped)
+		default:
+		}
+	}
+}
+
+func (w *backendWorker) loop() {
+	for snapshot := range w.queue {
+		w.report(snapshot)
+	}
+}
+
+func (w *backendWorker) report(snapshot *metrics.RegistrySnapshot) {
+	if w.abandoned != nil {
+		select {
+		case <-w.abandoned:
+			w.abandoned = nil
+		default:
+			w.dropped++
+			log.Printf("metrics/reporter: backend %s is still running a report that timed out, dropped snapshot (%d total)", w.Name, w.dropped)
+			return
+		}
+	}
+
+	if cr, ok := w.Reporter.(ContextReporter); ok {
+		ctx := context.Background()
+		if w.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+			defer cancel()
+		}
+		if err := cr.ReportContext(ctx, snapshot); err != nil {
+			log.Printf("metrics/reporter: backend %s failed to report: %s", w.Name, err.Error())
+		}
+		return
+	}
+
+	if w.Timeout <= 0 {
+		w.Reporter.Report(snapshot)
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Reporter.Report(snapshot)
+	}()
+	timer := time.NewTimer(w.Timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Printf("metrics/reporter: backend %s timed out after %s, abandoning report", w.Name, w.Timeout)
+		w.abandoned = done
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+type chanReporter struct {
+	ch      chan *metrics.RegistrySnapshot
+	started chan struct{}
+	block   chan struct{}
+}
+
+func (r *chanReporter) Report(snapshot *metrics.RegistrySnapshot) {
+	if r.block != nil {
+		r.started <- struct{}{}
+		<-r.block
+	}
+	r.ch <- snapshot
+}
+
+type ctxReporter struct {
+	err chan error
+}
+
+func (r *ctxReporter) Report(snapshot *metrics.RegistrySnapshot) {}
+
+func (r *ctxReporter) ReportContext(ctx context.Context, snapshot *metrics.RegistrySnapshot) error {
+	<-ctx.Done()
+	r.err <- ctx.Err()
+	return ctx.Err()
+}
+
+func TestMultiReporter(t *testing.T) {
+	reg := metrics.NewRegistry()
+	counter := metrics.NewCounter()
+	reg.Add("counter", counter)
+
+	fast := &chanReporter{ch: make(chan *metrics.RegistrySnapshot, 10)}
+	slow := &chanReporter{ch: make(chan *metrics.RegistrySnapshot, 10), started: make(chan struct{}, 10), block: make(chan struct{})}
+	mr := NewMultiReporter(
+		Backend{Name: "slow", Reporter: slow, QueueSize: 1},
+		Backend{Name: "fast", Reporter: fast},
+	)
+
+	snap := metrics.NewRegistrySnapshot(true)
+	for i := 1; i <= 3; i++ {
+		counter.Inc(uint64(i))
+		snap.Snapshot(reg)
+		mr.Report(snap)
+		if i == 1 {
+			<-slow.started
+		}
+	}
+
+	// The fast backend sees every interval even though the slow one is stuck
+	for i := 1; i <= 3; i++ {
+		select {
+		case s := <-fast.ch:
+			if v := s.Values[0].Value; v != float64(i) {
+				t.Fatalf("Expected latched counter value %d. Got %f", i, v)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timed out waiting for fast backend")
+		}
+	}
+
+	close(slow.block)
+	mr.Close()
+	close(slow.ch)
+	var got []float64
+	for s := range slow.ch {
+		got = append(got, s.Values[0].Value)
+	}
+	// The first snapshot was being reported, the second was dropped from
+	// the full queue in favour of the third.
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Fatalf("Expected slow backend to report [1 3]. Got %v", got)
+	}
+}
+
+func TestMultiReporterTimeout(t *testing.T) {
+	r := &ctxReporter{err: make(chan error, 1)}
+	mr := NewMultiReporter(Backend{Reporter: r, Timeout: time.Millisecond * 10})
+	defer mr.Close()
+	mr.Report(metrics.NewRegistrySnapshot(false))
+	select {
+	case err := <-r.err:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("Expected DeadlineExceeded. Got %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for report to be cancelled")
+	}
+}
+
+func TestMultiReporterTimeoutPlainReporter(t *testing.T) {
+	r := &chanReporter{ch: make(chan *metrics.RegistrySnapshot, 10), started: make(chan struct{}, 10), block: make(chan struct{})}
+	mr := NewMultiReporter(Backend{Reporter: r, Timeout: time.Millisecond * 10})
+
+	mr.Report(metrics.NewRegistrySnapshot(false))
+	<-r.started
+	// The stuck report is abandoned so Close doesn't wait for it, and the
+	// snapshots that arrive while it's running are dropped
+	mr.Report(metrics.NewRegistrySnapshot(false))
+	mr.Close()
+	select {
+	case <-r.started:
+		t.Fatal("Expected no report to start while the abandoned one is running")
+	default:
+	}
+
+	close(r.block)
+	select {
+	case <-r.ch:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for the abandoned report to finish")
+	}
+}
+
+func TestMultiReporterClose(t *testing.T) {
+	r := &chanReporter{ch: make(chan *metrics.RegistrySnapshot, 10)}
+	mr := NewMultiReporter(Backend{Reporter: r})
+	mr.Report(metrics.NewRegistrySnapshot(false))
+	mr.Close()
+	mr.Close()
+	mr.Report(metrics.NewRegistrySnapshot(false))
+	if n := len(r.ch); n != 1 {
+		t.Fatalf("Expected 1 report before close. Got %d", n)
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+// DefaultOpenTSDBMaxTags is the default maximum number of tags per data
+// point. It matches OpenTSDB's default tsd.storage.max_tags.
+const DefaultOpenTSDBMaxTags = 8
+
+// Statistics reported for distributions that don't specify their own
+const openTSDBDistributionStats = metrics.StatCount | metrics.StatSum | metrics.StatMin | metrics.StatMax | metrics.StatMean
+
+const (
+	defaultOpenTSDBTimeout   = time.Second * 15
+	defaultOpenTSDBBatchSize = 50
+)
+
+type openTSDBPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+type kairosDBPoint struct {
+	Name      string            `json:"name"`
+	Timestamp int64             `json:"timestamp"`
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// OpenTSDBConfig configures an OpenTSDB or KairosDB reporter.
+type OpenTSDBConfig struct {
+	// Addr is the host:port of OpenTSDB's telnet interface, such as
+	// localhost:4242, or the base URL of the HTTP API, such as
+	// http://localhost:4242.
+	Addr string
+	// Tags are added to every data point. OpenTSDB requires at least one
+	// tag so if there are none the host tag is set to the hostname.
+	Tags map[string]string
+	// MaxTags is the maximum number of tags per data point. Tags past the
+	// limit are dropped. Defaults to DefaultOpenTSDBMaxTags.
+	MaxTags int
+}
+
+// openTSDBTagger sanitizes tags and enforces the tag limit, logging each
+// kind of dropped tag once.
+type openTSDBTagger struct {
+	maxTags int
+	warned  sync.Map
+}
+
+type openTSDBTelnetReporter struct {
+	tagger *openTSDBTagger
+	tags   map[string]string
+	conn   persistentConn
+}
+
+type openTSDBHTTPReporter struct {
+	url       string
+	tagger    *openTSDBTagger
+	tags      map[string]string
+	kairos    bool
+	batchSize int
+	client    *http.Client
+}
+
+// NewOpenTSDBTelnetReporter returns a periodic reporter that sends metrics to
+// OpenTSDB using the telnet style put protocol. Addr should be of the form
+// localhost:4242. OpenTSDB requires at least one tag so if tags is empty the
+// host tag is set to the hostname.
+func NewOpenTSDBTelnetReporter(registry metrics.Registry, interval time.Duration, latched bool, addr string, tags map[string]string) *PeriodicReporter {
+	return NewOpenTSDBTelnetReporterWithConfig(registry, interval, latched, OpenTSDBConfig{Addr: addr, Tags: tags})
+}
+
+// NewOpenTSDBTelnetReporterWithConfig is like NewOpenTSDBTelnetReporter
+// but takes a config.
+func NewOpenTSDBTelnetReporterWithConfig(registry metrics.Registry, interval time.Duration, latched bool, cfg OpenTSDBConfig) *PeriodicReporter {
+	return NewPeriodicReporter(registry, interval, true, latched, newOpenTSDBTelnetReporter(cfg))
+}
+
+// NewOpenTSDBHTTPReporter returns a periodic reporter that sends metrics to
+// OpenTSDB's /api/put HTTP API. BaseURL should be of the form http://localhost:4242
+func NewOpenTSDBHTTPReporter(registry metrics.Registry, interval time.Duration, latched bool, baseURL string, tags map[string]string) *PeriodicReporter {
+	return NewOpenTSDBHTTPReporterWithConfig(registry, interval, latched, OpenTSDBConfig{Addr: baseURL, Tags: tags})
+}
+
+// NewOpenTSDBHTTPReporterWithConfig is like NewOpenTSDBHTTPReporter but
+// takes a config.
+func NewOpenTSDBHTTPReporterWithConfig(registry metrics.Registry, interval time.Duration, latched bool, cfg OpenTSDBConfig) *PeriodicReporter {
+	return NewPeriodicReporter(registry, interval, true, latched, newOpenTSDBHTTPReporter(cfg, false))
+}
+
+// NewKairosDBReporter returns a periodic reporter that sends metrics to
+// KairosDB's /api/v1/datapoints HTTP API. BaseURL should be of the form
+// http://localhost:8080
+func NewKairosDBReporter(registry metrics.Registry, interval time.Duration, latched bool, baseURL string, tags map[string]string) *PeriodicReporter {
+	return NewKairosDBReporterWithConfig(registry, interval, latched, OpenTSDBConfig{Addr: baseURL, Tags: tags})
+}
+
+// NewKairosDBReporterWithConfig is like NewKairosDBReporter but takes a
+// config.
+func NewKairosDBReporterWithConfig(registry metrics.Registry, interval time.Duration, latched bool, cfg OpenTSDBConfig) *PeriodicReporter {
+	return NewPeriodicReporter(registry, interval, true, latched, newOpenTSDBHTTPReporter(cfg, true))
+}
+
+// NewOpenTSDBTelnetBackend returns the reporter used by
+// NewOpenTSDBTelnetReporterWithConfig for use with
+// NewPeriodicReporterWithOptions or a MultiReporter.
+func NewOpenTSDBTelnetBackend(cfg OpenTSDBConfig) Reporter {
+	return newOpenTSDBTelnetReporter(cfg)
+}
+
+// NewOpenTSDBHTTPBackend returns the reporter used by
+// NewOpenTSDBHTTPReporterWithConfig for use with
+// NewPeriodicReporterWithOptions or a MultiReporter.
+func NewOpenTSDBHTTPBackend(cfg OpenTSDBConfig) Reporter {
+	return newOpenTSDBHTTPReporter(cfg, false)
+}
+
+// NewKairosDBBackend returns the reporter used by
+// NewKairosDBReporterWithConfig for use with NewPeriodicReporterWithOptions
+// or a MultiReporter.
+func NewKairosDBBackend(cfg OpenTSDBConfig) Reporter {
+	return newOpenTSDBHTTPReporter(cfg, true)
+}
+
+func newOpenTSDBTagger(maxTags int) *openTSDBTagger {
+	if maxTags <= 0 {
+		maxTags = DefaultOpenTSDBMaxTags
+	}
+	return &openTSDBTagger{maxTags: maxTags}
+}
+
+func newOpenTSDBTelnetReporter(cfg OpenTSDBConfig) *openTSDBTelnetReporter {
+	tagger := newOpenTSDBTagger(cfg.MaxTags)
+	return &openTSDBTelnetReporter{
+		tagger: tagger,
+		tags:   tagger.tags(cfg.Tags),
+		conn:   persistentConn{network: "tcp", addr: cfg.Addr, timeout: defaultOpenTSDBTimeout},
+	}
+}
+
+func newOpenTSDBHTTPReporter(cfg OpenTSDBConfig, kairos bool) *openTSDBHTTPReporter {
+	baseURL := strings.TrimRight(cfg.Addr, "/")
+	if kairos {
+		baseURL += "/api/v1/datapoints"
+	} else {
+		baseURL += "/api/put"
+	}
+	tagger := newOpenTSDBTagger(cfg.MaxTags)
+	return &openTSDBHTTPReporter{
+		url:       baseURL,
+		tagger:    tagger,
+		tags:      tagger.tags(cfg.Tags),
+		kairos:    kairos,
+		batchSize: defaultOpenTSDBBatchSize,
+		client:    &http.Client{Timeout: defaultOpenTSDBTimeout},
+	}
+}
+
+// openTSDBName replaces any characters not allowed by OpenTSDB in metric
+// names and tags with an underscore. Slashes are also replaced with dots
+// to match the naming used by the other reporters.
+func openTSDBName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '/':
+			return '.'
+		case r == '-' || r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// tags sanitizes tags and enforces the tag count limit. Tags whose names
+// are the same once sanitized are dropped. OpenTSDB rejects data points
+// without tags so the host tag is added if needed.
+func (t *openTSDBTagger) tags(tags map[string]string) map[string]string {
+	out := make(map[string]string, len(tags))
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := openTSDBName(k)
+		if _, ok := out[name]; ok {
+			t.warn("dropping tag %s which is the same as another once sanitized", k)
+			continue
+		}
+		if len(out) == t.maxTags {
+			t.warn("dropping tag %s, limit of %d tags reached", k, t.maxTags)
+			continue
+		}
+		out[name] = openTSDBName(tags[k])
+	}
+	if len(out) == 0 {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "unknown"
+		}
+		out["host"] = openTSDBName(host)
+	}
+	return out
+}
+
+// warn logs a message the first time it's seen since the same tags are
+// usually seen every report.
+func (t *openTSDBTagger) warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if _, loaded := t.warned.LoadOrStore(msg, struct{}{}); !loaded {
+		log.Print("metrics/reporter/opentsdb: " + msg)
+	}
+}
+
+func openTSDBPoints(snapshot *metrics.RegistrySnapshot, tagger *openTSDBTagger, tags map[string]string) []openTSDBPoint {
+	ts := snapshot.Time
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	points := make([]openTSDBPoint, 0, len(snapshot.Values)+len(snapshot.Distributions)*5)
+	add := func(name string, tags map[string]string, value float64) {
+		// OpenTSDB rejects NaN and infinite values
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return
+		}
+		points = append(points, openTSDBPoint{
+			Metric:    openTSDBName(name),
+			Timestamp: ts.Unix(),
+			Value:     value,
+			Tags:      tags,
+		})
+	}
+	tagsFor := func(metricTags map[string]string) map[string]string {
+		if len(metricTags) == 0 {
+			return tags
+		}
+		return tagger.tags(mergeTags(tags, metricTags))
+	}
+	for _, v := range snapshot.Values {
+		add(v.Name, tagsFor(v.Tags), v.Value)
+	}
+	for _, v := range snapshot.Distributions {
+		tags := tagsFor(v.Tags)
+		stats := openTSDBDistributionStats
+		if v.Stats != 0 {
+			stats = v.Stats
+		}
+		stats.Each(v.Value, func(_ metrics.Stats, name string, value float64) {
+			add(v.Name+"/"+name, tags, value)
+		})
+	}
+	return points
+}
+
+func (r *openTSDBTelnetReporter) Report(snapshot *metrics.RegistrySnapshot) {
+	points := openTSDBPoints(snapshot, r.tagger, r.tags)
+	if len(points) == 0 {
+		return
+	}
+	var b bytes.Buffer
+	for _, p := range points {
+		tags := make([]string, 0, len(p.Tags))
+		for k, v := range p.Tags {
+			tags = append(tags, k+"="+v)
+		}
+		sort.Strings(tags)
+		b.WriteString("put ")
+		b.WriteString(p.Metric)
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatInt(p.Timestamp, 10))
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatFloat(p.Value, 'f', -1, 64))
+		b.WriteByte(' ')
+		b.WriteString(strings.Join(tags, " "))
+		b.WriteByte('\n')
+	}
+	if err := r.conn.writeRetry(b.Bytes()); err != nil {
+		log.Printf("metrics/reporter/opentsdb: failed to send metrics to %s: %s", r.conn.addr, err.Error())
+	}
+}
+
+func (r *openTSDBHTTPReporter) Report(snapshot *metrics.RegistrySnapshot) {
+	if err := r.ReportContext(context.Background(), snapshot); err != nil {
+		log.Printf("metrics/reporter/opentsdb: failed to send metrics to %s: %s", r.url, err.Error())
+	}
+}
+
+func (r *openTSDBHTTPReporter) ReportContext(ctx context.Context, snapshot *metrics.RegistrySnapshot) error {
+	var errs []error
+	points := openTSDBPoints(snapshot, r.tagger, r.tags)
+	for i := 0; i < len(points); i += r.batchSize {
+		if err := r.post(ctx, points[i:min(i+r.batchSize, len(points))]); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *openTSDBHTTPReporter) post(ctx context.Context, points []openTSDBPoint) error {
+	var body []byte
+	var err error
+	if r.kairos {
+		kpoints := make([]kairosDBPoint, len(points))
+		for i, p := range points {
+			// KairosDB timestamps are in milliseconds
+			kpoints[i] = kairosDBPoint{Name: p.Metric, Timestamp: p.Timestamp * 1000, Value: p.Value, Tags: p.Tags}
+		}
+		body, err = json.Marshal(kpoints)
+	} else {
+		body, err = json.Marshal(points)
+	}
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+		return fmt.Errorf("%d %s", res.StatusCode, strings.TrimSpace(string(b)))
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+func testOpenTSDBSnapshot() *metrics.RegistrySnapshot {
+	return &metrics.RegistrySnapshot{
+		Values: []metrics.NamedValue{
+			{Name: "db/queries per sec", Value: 1.5},
+			{Name: "bad", Value: math.NaN()},
+		},
+		Distributions: []metrics.NamedDistribution{
+			{Name: "latency", Value: metrics.DistributionValue{Count: 2, Sum: 10, Min: 4, Max: 6}},
+		},
+		Time: time.Unix(1700000000, 0),
+	}
+}
+
+func TestOpenTSDBName(t *testing.T) {
+	if n := openTSDBName("a/b c:d-é_1"); n != "a.b_c_d-é_1" {
+		t.Fatalf("Expected a.b_c_d-é_1. Got %s", n)
+	}
+}
+
+func TestOpenTSDBTags(t *testing.T) {
+	tagger := newOpenTSDBTagger(0)
+	tags := make(map[string]string)
+	for i := range DefaultOpenTSDBMaxTags + 2 {
+		tags[fmt.Sprintf("k%02d", i)] = "v v"
+	}
+	out := tagger.tags(tags)
+	if len(out) != DefaultOpenTSDBMaxTags {
+		t.Fatalf("Expected %d tags. Got %d", DefaultOpenTSDBMaxTags, len(out))
+	}
+	if out["k00"] != "v_v" {
+		t.Fatalf("Expected sanitized tag value v_v. Got %q", out["k00"])
+	}
+	if _, ok := out[fmt.Sprintf("k%02d", DefaultOpenTSDBMaxTags)]; ok {
+		t.Fatal("Expected tags past the limit to be dropped")
+	}
+	if out := tagger.tags(nil); out["host"] == "" {
+		t.Fatal("Expected host tag to be added when there are no tags")
+	}
+
+	out = newOpenTSDBTagger(1).tags(map[string]string{"a": "1", "b": "2"})
+	if len(out) != 1 || out["a"] != "1" {
+		t.Fatalf("Expected only tag a with a limit of 1. Got %v", out)
+	}
+	out = tagger.tags(map[string]string{"a b": "1", "a:b": "2", "a_b": "3"})
+	if len(out) != 1 || out["a_b"] != "1" {
+		t.Fatalf("Expected the first of the colliding tags to be kept. Got %v", out)
+	}
+}
+
+func TestOpenTSDBTelnetReporter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	r := newOpenTSDBTelnetReporter(OpenTSDBConfig{Addr: l.Addr().String(), Tags: map[string]string{"host": "web1", "dc": "east"}})
+	defer r.conn.close()
+	r.Report(testOpenTSDBSnapshot())
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := bufio.NewScanner(conn)
+	for _, e := range []string{
+		"put db.queries_per_sec 1700000000 1.5 dc=east host=web1",
+		"put latency.count 1700000000 2 dc=east host=web1",
+		"put latency.sum 1700000000 10 dc=east host=web1",
+		"put latency.min 1700000000 4 dc=east host=web1",
+		"put latency.max 1700000000 6 dc=east host=web1",
+		"put latency.mean 1700000000 5 dc=east host=web1",
+	} {
+		if !s.Scan() {
+			t.Fatalf("Expected %q. Got %v", e, s.Err())
+		}
+		if s.Text() != e {
+			t.Fatalf("Expected %q. Got %q", e, s.Text())
+		}
+	}
+}
+
+func TestOpenTSDBHTTPReporter(t *testing.T) {
+	for _, kairos := range []bool{false, true} {
+		var body []map[string]any
+		var path string
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		r := newOpenTSDBHTTPReporter(OpenTSDBConfig{Addr: s.URL, Tags: map[string]string{"host": "web1"}}, kairos)
+		r.Report(testOpenTSDBSnapshot())
+		s.Close()
+
+		if len(body) != 6 {
+			t.Fatalf("Expected 6 data points. Got %d", len(body))
+		}
+		exp := map[string]any{
+			"metric":    "db.queries_per_sec",
+			"timestamp": float64(1700000000),
+			"value":     1.5,
+			"tags":      map[string]any{"host": "web1"},
+		}
+		expPath := "/api/put"
+		if kairos {
+			exp["name"] = exp["metric"]
+			exp["timestamp"] = float64(1700000000000)
+			delete(exp, "metric")
+			expPath = "/api/v1/datapoints"
+		}
+		if path != expPath {
+			t.Errorf("Expected path %s. Got %s", expPath, path)
+		}
+		if !reflect.DeepEqual(body[0], exp) {
+			t.Errorf("Expected %+v. Got %+v", exp, body[0])
+		}
+	}
+}
@@ -5,6 +5,7 @@
 package reporter
 
 import (
+	"context"
 	"time"
 
 	"github.com/samuel/go-metrics/metrics"
@@ -24,13 +25,28 @@ type Reporter interface {
 	Report(snapshot *metrics.RegistrySnapshot)
 }
 
+// ContextReporter is implemented by reporters that can abandon a report
+// when the context is cancelled, such as those that make HTTP requests.
+type ContextReporter interface {
+	Reporter
+	ReportContext(ctx context.Context, snapshot *metrics.RegistrySnapshot) error
+}
+
 func NewPeriodicReporter(registry metrics.Registry, interval time.Duration, alignInterval, latched bool, reporter Reporter) *PeriodicReporter {
+	return NewPeriodicReporterWithOptions(registry, interval, alignInterval, metrics.SnapshotOptions{ResetOnSnapshot: latched}, reporter)
+}
+
+// NewPeriodicReporterWithOptions returns a periodic reporter that takes
+// snapshots of the registry using the given options, such as the
+// Temporality expected by the reporter's backend or CounterRates. The
+// built-in backends are returned by functions such as NewGraphiteBackend.
+func NewPeriodicReporterWithOptions(registry metrics.Registry, interval time.Duration, alignInterval bool, options metrics.SnapshotOptions, reporter Reporter) *PeriodicReporter {
 	return &PeriodicReporter{
 		registry:      registry,
 		interval:      interval,
 		alignInterval: alignInterval,
 		reporter:      reporter,
-		snapshot:      metrics.NewRegistrySnapshot(latched),
+		snapshot:      metrics.NewRegistrySnapshotWithOptions(options),
 	}
 }
 
@@ -69,6 +85,7 @@ func (r *PeriodicReporter) loop(ch <-chan time.Time) {
 		select {
 		case <-ch:
 		case <-r.closeChan:
+			r.snapshot.Release()
 			return
 		}
 		r.snapshot.Snapshot(r.registry)
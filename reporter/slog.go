@@ -0,0 +1,116 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+// SlogMessage is the message of the records logged by the slog reporter.
+const SlogMessage = "metric"
+
+type slogReporter struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+// NewSlogReporter returns a reporter that logs one record per metric at
+// the info level with the time of the snapshot. Every record has the
+// attributes:
+//
+//	name          the metric's name
+//	kind          gauge, counter, rate, or distribution
+//	unit          the metric's unit if it has one
+//	tags          a group of the metric's tags if it has any
+//
+// Values also have a value attribute, and distributions have one for each
+// of the statistics that are reported for them (count, sum, min, max,
+// mean, and stddev by default). Counts are integers and everything else is
+// a float.
+func NewSlogReporter(registry metrics.Registry, interval time.Duration, latched bool, logger *slog.Logger) *PeriodicReporter {
+	return NewPeriodicReporter(registry, interval, false, latched, newSlogReporter(logger, slog.LevelInfo))
+}
+
+// NewSlogBackend returns the reporter used by NewSlogReporter for use with
+// NewPeriodicReporterWithOptions or a MultiReporter.
+func NewSlogBackend(logger *slog.Logger) Reporter {
+	return newSlogReporter(logger, slog.LevelInfo)
+}
+
+func newSlogReporter(logger *slog.Logger, level slog.Level) *slogReporter {
+	return &slogReporter{logger: logger, level: level}
+}
+
+func (r *slogReporter) Report(snapshot *metrics.RegistrySnapshot) {
+	r.ReportContext(context.Background(), snapshot)
+}
+
+func (r *slogReporter) ReportContext(ctx context.Context, snapshot *metrics.RegistrySnapshot) error {
+	h := r.logger.Handler()
+	if !h.Enabled(ctx, r.level) {
+		return nil
+	}
+	for _, v := range snapshot.Values {
+		rec := r.record(snapshot.Time, v.Name, v.Kind.String(), v.Unit, v.Tags)
+		rec.AddAttrs(slog.Float64("value", v.Value))
+		if err := r.handle(ctx, h, v.Name, rec); err != nil {
+			return err
+		}
+	}
+	for _, d := range snapshot.Distributions {
+		rec := r.record(snapshot.Time, d.Name, "distribution", d.Unit, d.Tags)
+		stats := d.Stats
+		if stats == 0 {
+			stats = metrics.StatAll
+		}
+		stats.Each(d.Value, func(stat metrics.Stats, name string, value float64) {
+			if stat == metrics.StatCount {
+				rec.AddAttrs(slog.Uint64(name, d.Value.Count))
+			} else {
+				rec.AddAttrs(slog.Float64(name, value))
+			}
+		})
+		if err := r.handle(ctx, h, d.Name, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *slogReporter) record(t time.Time, name, kind, unit string, tags map[string]string) slog.Record {
+	rec := slog.NewRecord(t, r.level, SlogMessage, 0)
+	rec.AddAttrs(slog.String("name", name), slog.String("kind", kind))
+	if unit != "" {
+		rec.AddAttrs(slog.String("unit", unit))
+	}
+	if len(tags) != 0 {
+		attrs := make([]any, 0, len(tags))
+		for _, k := range slices.Sorted(maps.Keys(tags)) {
+			attrs = append(attrs, slog.String(k, tags[k]))
+		}
+		rec.AddAttrs(slog.Group("tags", attrs...))
+	}
+	return rec
+}
+
+// handle passes a record to the handler, stopping early if the context is
+// done. Errors from the handler are logged since a failure to write one
+// record doesn't affect the others.
+func (r *slogReporter) handle(ctx context.Context, h slog.Handler, name string, rec slog.Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := h.Handle(ctx, rec); err != nil {
+		log.Printf("metrics/reporter/slog: failed to log %s: %s", name, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+func TestSlogReporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	r := newSlogReporter(slog.New(slog.NewJSONHandler(buf, nil)), slog.LevelInfo)
+	r.Report(&metrics.RegistrySnapshot{
+		Values: []metrics.NamedValue{
+			{Name: "requests", Value: 3, Kind: metrics.KindCounter, Tags: map[string]string{"route": "/", "env": "prod"}},
+		},
+		Distributions: []metrics.NamedDistribution{
+			{Name: "latency", Unit: metrics.UnitMicroseconds, Stats: metrics.StatCount | metrics.StatMax,
+				Value: metrics.DistributionValue{Count: 2, Sum: 10, Min: 4, Max: 6}},
+		},
+		Time: time.Unix(1700000000, 0).UTC(),
+	})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := []map[string]any{
+		{
+			"time": "2023-11-14T22:13:20Z", "level": "INFO", "msg": "metric",
+			"name": "requests", "kind": "counter", "value": 3.0,
+			"tags": map[string]any{"env": "prod", "route": "/"},
+		},
+		{
+			"time": "2023-11-14T22:13:20Z", "level": "INFO", "msg": "metric",
+			"name": "latency", "kind": "distribution", "unit": "microseconds",
+			"count": 2.0, "max": 6.0,
+		},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d records. Got %q", len(expected), lines)
+	}
+	for i, line := range lines {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rec, expected[i]) {
+			t.Fatalf("Expected %v. Got %v", expected[i], rec)
+		}
+	}
+
+	// Nothing is logged when the level isn't enabled
+	buf.Reset()
+	r = newSlogReporter(slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelWarn})), slog.LevelInfo)
+	if err := r.ReportContext(context.Background(), testGraphiteSnapshot()); err != nil || buf.Len() != 0 {
+		t.Fatalf("Expected nothing to be logged. Got %v %q", err, buf.String())
+	}
+}
+
+func TestWriterBackendCounterRates(t *testing.T) {
+	reg := metrics.NewRegistry()
+	counter := metrics.NewCounter()
+	reg.Add("requests", counter)
+	snap := metrics.NewRegistrySnapshotWithOptions(metrics.SnapshotOptions{CounterRates: true})
+	snap.Snapshot(reg)
+	counter.Inc(5)
+	time.Sleep(time.Millisecond)
+	snap.Snapshot(reg)
+
+	buf := &bytes.Buffer{}
+	NewWriterBackend(buf).Report(snap)
+	if out := buf.String(); !strings.Contains(out, "name=requests kind=rate") {
+		t.Fatalf("Expected requests to be reported as a rate. Got %q", out)
+	}
+}
@@ -19,11 +19,16 @@ type statHatReporter struct {
 }
 
 func NewStatHatReporter(registry metrics.Registry, interval time.Duration, latched bool, email, source string) *PeriodicReporter {
-	sr := &statHatReporter{
+	return NewPeriodicReporter(registry, interval, false, latched, NewStatHatBackend(email, source))
+}
+
+// NewStatHatBackend returns the reporter used by NewStatHatReporter for use
+// with NewPeriodicReporterWithOptions or a MultiReporter.
+func NewStatHatBackend(email, source string) Reporter {
+	return &statHatReporter{
 		source: source,
 		email:  email,
 	}
-	return NewPeriodicReporter(registry, interval, false, latched, sr)
 }
 
 func (r *statHatReporter) Report(snapshot *metrics.RegistrySnapshot) {
@@ -35,8 +40,16 @@ func (r *statHatReporter) Report(snapshot *metrics.RegistrySnapshot) {
 	}
 	for _, v := range snapshot.Distributions {
 		name := strings.ReplaceAll(v.Name, "/", ".")
-		if err := stathat.PostEZValue(name, r.email, v.Value.Mean()); err != nil {
-			log.Printf("stathat: failed to post metric %s: %s", name, err.Error())
+		if v.Stats == 0 {
+			if err := stathat.PostEZValue(name, r.email, v.Value.Mean()); err != nil {
+				log.Printf("stathat: failed to post metric %s: %s", name, err.Error())
+			}
+			continue
 		}
+		v.Stats.Each(v.Value, func(_ metrics.Stats, stat string, value float64) {
+			if err := stathat.PostEZValue(name+"."+stat, r.email, value); err != nil {
+				log.Printf("stathat: failed to post metric %s.%s: %s", name, stat, err.Error())
+			}
+		})
 	}
 }
@@ -0,0 +1,22 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import "maps"
+
+// mergeTags adds a metric's tags to the reporter's tags with the metric's
+// taking precedence. The result may be one of the arguments so it must not
+// be modified.
+func mergeTags(reporterTags, metricTags map[string]string) map[string]string {
+	if len(metricTags) == 0 {
+		return reporterTags
+	}
+	if len(reporterTags) == 0 {
+		return metricTags
+	}
+	merged := maps.Clone(reporterTags)
+	maps.Copy(merged, metricTags)
+	return merged
+}
@@ -0,0 +1,96 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import (
+	"context"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+type temporalityReporter struct {
+	reporter    Reporter
+	temporality metrics.Temporality
+	// counters and histograms hold the running totals when converting to
+	// cumulative, or the previous totals when converting to deltas
+	counters   map[string]float64
+	histograms map[string]metrics.DistributionValue
+}
+
+type temporalityContextReporter struct {
+	*temporalityReporter
+	reporter ContextReporter
+}
+
+// WithTemporality returns a reporter that converts snapshots to the given
+// temporality before passing them on to r. This lets the backends of a
+// MultiReporter that expect different temporalities share one snapshot.
+// Counters and the count and sum of distributions are converted. The min,
+// max, variance and percentiles of a distribution are left as they are, so
+// they describe the snapshot's interval when converting to cumulative.
+func WithTemporality(r Reporter, temporality metrics.Temporality) Reporter {
+	tr := &temporalityReporter{
+		reporter:    r,
+		temporality: temporality,
+		counters:    make(map[string]float64),
+		histograms:  make(map[string]metrics.DistributionValue),
+	}
+	if cr, ok := r.(ContextReporter); ok {
+		return &temporalityContextReporter{temporalityReporter: tr, reporter: cr}
+	}
+	return tr
+}
+
+func (r *temporalityReporter) Report(snapshot *metrics.RegistrySnapshot) {
+	r.reporter.Report(r.convert(snapshot))
+}
+
+func (r *temporalityContextReporter) ReportContext(ctx context.Context, snapshot *metrics.RegistrySnapshot) error {
+	return r.reporter.ReportContext(ctx, r.convert(snapshot))
+}
+
+func (r *temporalityReporter) convert(snapshot *metrics.RegistrySnapshot) *metrics.RegistrySnapshot {
+	if snapshot.Temporality() == r.temporality {
+		return snapshot
+	}
+	cumulative := r.temporality == metrics.TemporalityCumulative
+	out := metrics.NewRegistrySnapshotWithOptions(metrics.SnapshotOptions{Temporality: r.temporality})
+	out.Start = snapshot.Start
+	out.Time = snapshot.Time
+	out.Values = make([]metrics.NamedValue, len(snapshot.Values))
+	for i, v := range snapshot.Values {
+		if v.Kind == metrics.KindCounter {
+			prev := r.counters[v.Name]
+			if cumulative {
+				v.Value += prev
+				r.counters[v.Name] = v.Value
+			} else {
+				r.counters[v.Name] = v.Value
+				// A total that went backwards was reset
+				if v.Value >= prev {
+					v.Value -= prev
+				}
+			}
+		}
+		out.Values[i] = v
+	}
+	out.Distributions = make([]metrics.NamedDistribution, len(snapshot.Distributions))
+	for i, d := range snapshot.Distributions {
+		prev := r.histograms[d.Name]
+		if cumulative {
+			d.Value.Count += prev.Count
+			d.Value.Sum += prev.Sum
+			r.histograms[d.Name] = d.Value
+		} else {
+			r.histograms[d.Name] = d.Value
+			if d.Value.Count >= prev.Count {
+				d.Value.Count -= prev.Count
+				d.Value.Sum -= prev.Sum
+			}
+		}
+		out.Distributions[i] = d
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package reporter
+
+import (
+	"testing"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+func TestWithTemporality(t *testing.T) {
+	reg := metrics.NewRegistry()
+	counter := metrics.NewCounter()
+	reg.Add("counter", counter)
+	reg.Add("gauge", metrics.NewIntegerGauge())
+	hist := metrics.NewUnbiasedHistogram()
+	reg.Add("hist", hist)
+
+	delta := &chanReporter{ch: make(chan *metrics.RegistrySnapshot, 10)}
+	cumulative := &chanReporter{ch: make(chan *metrics.RegistrySnapshot, 10)}
+	toDelta := WithTemporality(delta, metrics.TemporalityDelta)
+	toCumulative := WithTemporality(cumulative, metrics.TemporalityCumulative)
+	deltaSnap := metrics.NewRegistrySnapshot(false)
+	cumulativeSnap := metrics.NewRegistrySnapshotWithOptions(metrics.SnapshotOptions{Temporality: metrics.TemporalityCumulative})
+
+	for i := 1; i <= 3; i++ {
+		counter.Inc(uint64(i))
+		hist.Update(int64(i))
+		cumulativeSnap.Snapshot(reg)
+		deltaSnap.Snapshot(reg)
+		toDelta.Report(cumulativeSnap)
+		toCumulative.Report(deltaSnap)
+
+		d := <-delta.ch
+		if d.Temporality() != metrics.TemporalityDelta {
+			t.Fatalf("Expected delta temporality. Got %s", d.Temporality())
+		}
+		if v := d.Values[0]; v.Name != "counter" || v.Value != float64(i) {
+			t.Fatalf("Expected counter delta of %d. Got %+v", i, v)
+		}
+		if v := d.Distributions[0].Value; v.Count != 1 || v.Sum != float64(i) {
+			t.Fatalf("Expected histogram count 1 and sum %d. Got %+v", i, v)
+		}
+
+		c := <-cumulative.ch
+		if c.Temporality() != metrics.TemporalityCumulative {
+			t.Fatalf("Expected cumulative temporality. Got %s", c.Temporality())
+		}
+		total := i * (i + 1) / 2
+		if v := c.Values[0]; v.Name != "counter" || v.Value != float64(total) {
+			t.Fatalf("Expected counter total of %d. Got %+v", total, v)
+		}
+		if v := c.Distributions[0].Value; v.Count != uint64(i) || v.Sum != float64(total) {
+			t.Fatalf("Expected histogram count %d and sum %d. Got %+v", i, total, v)
+		}
+	}
+}
@@ -5,32 +5,22 @@
 package reporter
 
 import (
-	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/samuel/go-metrics/metrics"
 )
 
-type writerReporter struct {
-	w io.Writer
-}
-
+// NewWriterReporter returns a reporter that writes a line for every metric
+// to w in the key=value format of slog.TextHandler. The attributes are
+// those described for NewSlogReporter.
 func NewWriterReporter(registry metrics.Registry, interval time.Duration, latched bool, w io.Writer) *PeriodicReporter {
-	return NewPeriodicReporter(registry, interval, false, latched, &writerReporter{w})
+	return NewSlogReporter(registry, interval, latched, slog.New(slog.NewTextHandler(w, nil)))
 }
 
-func (r *writerReporter) Report(snapshot *metrics.RegistrySnapshot) {
-	fmt.Fprintf(r.w, "%+v\n", time.Now())
-	for _, v := range snapshot.Values {
-		if _, err := fmt.Fprintf(r.w, "%s: %f\n", v.Name, v.Value); err != nil {
-			log.Printf("metricswriter: failed to post %s: %s", v.Name, err.Error())
-		}
-	}
-	for _, v := range snapshot.Distributions {
-		if _, err := fmt.Fprintf(r.w, "%s: %+v\n", v.Name, v.Value); err != nil {
-			log.Printf("metricswriter: failed to post %s: %s", v.Name, err.Error())
-		}
-	}
+// NewWriterBackend returns the reporter used by NewWriterReporter for use
+// with NewPeriodicReporterWithOptions or a MultiReporter.
+func NewWriterBackend(w io.Writer) Reporter {
+	return NewSlogBackend(slog.New(slog.NewTextHandler(w, nil)))
 }
@@ -0,0 +1,101 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+// Package slogmetrics counts log/slog records.
+package slogmetrics
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+// levels are the names of the counters of records by level. Levels between
+// the standard ones count as the one below them.
+var levels = [...]struct {
+	level slog.Level
+	name  string
+}{
+	{slog.LevelDebug, "debug"},
+	{slog.LevelInfo, "info"},
+	{slog.LevelWarn, "warn"},
+	{slog.LevelError, "error"},
+}
+
+func levelIndex(level slog.Level) int {
+	for i := len(levels) - 1; i > 0; i-- {
+		if level >= levels[i].level {
+			return i
+		}
+	}
+	return 0
+}
+
+type handler struct {
+	next     slog.Handler
+	registry metrics.Registry
+	groups   []string
+	counters [len(levels)]*metrics.Counter
+}
+
+// NewHandler returns a handler that counts the records passed to next by
+// level and group. Records that next isn't enabled for aren't counted.
+//
+// The counters are records/debug, records/info, records/warn, and
+// records/error. Records of loggers with groups are counted in a scope of
+// the registry named after the groups, so logger.WithGroup("http") counts
+// in http/records/info, and the counters are tagged with the level and
+// the group joined by dots. The counters are exempt from the registry's
+// TTL, if it has one.
+func NewHandler(registry metrics.Registry, next slog.Handler) slog.Handler {
+	return newHandler(registry, next, nil)
+}
+
+func newHandler(registry metrics.Registry, next slog.Handler, groups []string) *handler {
+	h := &handler{next: next, registry: registry, groups: groups}
+	r := registry
+	if len(groups) != 0 {
+		r = registry.Scope(strings.Join(groups, "/"))
+	}
+	for i, l := range levels {
+		tags := map[string]string{"level": l.name}
+		if len(groups) != 0 {
+			tags["group"] = strings.Join(groups, ".")
+		}
+		// The handler keeps the counter so it mustn't expire from the registry
+		c, err := metrics.GetOrAddCounter(r, "records/"+l.name, metrics.WithTags(tags),
+			metrics.WithDescription("Number of "+l.name+" log records"), metrics.WithoutExpiry())
+		if err != nil {
+			// Don't fail logging because of a conflicting metric
+			c = metrics.NewCounter()
+		}
+		h.counters[i] = c
+	}
+	return h
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	h.counters[levelIndex(r.Level)].Inc(1)
+	return h.next.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+	return &c
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	groups := append(h.groups[:len(h.groups):len(h.groups)], name)
+	return newHandler(h.registry, h.next.WithGroup(name), groups)
+}
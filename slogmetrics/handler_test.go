@@ -0,0 +1,92 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package slogmetrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+func TestHandler(t *testing.T) {
+	reg := metrics.NewRegistry()
+	buf := &bytes.Buffer{}
+	logger := slog.New(NewHandler(reg, slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	logger.Debug("not enabled")
+	logger.Info("one")
+	logger.Log(context.Background(), slog.LevelInfo+2, "between info and warn")
+	logger.With("a", 1).Warn("two")
+	logger.WithGroup("http").WithGroup("client").Error("three", "status", 500)
+
+	if n := strings.Count(buf.String(), "\n"); n != 4 {
+		t.Fatalf("Expected 4 lines to be logged. Got %d: %s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "http.client.status=500") {
+		t.Fatalf("Expected groups to be passed on. Got %s", buf.String())
+	}
+
+	counts := make(map[string]uint64)
+	metrics.Visit(reg, func(name string, m any, md metrics.Metadata) error {
+		counts[name] = m.(*metrics.Counter).Count()
+		if name == "http/client/records/error" {
+			if exp := map[string]string{"level": "error", "group": "http.client"}; !reflect.DeepEqual(md.Tags, exp) {
+				t.Fatalf("Expected tags %v. Got %v", exp, md.Tags)
+			}
+		}
+		return nil
+	})
+	expected := map[string]uint64{
+		"records/debug":             0,
+		"records/info":              2,
+		"records/warn":              1,
+		"records/error":             0,
+		"http/records/debug":        0,
+		"http/records/info":         0,
+		"http/records/warn":         0,
+		"http/records/error":        0,
+		"http/client/records/debug": 0,
+		"http/client/records/info":  0,
+		"http/client/records/warn":  0,
+		"http/client/records/error": 1,
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("Expected %v. Got %v", expected, counts)
+	}
+}
+
+func TestHandlerTTL(t *testing.T) {
+	reg := metrics.NewRegistry(metrics.WithTTL(time.Millisecond))
+	logger := slog.New(NewHandler(reg, slog.NewTextHandler(io.Discard, nil)))
+	count := func() uint64 {
+		var n uint64
+		reg.Do(func(name string, m any) error {
+			if name == "records/info" {
+				n = m.(*metrics.Counter).Count()
+			}
+			return nil
+		})
+		return n
+	}
+
+	logger.Info("one")
+	// The first walk notices the record and the second would expire the
+	// idle counter
+	for range 2 {
+		time.Sleep(time.Millisecond * 5)
+		count()
+	}
+	logger.Info("two")
+	if n := count(); n != 2 {
+		t.Fatalf("Expected 2 records after the TTL. Got %d", n)
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package sqlmetrics
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+)
+
+// wrappedConn implements all the optional interfaces of driver.Conn and
+// falls back to what database/sql would do when the underlying connection
+// doesn't.
+type wrappedConn struct {
+	conn driver.Conn
+	in   *instruments
+}
+
+var (
+	_ driver.ConnBeginTx        = &wrappedConn{}
+	_ driver.ConnPrepareContext = &wrappedConn{}
+	_ driver.ExecerContext      = &wrappedConn{}
+	_ driver.QueryerContext     = &wrappedConn{}
+	_ driver.Pinger             = &wrappedConn{}
+	_ driver.SessionResetter    = &wrappedConn{}
+	_ driver.Validator          = &wrappedConn{}
+	_ driver.NamedValueChecker  = &wrappedConn{}
+	_ driver.StmtExecContext    = &wrappedStmt{}
+	_ driver.StmtQueryContext   = &wrappedStmt{}
+	_ driver.NamedValueChecker  = &wrappedStmt{}
+	_ driver.ColumnConverter    = &wrappedStmt{}
+)
+
+func (c *wrappedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *wrappedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	start := time.Now()
+	var s driver.Stmt
+	var err error
+	if cp, ok := c.conn.(driver.ConnPrepareContext); ok {
+		s, err = cp.PrepareContext(ctx, query)
+	} else if err = ctx.Err(); err == nil {
+		s, err = c.conn.Prepare(query)
+	}
+	c.in.record(opPrepare, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedStmt{s, c}, nil
+}
+
+func (c *wrappedConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *wrappedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *wrappedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	var tx driver.Tx
+	var err error
+	if cb, ok := c.conn.(driver.ConnBeginTx); ok {
+		tx, err = cb.BeginTx(ctx, opts)
+	} else if opts.Isolation != 0 {
+		err = errors.New("sql: driver does not support non-default isolation level")
+	} else if opts.ReadOnly {
+		err = errors.New("sql: driver does not support read-only transactions")
+	} else if err = ctx.Err(); err == nil {
+		tx, err = c.conn.Begin()
+	}
+	c.in.record(opBegin, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedTx{tx, c.in}, nil
+}
+
+func (c *wrappedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var res driver.Result
+	var err error
+	if ec, ok := c.conn.(driver.ExecerContext); ok {
+		res, err = ec.ExecContext(ctx, query, args)
+	} else if e, ok := c.conn.(driver.Execer); ok {
+		var values []driver.Value
+		if values, err = namedValuesToValues(args); err == nil {
+			if err = ctx.Err(); err == nil {
+				res, err = e.Exec(query, values)
+			}
+		}
+	} else {
+		err = driver.ErrSkip
+	}
+	c.in.record(opExec, start, err)
+	return res, err
+}
+
+func (c *wrappedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if qc, ok := c.conn.(driver.QueryerContext); ok {
+		rows, err = qc.QueryContext(ctx, query, args)
+	} else if q, ok := c.conn.(driver.Queryer); ok {
+		var values []driver.Value
+		if values, err = namedValuesToValues(args); err == nil {
+			if err = ctx.Err(); err == nil {
+				rows, err = q.Query(query, values)
+			}
+		}
+	} else {
+		err = driver.ErrSkip
+	}
+	c.in.record(opQuery, start, err)
+	return rows, err
+}
+
+func (c *wrappedConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *wrappedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *wrappedConn) IsValid() bool {
+	if v, ok := c.conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *wrappedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := c.conn.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	// Use the default conversion
+	return driver.ErrSkip
+}
+
+type wrappedTx struct {
+	tx driver.Tx
+	in *instruments
+}
+
+func (t *wrappedTx) Commit() error {
+	start := time.Now()
+	err := t.tx.Commit()
+	t.in.record(opCommit, start, err)
+	return err
+}
+
+func (t *wrappedTx) Rollback() error {
+	start := time.Now()
+	err := t.tx.Rollback()
+	t.in.record(opRollback, start, err)
+	return err
+}
+
+type wrappedStmt struct {
+	stmt driver.Stmt
+	conn *wrappedConn
+}
+
+func (s *wrappedStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *wrappedStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *wrappedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := time.Now()
+	res, err := s.stmt.Exec(args)
+	s.conn.in.record(opExec, start, err)
+	return res, err
+}
+
+func (s *wrappedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := s.stmt.Query(args)
+	s.conn.in.record(opQuery, start, err)
+	return rows, err
+}
+
+func (s *wrappedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var res driver.Result
+	var err error
+	if sc, ok := s.stmt.(driver.StmtExecContext); ok {
+		res, err = sc.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValuesToValues(args); err == nil {
+			if err = ctx.Err(); err == nil {
+				res, err = s.stmt.Exec(values)
+			}
+		}
+	}
+	s.conn.in.record(opExec, start, err)
+	return res, err
+}
+
+func (s *wrappedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if sc, ok := s.stmt.(driver.StmtQueryContext); ok {
+		rows, err = sc.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValuesToValues(args); err == nil {
+			if err = ctx.Err(); err == nil {
+				rows, err = s.stmt.Query(values)
+			}
+		}
+	}
+	s.conn.in.record(opQuery, start, err)
+	return rows, err
+}
+
+// CheckNamedValue uses the checker of the statement or else that of the
+// connection since database/sql only looks at the connection's if the
+// statement doesn't have one.
+func (s *wrappedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := s.stmt.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+func (s *wrappedStmt) ColumnConverter(idx int) driver.ValueConverter {
+	if cc, ok := s.stmt.(driver.ColumnConverter); ok {
+		return cc.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
+
+// namedValuesToValues converts arguments for drivers that don't support
+// names.
+func namedValuesToValues(named []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(named))
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		values[i] = nv.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+// Package sqlmetrics instruments database/sql drivers and connection pools.
+package sqlmetrics
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+// Option configures the instrumentation.
+type Option func(c *config)
+
+type config struct {
+	newHistogram func() metrics.Histogram
+}
+
+// WithHistogram sets the factory of the latency histograms. The default is
+// metrics.NewDefaultBucketedHistogram.
+func WithHistogram(factory func() metrics.Histogram) Option {
+	return func(c *config) {
+		c.newHistogram = factory
+	}
+}
+
+type op int
+
+const (
+	opExec op = iota
+	opQuery
+	opPrepare
+	opBegin
+	opCommit
+	opRollback
+	numOps
+)
+
+var opNames = [numOps]string{"exec", "query", "prepare", "begin", "commit", "rollback"}
+
+type opMetrics struct {
+	latency metrics.Histogram
+	errors  *metrics.Counter
+}
+
+// instruments are the metrics shared by everything created by a wrapped
+// driver or connector.
+type instruments struct {
+	ops [numOps]opMetrics
+}
+
+func newInstruments(registry metrics.Registry, opts []Option) *instruments {
+	c := &config{newHistogram: metrics.NewDefaultBucketedHistogram}
+	for _, opt := range opts {
+		opt(c)
+	}
+	in := &instruments{}
+	for i, name := range opNames {
+		r := registry.Scope(name)
+		tags := metrics.WithTags(map[string]string{"operation": name})
+		// The metrics are kept for as long as the driver so they mustn't
+		// expire from the registry
+		h, err := metrics.GetOrAddHistogram(r, "latency", c.newHistogram, tags, metrics.WithoutExpiry(),
+			metrics.WithDescription("Time taken by "+name+" calls"), metrics.WithUnit(metrics.UnitMicroseconds))
+		if err != nil {
+			h = c.newHistogram()
+		}
+		errs, err := metrics.GetOrAddCounter(r, "errors", tags, metrics.WithoutExpiry(),
+			metrics.WithDescription("Number of "+name+" calls that failed"))
+		if err != nil {
+			errs = metrics.NewCounter()
+		}
+		in.ops[i] = opMetrics{h, errs}
+	}
+	return in
+}
+
+// record records a call that started at start. Calls that return
+// driver.ErrSkip aren't recorded since database/sql tries another way.
+func (in *instruments) record(o op, start time.Time, err error) {
+	if errors.Is(err, driver.ErrSkip) {
+		return
+	}
+	in.ops[o].latency.Update(time.Since(start).Microseconds())
+	if err != nil {
+		in.ops[o].errors.Inc(1)
+	}
+}
+
+// WrapDriver returns a driver that records the latency and errors of the
+// connections it opens. Register it with sql.Register under a new name to
+// use it with sql.Open.
+//
+// For each operation (exec, query, prepare, begin, commit, and rollback)
+// the registry gets a latency histogram in microseconds and an errors
+// counter, for instance exec/latency and exec/errors, tagged with the
+// operation. Executing and querying prepared statements count as exec and
+// query. Query latency is the time until the rows are returned, not until
+// they've been read. The metrics are exempt from the registry's TTL, if it
+// has one.
+func WrapDriver(d driver.Driver, registry metrics.Registry, opts ...Option) driver.Driver {
+	return &wrappedDriver{d, newInstruments(registry, opts)}
+}
+
+// WrapConnector is like WrapDriver for use with sql.OpenDB.
+func WrapConnector(c driver.Connector, registry metrics.Registry, opts ...Option) driver.Connector {
+	in := newInstruments(registry, opts)
+	return &wrappedConnector{c, &wrappedDriver{c.Driver(), in}, in}
+}
+
+type wrappedDriver struct {
+	driver.Driver
+	in *instruments
+}
+
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedConn{c, d.in}, nil
+}
+
+func (d *wrappedDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.Driver.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &wrappedConnector{c, d, d.in}, nil
+	}
+	return &wrappedConnector{dsnConnector{name, d.Driver}, d, d.in}, nil
+}
+
+// dsnConnector opens connections of a driver that isn't a DriverContext
+// like database/sql does.
+type dsnConnector struct {
+	name   string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.name)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type wrappedConnector struct {
+	driver.Connector
+	driver *wrappedDriver
+	in     *instruments
+}
+
+func (c *wrappedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedConn{conn, c.in}, nil
+}
+
+func (c *wrappedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close closes the underlying connector if it's an io.Closer which
+// sql.DB.Close relies on.
+func (c *wrappedConnector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
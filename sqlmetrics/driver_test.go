@@ -0,0 +1,264 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package sqlmetrics
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+var errFake = errors.New("fake error")
+
+// fakeDriver is an in-memory driver whose connections fail any statement
+// containing "fail". If legacy is set its connections only implement
+// driver.Conn so database/sql has to prepare statements.
+type fakeDriver struct {
+	legacy bool
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	c := &fakeConn{}
+	if d.legacy {
+		return legacyConn{c}, nil
+	}
+	return c, nil
+}
+
+type fakeConn struct{}
+
+type legacyConn struct {
+	driver.Conn
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if query == "fail" {
+		return nil, errFake
+	}
+	return &fakeStmt{query}, nil
+}
+
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return (&fakeStmt{query}).Exec(nil)
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return (&fakeStmt{query}).Query(nil)
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return errFake }
+
+type fakeStmt struct {
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if s.query == "exec fail" {
+		return nil, errFake
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.query == "query fail" {
+		return nil, errFake
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct {
+	done bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"n"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(42)
+	return nil
+}
+
+type opCounts struct {
+	calls  uint64
+	errors uint64
+}
+
+func readOps(t *testing.T, reg metrics.Registry) map[string]opCounts {
+	t.Helper()
+	ops := make(map[string]opCounts)
+	reg.Do(func(name string, m any) error {
+		dir := path.Dir(name)
+		c := ops[dir]
+		switch path.Base(name) {
+		case "latency":
+			c.calls = m.(metrics.Histogram).Distribution().Count
+		case "errors":
+			c.errors = m.(*metrics.Counter).Count()
+		}
+		ops[dir] = c
+		return nil
+	})
+	return ops
+}
+
+func exercise(t *testing.T, db *sql.DB) {
+	t.Helper()
+	if _, err := db.Exec("insert"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("exec fail"); err != errFake {
+		t.Fatalf("Expected %v. Got %v", errFake, err)
+	}
+	var n int
+	if err := db.QueryRow("select").Scan(&n); err != nil || n != 42 {
+		t.Fatalf("Expected 42. Got %d %v", n, err)
+	}
+	if _, err := db.Query("query fail"); err != errFake {
+		t.Fatalf("Expected %v. Got %v", errFake, err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != errFake {
+		t.Fatalf("Expected %v. Got %v", errFake, err)
+	}
+	stmt, err := db.Prepare("select")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stmt.QueryRow().Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+	if _, err := db.Prepare("fail"); err != errFake {
+		t.Fatalf("Expected %v. Got %v", errFake, err)
+	}
+}
+
+func TestWrapConnector(t *testing.T) {
+	reg := metrics.NewRegistry()
+	db := sql.OpenDB(WrapConnector(dsnConnector{"", &fakeDriver{}}, reg))
+	defer db.Close()
+	exercise(t, db)
+
+	expected := map[string]opCounts{
+		"exec":     {2, 1},
+		"query":    {3, 1},
+		"prepare":  {2, 1},
+		"begin":    {2, 0},
+		"commit":   {1, 0},
+		"rollback": {1, 1},
+	}
+	if ops := readOps(t, reg); len(ops) != len(expected) {
+		t.Fatalf("Expected %+v. Got %+v", expected, ops)
+	} else {
+		for op, exp := range expected {
+			if ops[op] != exp {
+				t.Fatalf("Expected %+v for %s. Got %+v", exp, op, ops[op])
+			}
+		}
+	}
+}
+
+func TestWrapConnectorTTL(t *testing.T) {
+	reg := metrics.NewRegistry(metrics.WithTTL(time.Millisecond))
+	db := sql.OpenDB(WrapConnector(dsnConnector{"", &fakeDriver{}}, reg))
+	defer db.Close()
+	if _, err := db.Exec("insert"); err != nil {
+		t.Fatal(err)
+	}
+	// The first walk notices the call and the second would expire the idle
+	// metrics
+	for range 2 {
+		time.Sleep(time.Millisecond * 5)
+		readOps(t, reg)
+	}
+	if _, err := db.Exec("insert"); err != nil {
+		t.Fatal(err)
+	}
+	if exp, ops := (opCounts{2, 0}), readOps(t, reg); ops["exec"] != exp {
+		t.Fatalf("Expected %+v for exec after the TTL. Got %+v", exp, ops["exec"])
+	}
+}
+
+func TestWrapDriverLegacy(t *testing.T) {
+	reg := metrics.NewRegistry()
+	c, err := WrapDriver(&fakeDriver{legacy: true}, reg).(driver.DriverContext).OpenConnector("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	exercise(t, db)
+
+	// Without ExecerContext and QueryerContext database/sql prepares every
+	// statement. The skipped direct calls aren't recorded.
+	ops := readOps(t, reg)
+	if exp := (opCounts{2, 1}); ops["exec"] != exp {
+		t.Fatalf("Expected %+v for exec. Got %+v", exp, ops["exec"])
+	}
+	if exp := (opCounts{6, 1}); ops["prepare"] != exp {
+		t.Fatalf("Expected %+v for prepare. Got %+v", exp, ops["prepare"])
+	}
+}
+
+func TestStatsCollection(t *testing.T) {
+	db := sql.OpenDB(WrapConnector(dsnConnector{"", &fakeDriver{}}, metrics.NewRegistry()))
+	defer db.Close()
+	db.SetMaxOpenConns(3)
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := metrics.NewRegistry()
+	reg.Add("db", NewStatsCollection(db))
+	values := make(map[string]any)
+	reg.Do(func(name string, m any) error {
+		values[name] = m
+		return nil
+	})
+	conn.Close()
+	expected := map[string]any{
+		"db/connections/max_open":  metrics.GaugeValue(3),
+		"db/connections/open":      metrics.GaugeValue(1),
+		"db/connections/in_use":    metrics.GaugeValue(1),
+		"db/connections/idle":      metrics.GaugeValue(0),
+		"db/wait/count":            metrics.CounterValue(0),
+		"db/wait/duration_seconds": metrics.Float64CounterValue(0),
+	}
+	for name, exp := range expected {
+		if values[name] != exp {
+			t.Fatalf("Expected %v for %s. Got %v", exp, name, values[name])
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2012 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package sqlmetrics
+
+import (
+	"database/sql"
+
+	"github.com/samuel/go-metrics/metrics"
+)
+
+// StatsCollection is a Collection of the connection pool statistics of a
+// sql.DB. They're read whenever the registry it's added to is walked, such
+// as by a periodic reporter.
+type StatsCollection struct {
+	db *sql.DB
+}
+
+// NewStatsCollection returns a collection of the pool statistics of db:
+//
+//	connections/max_open             maximum number of open connections (gauge)
+//	connections/open                 open connections (gauge)
+//	connections/in_use               connections in use (gauge)
+//	connections/idle                 idle connections (gauge)
+//	wait/count                       times a connection had to be waited for (counter)
+//	wait/duration_seconds            total time spent waiting (counter)
+//	closed/max_idle                  connections closed due to SetMaxIdleConns (counter)
+//	closed/max_idle_time             connections closed due to SetConnMaxIdleTime (counter)
+//	closed/max_lifetime              connections closed due to SetConnMaxLifetime (counter)
+//
+// The wait duration is a metrics.Float64CounterValue since it's cumulative
+// but not a whole number.
+func NewStatsCollection(db *sql.DB) *StatsCollection {
+	return &StatsCollection{db}
+}
+
+func (c *StatsCollection) Metrics() map[string]any {
+	s := c.db.Stats()
+	return map[string]any{
+		"connections/max_open":  metrics.GaugeValue(s.MaxOpenConnections),
+		"connections/open":      metrics.GaugeValue(s.OpenConnections),
+		"connections/in_use":    metrics.GaugeValue(s.InUse),
+		"connections/idle":      metrics.GaugeValue(s.Idle),
+		"wait/count":            metrics.CounterValue(s.WaitCount),
+		"wait/duration_seconds": metrics.Float64CounterValue(s.WaitDuration.Seconds()),
+		"closed/max_idle":       metrics.CounterValue(s.MaxIdleClosed),
+		"closed/max_idle_time":  metrics.CounterValue(s.MaxIdleTimeClosed),
+		"closed/max_lifetime":   metrics.CounterValue(s.MaxLifetimeClosed),
+	}
+}